@@ -0,0 +1,558 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"log"
+	"strconv"
+)
+
+// fixedTypes are written as is by binary.Write, rune and byte are aliases of int32 and uint8
+var fixedTypes = map[string]bool{
+	"int8": true, "int16": true, "int32": true, "int64": true,
+	"uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"byte": true, "rune": true,
+	"float32": true, "float64": true,
+	"bool": true,
+}
+
+// codec generates Pack and Unpack of binpack structs, one struct at a time
+type codec struct {
+	structs map[string]*binpackStruct
+	imports map[string]bool
+
+	// state of the struct being generated
+	order   string
+	tmpVars int
+}
+
+func newCodec(structs []*binpackStruct) *codec {
+	c := &codec{
+		structs: make(map[string]*binpackStruct),
+		imports: make(map[string]bool),
+	}
+	for _, st := range structs {
+		c.structs[st.Name] = st
+	}
+	return c
+}
+
+func (c *codec) generateStruct(out io.Writer, st *binpackStruct) {
+	c.order = st.Order
+	for _, f := range st.Fields {
+		c.checkType(st.Name+"."+f.Name, f.Type)
+	}
+
+	if st.Versioned {
+		c.generateVersioned(out, st)
+		return
+	}
+
+	fmt.Fprint(out, `
+func (in *`+st.Name+`) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	if err := in.packTo(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (in *`+st.Name+`) Unpack(data []byte) error {
+	return in.unpackFrom(bytes.NewReader(data))
+}
+`)
+
+	c.tmpVars = 0
+	fmt.Fprint(out, `
+func (in *`+st.Name+`) packTo(w *bytes.Buffer) error {`)
+	for _, f := range st.Fields {
+		fmt.Printf("\tgenerating code for field %s.%s\n", st.Name, f.Name)
+		fmt.Fprint(out, `
+	// `+f.Name+`
+`+c.packCode("in."+f.Name, f.Type, fieldFail(f.Name)))
+	}
+	fmt.Fprint(out, `	return nil
+}
+`)
+
+	c.tmpVars = 0
+	fmt.Fprint(out, `
+func (in *`+st.Name+`) unpackFrom(r *bytes.Reader) error {`)
+	for _, f := range st.Fields {
+		fmt.Fprint(out, `
+	// `+f.Name+`
+`+c.unpackCode("in."+f.Name, f.Type, fieldFail(f.Name)))
+	}
+	fmt.Fprint(out, `	return nil
+}
+`)
+}
+
+// generateVersioned writes structs with numbered fields as the header and a message,
+// the message is its length and fields, a field is its number, its length and the value.
+// Readers skip fields with numbers they dont know, so older and newer versions read each other's data
+func (c *codec) generateVersioned(out io.Writer, st *binpackStruct) {
+	c.imports["strconv"] = true
+	fmt.Fprint(out, `
+func (in *`+st.Name+`) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	w.Write(binpackHeader)
+	if err := in.packTo(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (in *`+st.Name+`) Unpack(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := binpackReadHeader(r); err != nil {
+		return err
+	}
+	if err := in.unpackFrom(r); err != nil {
+		return err
+	}
+	return binpackCheckEnd(r, "message")
+}
+`)
+
+	c.tmpVars = 0
+	fmt.Fprint(out, `
+func (in *`+st.Name+`) packTo(w *bytes.Buffer) error {
+	msg := binpackBeginLen(w)`)
+	if len(st.Fields) > 0 {
+		fmt.Fprint(out, `
+	var field int`)
+	}
+	for _, f := range st.Fields {
+		fmt.Printf("\tgenerating code for field %s.%s = %d\n", st.Name, f.Name, f.Number)
+		fail := fieldFail(f.Name)
+		fmt.Fprint(out, `
+
+	// `+f.Name+`
+	field = binpackBeginField(w, `+c.order+`, `+strconv.Itoa(f.Number)+`)
+`+c.packCode("in."+f.Name, f.Type, fail)+`	if err := binpackEndLen(w, `+c.order+`, field); err != nil {
+		`+fail+`
+	}`)
+	}
+	fmt.Fprint(out, `
+	return binpackEndLen(w, `+c.order+`, msg)
+}
+`)
+
+	c.tmpVars = 0
+	fmt.Fprint(out, `
+func (in *`+st.Name+`) unpackFrom(data *bytes.Reader) error {
+	msg, err := binpackReadMessage(data, `+c.order+`)
+	if err != nil {
+		return err
+	}
+`)
+	for _, f := range st.Fields {
+		fmt.Fprint(out, `	in.`+f.Name+` = `+c.zeroValue(f.Type)+`
+`)
+	}
+	fmt.Fprint(out, `
+	for msg.Len() > 0 {
+		number, r, err := binpackReadField(msg, `+c.order+`)
+		if err != nil {
+			return err
+		}
+		switch number {`)
+	for _, f := range st.Fields {
+		fmt.Fprint(out, `
+		case `+strconv.Itoa(f.Number)+`:
+			// `+f.Name+`
+`+c.unpackCode("in."+f.Name, f.Type, fieldFail(f.Name)))
+	}
+	fmt.Fprint(out, `
+		default:
+			// a field of a newer version
+			continue
+		}
+		if err := binpackCheckEnd(r, "field "+strconv.Itoa(int(number))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+`)
+}
+
+// zeroValue is what unpackFrom sets fields missing in data to
+func (c *codec) zeroValue(t ast.Expr) string {
+	ident, ok := t.(*ast.Ident)
+	if !ok {
+		return "nil"
+	}
+	switch {
+	case ident.Name == "string":
+		return `""`
+	case ident.Name == "bool":
+		return "false"
+	case c.structs[ident.Name] != nil:
+		return ident.Name + "{}"
+	}
+	return "0"
+}
+
+// fieldFail is the statement returning an error of the field, errors of nested structs get the field in front
+func fieldFail(fieldName string) string {
+	return `return binpackFieldError(` + strconv.Quote(fieldName) + `, err)`
+}
+
+func (c *codec) tmpVar() string {
+	c.tmpVars++
+	return strconv.Itoa(c.tmpVars)
+}
+
+// checkType fails generation on types the codec cant pack
+func (c *codec) checkType(field string, t ast.Expr) {
+	switch t := t.(type) {
+	case *ast.Ident:
+		if fixedTypes[t.Name] || t.Name == "int" || t.Name == "uint" || t.Name == "string" {
+			return
+		}
+		if _, isBinpack := c.structs[t.Name]; isBinpack {
+			return
+		}
+	case *ast.ArrayType:
+		if t.Len == nil {
+			c.checkType(field, t.Elt)
+			return
+		}
+	case *ast.MapType:
+		key, ok := t.Key.(*ast.Ident)
+		if !ok || key.Name == "bool" || !(fixedTypes[key.Name] || key.Name == "int" || key.Name == "uint" || key.Name == "string") {
+			log.Fatalf("%s: map keys must be numbers or strings", field)
+		}
+		c.checkType(field, t.Value)
+		return
+	}
+	log.Fatalf("%s: unsupported type %s", field, types.ExprString(t))
+}
+
+func isByteSlice(t *ast.ArrayType) bool {
+	elt, ok := t.Elt.(*ast.Ident)
+	return ok && t.Len == nil && (elt.Name == "byte" || elt.Name == "uint8")
+}
+
+// packCode returns code writing expr of type t to w, fail is the statement run on error
+func (c *codec) packCode(expr string, t ast.Expr, fail string) string {
+	switch t := t.(type) {
+	case *ast.Ident:
+		switch {
+		case fixedTypes[t.Name]:
+			return `	binary.Write(w, ` + c.order + `, ` + expr + `)
+`
+		case t.Name == "int":
+			return `	if err := binpackWriteInt(w, ` + c.order + `, ` + expr + `); err != nil {
+		` + fail + `
+	}
+`
+		case t.Name == "uint":
+			return `	if err := binpackWriteUint(w, ` + c.order + `, ` + expr + `); err != nil {
+		` + fail + `
+	}
+`
+		case t.Name == "string":
+			return `	if err := binpackWriteString(w, ` + c.order + `, ` + expr + `); err != nil {
+		` + fail + `
+	}
+`
+		default:
+			return `	if err := ` + expr + `.packTo(w); err != nil {
+		` + fail + `
+	}
+`
+		}
+
+	case *ast.ArrayType:
+		if isByteSlice(t) {
+			return `	if err := binpackWriteBytes(w, ` + c.order + `, ` + expr + `); err != nil {
+		` + fail + `
+	}
+`
+		}
+		v := "v" + c.tmpVar()
+		return `	if err := binpackWriteLen(w, ` + c.order + `, len(` + expr + `)); err != nil {
+		` + fail + `
+	}
+	for _, ` + v + ` := range ` + expr + ` {
+` + c.packCode(v, t.Elt, fail) + `	}
+`
+
+	case *ast.MapType:
+		// keys are sorted, so the same map is always packed the same way
+		c.imports["sort"] = true
+		n := c.tmpVar()
+		k, keys, v := "k"+n, "keys"+n, "v"+n
+		return `	if err := binpackWriteLen(w, ` + c.order + `, len(` + expr + `)); err != nil {
+		` + fail + `
+	}
+	` + keys + ` := make([]` + types.ExprString(t.Key) + `, 0, len(` + expr + `))
+	for ` + k + ` := range ` + expr + ` {
+		` + keys + ` = append(` + keys + `, ` + k + `)
+	}
+	sort.Slice(` + keys + `, func(i, j int) bool { return ` + keys + `[i] < ` + keys + `[j] })
+	for _, ` + k + ` := range ` + keys + ` {
+		` + v + ` := ` + expr + `[` + k + `]
+` + c.packCode(k, t.Key, fail) + c.packCode(v, t.Value, fail) + `	}
+`
+	}
+	panic("unchecked type " + types.ExprString(t))
+}
+
+// unpackCode returns code reading target of type t from r, target must be addressable
+func (c *codec) unpackCode(target string, t ast.Expr, fail string) string {
+	switch t := t.(type) {
+	case *ast.Ident:
+		switch {
+		case fixedTypes[t.Name]:
+			return `	if err := binpackRead(r, ` + c.order + `, &` + target + `); err != nil {
+		` + fail + `
+	}
+`
+		case t.Name == "int":
+			return `	if err := binpackReadInt(r, ` + c.order + `, &` + target + `); err != nil {
+		` + fail + `
+	}
+`
+		case t.Name == "uint":
+			return `	if err := binpackReadUint(r, ` + c.order + `, &` + target + `); err != nil {
+		` + fail + `
+	}
+`
+		case t.Name == "string":
+			return `	if err := binpackReadString(r, ` + c.order + `, &` + target + `); err != nil {
+		` + fail + `
+	}
+`
+		default:
+			return `	if err := ` + target + `.unpackFrom(r); err != nil {
+		` + fail + `
+	}
+`
+		}
+
+	case *ast.ArrayType:
+		if isByteSlice(t) {
+			return `	if err := binpackReadBytes(r, ` + c.order + `, &` + target + `); err != nil {
+		` + fail + `
+	}
+`
+		}
+		num := c.tmpVar()
+		n, i := "n"+num, "i"+num
+		return `	` + n + `, err := binpackReadLen(r, ` + c.order + `)
+	if err != nil {
+		` + fail + `
+	}
+	` + target + ` = nil
+	if ` + n + ` > 0 {
+		` + target + ` = make([]` + types.ExprString(t.Elt) + `, ` + n + `)
+	}
+	for ` + i + ` := range ` + target + ` {
+` + c.unpackCode(target+`[`+i+`]`, t.Elt, fail) + `	}
+`
+
+	case *ast.MapType:
+		num := c.tmpVar()
+		n, i, k, v := "n"+num, "i"+num, "k"+num, "v"+num
+		return `	` + n + `, err := binpackReadLen(r, ` + c.order + `)
+	if err != nil {
+		` + fail + `
+	}
+	` + target + ` = nil
+	if ` + n + ` > 0 {
+		` + target + ` = make(` + types.ExprString(t) + `, ` + n + `)
+	}
+	for ` + i + ` := 0; ` + i + ` < ` + n + `; ` + i + `++ {
+		var ` + k + ` ` + types.ExprString(t.Key) + `
+` + c.unpackCode(k, t.Key, fail) + `		var ` + v + ` ` + types.ExprString(t.Value) + `
+` + c.unpackCode(v, t.Value, fail) + `		` + target + `[` + k + `] = ` + v + `
+	}
+`
+	}
+	panic("unchecked type " + types.ExprString(t))
+}
+
+var runtimeSrc = `
+// versioned data starts with the magic and the version of the format
+var binpackHeader = []byte{'B', 'P', 1}
+
+func binpackReadHeader(r *bytes.Reader) error {
+	header := make([]byte, len(binpackHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("header: %w", io.ErrUnexpectedEOF)
+	}
+	if header[0] != binpackHeader[0] || header[1] != binpackHeader[1] {
+		return fmt.Errorf("header: not a binpack message")
+	}
+	if header[2] != binpackHeader[2] {
+		return fmt.Errorf("header: unsupported format version %d", header[2])
+	}
+	return nil
+}
+
+// binpackBeginLen reserves room for the uint32 length of what is written next and returns where it is
+func binpackBeginLen(w *bytes.Buffer) int {
+	start := w.Len()
+	w.Write([]byte{0, 0, 0, 0})
+	return start
+}
+
+func binpackEndLen(w *bytes.Buffer, order binary.ByteOrder, start int) error {
+	n := w.Len() - start - 4
+	if uint64(n) > math.MaxUint32 {
+		return fmt.Errorf("length %d overflows uint32", n)
+	}
+	order.PutUint32(w.Bytes()[start:], uint32(n))
+	return nil
+}
+
+func binpackBeginField(w *bytes.Buffer, order binary.ByteOrder, number uint16) int {
+	binary.Write(w, order, number)
+	return binpackBeginLen(w)
+}
+
+// binpackReadMessage returns a reader of the length prefixed message, so fields cant read past it
+func binpackReadMessage(r *bytes.Reader, order binary.ByteOrder) (*bytes.Reader, error) {
+	var msg []byte
+	if err := binpackReadBytes(r, order, &msg); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(msg), nil
+}
+
+func binpackReadField(msg *bytes.Reader, order binary.ByteOrder) (uint16, *bytes.Reader, error) {
+	var number uint16
+	if err := binpackRead(msg, order, &number); err != nil {
+		return 0, nil, err
+	}
+	r, err := binpackReadMessage(msg, order)
+	if err != nil {
+		return 0, nil, fmt.Errorf("field %d: %w", number, err)
+	}
+	return number, r, nil
+}
+
+// binpackCheckEnd fails if data is not read to the end, a field with extra bytes has changed its type
+func binpackCheckEnd(r *bytes.Reader, what string) error {
+	if r.Len() > 0 {
+		return fmt.Errorf("%s: %d extra bytes", what, r.Len())
+	}
+	return nil
+}
+
+// binpackFieldError says which field could not be packed or unpacked
+func binpackFieldError(field string, err error) error {
+	return fmt.Errorf("%s: %w", field, err)
+}
+
+// binpackRead reads a fixed size value, data that ends before the value is io.ErrUnexpectedEOF
+func binpackRead(r *bytes.Reader, order binary.ByteOrder, data interface{}) error {
+	err := binary.Read(r, order, data)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// int and uint are packed in 32 bits, as the first version of the format did
+func binpackWriteInt(w *bytes.Buffer, order binary.ByteOrder, v int) error {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return fmt.Errorf("%d overflows int32", v)
+	}
+	binary.Write(w, order, int32(v))
+	return nil
+}
+
+func binpackReadInt(r *bytes.Reader, order binary.ByteOrder, v *int) error {
+	var raw int32
+	if err := binpackRead(r, order, &raw); err != nil {
+		return err
+	}
+	*v = int(raw)
+	return nil
+}
+
+func binpackWriteUint(w *bytes.Buffer, order binary.ByteOrder, v uint) error {
+	if uint64(v) > math.MaxUint32 {
+		return fmt.Errorf("%d overflows uint32", v)
+	}
+	binary.Write(w, order, uint32(v))
+	return nil
+}
+
+func binpackReadUint(r *bytes.Reader, order binary.ByteOrder, v *uint) error {
+	var raw uint32
+	if err := binpackRead(r, order, &raw); err != nil {
+		return err
+	}
+	*v = uint(raw)
+	return nil
+}
+
+// strings, slices and maps start with the uint32 number of their elements
+func binpackWriteLen(w *bytes.Buffer, order binary.ByteOrder, n int) error {
+	if uint64(n) > math.MaxUint32 {
+		return fmt.Errorf("length %d overflows uint32", n)
+	}
+	binary.Write(w, order, uint32(n))
+	return nil
+}
+
+// binpackReadLen checks the length against the rest of data, every element takes at least a byte,
+// so broken data cant make Unpack allocate more than data is
+func binpackReadLen(r *bytes.Reader, order binary.ByteOrder) (int, error) {
+	var n uint32
+	if err := binpackRead(r, order, &n); err != nil {
+		return 0, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func binpackWriteString(w *bytes.Buffer, order binary.ByteOrder, s string) error {
+	if err := binpackWriteLen(w, order, len(s)); err != nil {
+		return err
+	}
+	w.WriteString(s)
+	return nil
+}
+
+func binpackReadString(r *bytes.Reader, order binary.ByteOrder, s *string) error {
+	var raw []byte
+	if err := binpackReadBytes(r, order, &raw); err != nil {
+		return err
+	}
+	*s = string(raw)
+	return nil
+}
+
+func binpackWriteBytes(w *bytes.Buffer, order binary.ByteOrder, data []byte) error {
+	if err := binpackWriteLen(w, order, len(data)); err != nil {
+		return err
+	}
+	w.Write(data)
+	return nil
+}
+
+// binpackReadBytes reads empty slices as nil, as all slices and maps are
+func binpackReadBytes(r *bytes.Reader, order binary.ByteOrder, data *[]byte) error {
+	n, err := binpackReadLen(r, order)
+	if err != nil {
+		return err
+	}
+	*data = nil
+	if n > 0 {
+		*data = make([]byte, n)
+		r.Read(*data)
+	}
+	return nil
+}
+`
@@ -1,128 +1,275 @@
-// go build gen/* && ./codegen.exe pack/unpack.go  pack/marshaller.go
+// go build -o codegen.exe ./gen && ./codegen.exe -schema pack/binpack_schema.json -compat pack/marshaller_compat_test.go -fuzz pack/marshaller_fuzz_test.go pack/unpack.go pack/marshaller.go
 // go run pack/*
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
-	"text/template"
 )
 
-type tpl struct {
-	FieldName string
+const binpackMark = "// cgen: binpack"
+
+// binpackStruct is a struct marked with "// cgen: binpack", options go after the mark: "// cgen: binpack endian=big version=2"
+type binpackStruct struct {
+	Name string
+	// binary.LittleEndian or binary.BigEndian
+	Order  string
+	Fields []binpackField
+	// structs with numbered fields are packed in the versioned format, version is of their fields
+	Versioned bool
+	Version   int
 }
 
-var (
-	intTpl = template.Must(template.New("intTpl").Parse(`
-	// {{.FieldName}}
-	var {{.FieldName}}Raw uint32
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}Raw)
-	in.{{.FieldName}} = int({{.FieldName}}Raw)
-`))
-
-	strTpl = template.Must(template.New("strTpl").Parse(`
-	// {{.FieldName}}
-	var {{.FieldName}}LenRaw uint32
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}LenRaw)
-	{{.FieldName}}Raw := make([]byte, {{.FieldName}}LenRaw)
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}Raw)
-	in.{{.FieldName}} = string({{.FieldName}}Raw)
-`))
-)
+type binpackField struct {
+	Name string
+	Type ast.Expr
+	// number from the cgen tag: `+"`"+`cgen:"1"`+"`"+`, fields of versioned structs are found in data by it
+	Number int
+}
 
 func main() {
+	endian := flag.String("endian", "little", "byte order of structs without the endian option: little or big")
+	schemaPath := flag.String("schema", "", "json file with the history of versioned structs, new versions are added to it")
+	compatPath := flag.String("compat", "", "test file to write compatibility tests of recorded versions to, needs -schema")
+	fuzzPath := flag.String("fuzz", "", "test file to write round trip property tests and fuzz targets to")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] in.go out.go\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 || (*compatPath != "" && *schemaPath == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	defaultOrder, err := byteOrder(*endian)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+	node, err := parser.ParseFile(fset, flag.Arg(0), nil, parser.ParseComments)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	out, _ := os.Create(os.Args[2])
+	structs := findBinpackStructs(node, defaultOrder)
+	c := newCodec(structs)
+
+	schema := make(binpackSchema)
+	if *schemaPath != "" {
+		schema = loadSchema(*schemaPath)
+		for _, st := range structs {
+			if st.Versioned {
+				schema.record(st)
+			}
+		}
+	}
+
+	body := new(bytes.Buffer)
+	for _, st := range structs {
+		fmt.Printf("process struct %s\n", st.Name)
+		fmt.Printf("\tgenerating Pack and Unpack methods\n")
+		c.generateStruct(body, st)
+	}
+
+	src := new(bytes.Buffer)
+	fmt.Fprintln(src, `// Code generated by codegen. DO NOT EDIT.`)
+	fmt.Fprintln(src) // empty line
+	fmt.Fprintln(src, `package `+node.Name.Name)
+	fmt.Fprintln(src) // empty line
+	fmt.Fprintln(src, `import (`)
+	for _, pkg := range c.packages() {
+		fmt.Fprintln(src, `	"`+pkg+`"`)
+	}
+	fmt.Fprintln(src, `)`)
+	src.Write(body.Bytes())
+	fmt.Fprint(src, runtimeSrc)
+	writeSource(flag.Arg(1), src.Bytes())
+
+	if *schemaPath != "" {
+		schema.save(*schemaPath)
+	}
+
+	if *compatPath != "" {
+		generateCompatTests(*compatPath, node.Name.Name, structs, schema)
+	}
+	if *fuzzPath != "" {
+		generateFuzzTests(*fuzzPath, node.Name.Name, structs)
+	}
+}
+
+// writeSource writes formatted code, broken code is written as is, so it can be looked at
+func writeSource(path string, src []byte) {
+	formatted, fmtErr := format.Source(src)
+	if fmtErr != nil {
+		formatted = src
+	}
+	if err := ioutil.WriteFile(path, formatted, 0644); err != nil {
+		log.Fatal(err)
+	}
+	if fmtErr != nil {
+		log.Fatalf("generated code of %s is broken: %s", path, fmtErr)
+	}
+}
 
-	fmt.Fprintln(out, `package `+node.Name.Name)
-	fmt.Fprintln(out) // empty line
-	fmt.Fprintln(out, `import "encoding/binary"`)
-	fmt.Fprintln(out, `import "bytes"`)
-	fmt.Fprintln(out) // empty line
+func byteOrder(endian string) (string, error) {
+	switch endian {
+	case "little":
+		return "binary.LittleEndian", nil
+	case "big":
+		return "binary.BigEndian", nil
+	}
+	return "", fmt.Errorf("unknown endian %q, must be little or big", endian)
+}
 
+// findBinpackStructs returns marked structs of the file in the order they are declared
+func findBinpackStructs(node *ast.File, defaultOrder string) []*binpackStruct {
+	var structs []*binpackStruct
+	var err error
 	for _, f := range node.Decls {
 		g, ok := f.(*ast.GenDecl)
 		if !ok {
-			fmt.Printf("SKIP %#T is not *ast.GenDecl\n", f)
+			fmt.Printf("SKIP %T is not *ast.GenDecl\n", f)
 			continue
 		}
-	SPECS_LOOP:
 		for _, spec := range g.Specs {
 			currType, ok := spec.(*ast.TypeSpec)
 			if !ok {
-				fmt.Printf("SKIP %#T is not ast.TypeSpec\n", spec)
+				fmt.Printf("SKIP %T is not ast.TypeSpec\n", spec)
 				continue
 			}
 
 			currStruct, ok := currType.Type.(*ast.StructType)
 			if !ok {
-				fmt.Printf("SKIP %#T is not ast.StructType\n", currStruct)
+				fmt.Printf("SKIP %T is not ast.StructType\n", currType.Type)
 				continue
 			}
 
-			if g.Doc == nil {
+			doc := currType.Doc
+			if doc == nil {
+				doc = g.Doc
+			}
+			if doc == nil {
 				fmt.Printf("SKIP struct %#v doesnt have comments\n", currType.Name.Name)
 				continue
 			}
 
-			needCodegen := false
-			for _, comment := range g.Doc.List {
-				needCodegen = needCodegen || strings.HasPrefix(comment.Text, "// cgen: binpack")
-			}
+			options, needCodegen := binpackOptions(doc)
 			if !needCodegen {
 				fmt.Printf("SKIP struct %#v doesnt have cgen mark\n", currType.Name.Name)
-				continue SPECS_LOOP
+				continue
 			}
 
-			fmt.Printf("process struct %s\n", currType.Name.Name)
-			fmt.Printf("\tgenerating Unpack method\n")
-
-			fmt.Fprintln(out, "func (in *"+currType.Name.Name+") Unpack(data []byte) error {")
-			fmt.Fprintln(out, "	r := bytes.NewReader(data)")
-
-		FIELDS_LOOP:
-			for _, field := range currStruct.Fields.List {
-
-				if field.Tag != nil {
-					tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-					if tag.Get("cgen") == "-" {
-						continue FIELDS_LOOP
-					}
+			st := &binpackStruct{
+				Name:  currType.Name.Name,
+				Order: defaultOrder,
+			}
+			if endian, isSet := options["endian"]; isSet {
+				var order string
+				order, err = byteOrder(endian)
+				if err != nil {
+					log.Fatalf("%s: %s", st.Name, err)
 				}
-
-				fieldName := field.Names[0].Name
-				fileType := field.Type.(*ast.Ident).Name
-
-				fmt.Printf("\tgenerating code for field %s.%s\n", currType.Name.Name, fieldName)
-
-				switch fileType {
-				case "int":
-					intTpl.Execute(out, tpl{fieldName})
-				case "string":
-					strTpl.Execute(out, tpl{fieldName})
-				default:
-					log.Fatalln("unsupported", fileType)
+				st.Order = order
+			}
+			st.Fields = structFields(st.Name, currStruct)
+			st.Versioned = len(st.Fields) > 0 && st.Fields[0].Number != 0
+			st.Version = 1
+			if version, isSet := options["version"]; isSet {
+				st.Version, err = strconv.Atoi(version)
+				if err != nil || st.Version < 1 {
+					log.Fatalf("%s: version must be a positive number", st.Name)
+				}
+				if !st.Versioned {
+					log.Fatalf("%s: version needs numbered fields", st.Name)
 				}
 			}
+			structs = append(structs, st)
+		}
+	}
+	return structs
+}
+
+func binpackOptions(doc *ast.CommentGroup) (map[string]string, bool) {
+	for _, comment := range doc.List {
+		if !strings.HasPrefix(comment.Text, binpackMark) {
+			continue
+		}
+		options := make(map[string]string)
+		for _, option := range strings.Fields(strings.TrimPrefix(comment.Text, binpackMark)) {
+			kv := strings.SplitN(option, "=", 2)
+			if len(kv) == 2 {
+				options[kv[0]] = kv[1]
+			} else {
+				options[kv[0]] = ""
+			}
+		}
+		return options, true
+	}
+	return nil, false
+}
 
-			fmt.Fprintln(out, "	return nil")
-			fmt.Fprintln(out, "}") // end of Unpack func
-			fmt.Fprintln(out)      // empty line
+// structFields returns fields to pack, either all of them have numbers or none
+func structFields(structName string, currStruct *ast.StructType) []binpackField {
+	var fields []binpackField
+	numbers := make(map[int]string)
+	for _, field := range currStruct.Fields.List {
+		var cgenTag string
+		if field.Tag != nil {
+			tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
+			cgenTag = tag.Get("cgen")
+		}
+		if cgenTag == "-" {
+			continue
+		}
+		if len(field.Names) == 0 {
+			log.Fatalf("%s: embedded fields are not supported", structName)
+		}
 
+		number := 0
+		if cgenTag != "" {
+			var err error
+			number, err = strconv.Atoi(cgenTag)
+			if err != nil || number < 1 || number > math.MaxUint16 {
+				log.Fatalf("%s.%s: field number must be from 1 to %d", structName, field.Names[0].Name, math.MaxUint16)
+			}
+			if len(field.Names) > 1 {
+				log.Fatalf("%s.%s: numbered fields must be declared one by one", structName, field.Names[0].Name)
+			}
+			if prevName, exists := numbers[number]; exists {
+				log.Fatalf("%s.%s: field number %d is already used by %s", structName, field.Names[0].Name, number, prevName)
+			}
+			numbers[number] = field.Names[0].Name
+		}
+		for _, name := range field.Names {
+			fields = append(fields, binpackField{name.Name, field.Type, number})
 		}
 	}
+	if len(numbers) > 0 && len(numbers) != len(fields) {
+		log.Fatalf("%s: either all fields have numbers or none", structName)
+	}
+	return fields
 }
 
-// go build gen/* && ./codegen.exe pack/unpack.go  pack/marshaller.go
-// go run pack/*
+// packages returns imports the generated code needs
+func (c *codec) packages() []string {
+	packages := []string{"bytes", "encoding/binary", "fmt", "io", "math"}
+	for pkg := range c.imports {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	return packages
+}
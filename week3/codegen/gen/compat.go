@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// schemaField is a field of a versioned struct as it was in some version
+type schemaField struct {
+	Name   string `json:"name"`
+	Number int    `json:"number"`
+	Type   string `json:"type"`
+}
+
+// binpackSchema is the history of versioned structs: struct name -> version -> fields,
+// it is kept with the code, so every version ever packed can be tested against the current one
+type binpackSchema map[string]map[int][]schemaField
+
+func loadSchema(path string) binpackSchema {
+	schema := make(binpackSchema)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return schema
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		log.Fatalf("cant parse schema %s: %s", path, err)
+	}
+	return schema
+}
+
+func (schema binpackSchema) save(path string) {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func schemaFields(st *binpackStruct) []schemaField {
+	fields := make([]schemaField, 0, len(st.Fields))
+	for _, f := range st.Fields {
+		fields = append(fields, schemaField{f.Name, f.Number, types.ExprString(f.Type)})
+	}
+	return fields
+}
+
+// record adds the current version of the struct to the history,
+// it fails generation if fields changed without a new version or a field number got another type
+func (schema binpackSchema) record(st *binpackStruct) {
+	current := schemaFields(st)
+	versions, exists := schema[st.Name]
+	if !exists {
+		versions = make(map[int][]schemaField)
+		schema[st.Name] = versions
+	}
+
+	for version, fields := range versions {
+		if version > st.Version {
+			log.Fatalf("%s: version %d is older than recorded version %d", st.Name, st.Version, version)
+		}
+		if version == st.Version && !reflect.DeepEqual(fields, current) {
+			log.Fatalf("%s: fields changed since version %d was recorded, bump the version", st.Name, version)
+		}
+		for _, old := range fields {
+			for _, f := range current {
+				if f.Number == old.Number && f.Type != old.Type {
+					log.Fatalf("%s.%s: field number %d was %s %s in version %d, give the field a new number",
+						st.Name, f.Name, f.Number, old.Name, old.Type, version)
+				}
+			}
+		}
+	}
+	versions[st.Version] = current
+}
+
+// oldStructName is the name of the struct as it was in the version for compatibility tests
+func oldStructName(name string, version int) string {
+	return strings.ToLower(name[:1]) + name[1:] + "V" + strconv.Itoa(version)
+}
+
+type compatTestTplArg struct {
+	Name            string
+	OldName         string
+	Version         int
+	Current         string
+	Old             string
+	ExpectedOld     string
+	ExpectedCurrent string
+}
+
+var compatTestTpl = template.Must(template.New("compatTestTpl").Parse(`
+func Test{{.Name}}CompatV{{.Version}}(t *testing.T) {
+	current := {{.Current}}
+	old := {{.Old}}
+
+	// version {{.Version}} reads data of the current version, fields it doesnt know are skipped
+	packed, err := current.Pack()
+	if err != nil {
+		t.Fatalf("cant pack {{.Name}}: %s", err)
+	}
+	unpackedOld := {{.OldName}}{}
+	if err := unpackedOld.Unpack(packed); err != nil {
+		t.Fatalf("version {{.Version}} cant unpack {{.Name}}: %s", err)
+	}
+	expectedOld := {{.ExpectedOld}}
+	if !reflect.DeepEqual(unpackedOld, expectedOld) {
+		t.Errorf("version {{.Version}} unpacked {{.Name}} wrong\nexpected %#v\ngot %#v", expectedOld, unpackedOld)
+	}
+
+	// the current version reads data of version {{.Version}}, fields it didnt have stay zero
+	packed, err = old.Pack()
+	if err != nil {
+		t.Fatalf("cant pack {{.Name}} of version {{.Version}}: %s", err)
+	}
+	unpackedCurrent := {{.Name}}{}
+	if err := unpackedCurrent.Unpack(packed); err != nil {
+		t.Fatalf("cant unpack {{.Name}} of version {{.Version}}: %s", err)
+	}
+	expectedCurrent := {{.ExpectedCurrent}}
+	if !reflect.DeepEqual(unpackedCurrent, expectedCurrent) {
+		t.Errorf("{{.Name}} of version {{.Version}} unpacked wrong\nexpected %#v\ngot %#v", expectedCurrent, unpackedCurrent)
+	}
+}
+`))
+
+// generateCompatTests writes a test file with every recorded old version of versioned structs
+// and tests that the old and the current version read each other's data
+func generateCompatTests(path string, pkgName string, structs []*binpackStruct, schema binpackSchema) {
+	// old versions and the structs they are of
+	var oldStructs []*binpackStruct
+	currentOf := make(map[*binpackStruct]*binpackStruct)
+	for _, st := range structs {
+		if !st.Versioned {
+			continue
+		}
+		versions := make([]int, 0, len(schema[st.Name]))
+		for version := range schema[st.Name] {
+			if version < st.Version {
+				versions = append(versions, version)
+			}
+		}
+		sort.Ints(versions)
+		for _, version := range versions {
+			old := &binpackStruct{
+				Name:      oldStructName(st.Name, version),
+				Order:     st.Order,
+				Versioned: true,
+				Version:   version,
+			}
+			for _, f := range schema[st.Name][version] {
+				t, err := parser.ParseExpr(f.Type)
+				if err != nil {
+					log.Fatalf("%s: bad type %s of %s in version %d", st.Name, f.Type, f.Name, version)
+				}
+				old.Fields = append(old.Fields, binpackField{f.Name, t, f.Number})
+			}
+			oldStructs = append(oldStructs, old)
+			currentOf[old] = st
+		}
+	}
+
+	c := newCodec(append(append([]*binpackStruct{}, structs...), oldStructs...))
+	c.imports["reflect"] = true
+	c.imports["testing"] = true
+
+	body := new(bytes.Buffer)
+	for _, old := range oldStructs {
+		current := currentOf[old]
+		fmt.Printf("generating compatibility test of %s version %d\n", current.Name, old.Version)
+
+		fmt.Fprint(body, `
+// `+old.Name+` is `+current.Name+` as it was in version `+strconv.Itoa(old.Version)+`
+type `+old.Name+` struct {`)
+		for _, f := range old.Fields {
+			fmt.Fprint(body, `
+	`+f.Name+` `+types.ExprString(f.Type)+" `cgen:\""+strconv.Itoa(f.Number)+"\"`")
+		}
+		fmt.Fprint(body, `
+}
+`)
+		c.generateStruct(body, old)
+
+		oldNumbers := make(map[int]bool)
+		for _, f := range old.Fields {
+			oldNumbers[f.Number] = true
+		}
+		var commonFields []binpackField
+		for _, f := range current.Fields {
+			if oldNumbers[f.Number] {
+				commonFields = append(commonFields, f)
+			}
+		}
+		currentNumbers := make(map[int]bool)
+		for _, f := range commonFields {
+			currentNumbers[f.Number] = true
+		}
+
+		compatTestTpl.Execute(body, compatTestTplArg{
+			Name:            current.Name,
+			OldName:         old.Name,
+			Version:         old.Version,
+			Current:         c.sampleStruct(current.Name, current.Fields, nil, nil),
+			Old:             c.sampleStruct(old.Name, old.Fields, nil, nil),
+			ExpectedOld:     c.sampleStruct(old.Name, old.Fields, currentNumbers, nil),
+			ExpectedCurrent: c.sampleStruct(current.Name, commonFields, nil, nil),
+		})
+	}
+
+	src := new(bytes.Buffer)
+	fmt.Fprintln(src, `// Code generated by codegen. DO NOT EDIT.`)
+	fmt.Fprintln(src) // empty line
+	fmt.Fprintln(src, `package `+pkgName)
+	fmt.Fprintln(src) // empty line
+	if packages := c.testPackages(oldStructs); len(packages) > 0 {
+		fmt.Fprintln(src, `import (`)
+		for _, pkg := range packages {
+			fmt.Fprintln(src, `	"`+pkg+`"`)
+		}
+		fmt.Fprintln(src, `)`)
+	}
+	src.Write(body.Bytes())
+
+	writeSource(path, src.Bytes())
+}
+
+// testPackages returns imports of the test file, it has no old structs when all versions are the current ones
+func (c *codec) testPackages(oldStructs []*binpackStruct) []string {
+	var packages []string
+	if len(oldStructs) > 0 {
+		packages = append(packages, "bytes", "encoding/binary")
+		for pkg := range c.imports {
+			packages = append(packages, pkg)
+		}
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+// sampleStruct is a literal of the struct with sample values of fields, only fields with numbers in only if it is set,
+// seen are structs the literal is nested in
+func (c *codec) sampleStruct(name string, fields []binpackField, only map[int]bool, seen map[string]bool) string {
+	nestedSeen := map[string]bool{name: true}
+	for seenName := range seen {
+		nestedSeen[seenName] = true
+	}
+
+	var values []string
+	for idx, f := range fields {
+		if only != nil && !only[f.Number] {
+			continue
+		}
+		seed := f.Number
+		if seed == 0 {
+			seed = idx + 1
+		}
+		values = append(values, f.Name+": "+c.sampleValue(f.Type, seed, nestedSeen))
+	}
+	return name + "{" + strings.Join(values, ", ") + "}"
+}
+
+// sampleValue is a non zero value of type t, the same for the same seed, so versions get equal values of a field
+func (c *codec) sampleValue(t ast.Expr, seed int, seen map[string]bool) string {
+	switch t := t.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "bool":
+			return "true"
+		case "string":
+			return strconv.Quote("value" + strconv.Itoa(seed))
+		case "float32", "float64":
+			return strconv.Itoa(seed) + ".5"
+		}
+		if st, isBinpack := c.structs[t.Name]; isBinpack {
+			// recursive types stop at the first repeat
+			if seen[t.Name] {
+				return t.Name + "{}"
+			}
+			return c.sampleStruct(t.Name, st.Fields, nil, seen)
+		}
+		return strconv.Itoa(seed%100 + 1)
+	case *ast.ArrayType:
+		return types.ExprString(t) + "{" + c.sampleValue(t.Elt, seed, seen) + "}"
+	case *ast.MapType:
+		return types.ExprString(t) + "{" + c.sampleValue(t.Key, seed, seen) + ": " + c.sampleValue(t.Value, seed, seen) + "}"
+	}
+	panic("unchecked type " + types.ExprString(t))
+}
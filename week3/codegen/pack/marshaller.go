@@ -1,26 +1,555 @@
+// Code generated by codegen. DO NOT EDIT.
+
 package main
 
-import "encoding/binary"
-import "bytes"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+func (in *User) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	w.Write(binpackHeader)
+	if err := in.packTo(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
 
 func (in *User) Unpack(data []byte) error {
 	r := bytes.NewReader(data)
+	if err := binpackReadHeader(r); err != nil {
+		return err
+	}
+	if err := in.unpackFrom(r); err != nil {
+		return err
+	}
+	return binpackCheckEnd(r, "message")
+}
+
+func (in *User) packTo(w *bytes.Buffer) error {
+	msg := binpackBeginLen(w)
+	var field int
 
 	// ID
-	var IDRaw uint32
-	binary.Read(r, binary.LittleEndian, &IDRaw)
-	in.ID = int(IDRaw)
+	field = binpackBeginField(w, binary.LittleEndian, 1)
+	if err := binpackWriteInt(w, binary.LittleEndian, in.ID); err != nil {
+		return binpackFieldError("ID", err)
+	}
+	if err := binpackEndLen(w, binary.LittleEndian, field); err != nil {
+		return binpackFieldError("ID", err)
+	}
 
 	// Login
-	var LoginLenRaw uint32
-	binary.Read(r, binary.LittleEndian, &LoginLenRaw)
-	LoginRaw := make([]byte, LoginLenRaw)
-	binary.Read(r, binary.LittleEndian, &LoginRaw)
-	in.Login = string(LoginRaw)
+	field = binpackBeginField(w, binary.LittleEndian, 2)
+	if err := binpackWriteString(w, binary.LittleEndian, in.Login); err != nil {
+		return binpackFieldError("Login", err)
+	}
+	if err := binpackEndLen(w, binary.LittleEndian, field); err != nil {
+		return binpackFieldError("Login", err)
+	}
 
 	// Flags
-	var FlagsRaw uint32
-	binary.Read(r, binary.LittleEndian, &FlagsRaw)
-	in.Flags = int(FlagsRaw)
+	field = binpackBeginField(w, binary.LittleEndian, 3)
+	if err := binpackWriteInt(w, binary.LittleEndian, in.Flags); err != nil {
+		return binpackFieldError("Flags", err)
+	}
+	if err := binpackEndLen(w, binary.LittleEndian, field); err != nil {
+		return binpackFieldError("Flags", err)
+	}
+
+	// Email
+	field = binpackBeginField(w, binary.LittleEndian, 4)
+	if err := binpackWriteString(w, binary.LittleEndian, in.Email); err != nil {
+		return binpackFieldError("Email", err)
+	}
+	if err := binpackEndLen(w, binary.LittleEndian, field); err != nil {
+		return binpackFieldError("Email", err)
+	}
+	return binpackEndLen(w, binary.LittleEndian, msg)
+}
+
+func (in *User) unpackFrom(data *bytes.Reader) error {
+	msg, err := binpackReadMessage(data, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	in.ID = 0
+	in.Login = ""
+	in.Flags = 0
+	in.Email = ""
+
+	for msg.Len() > 0 {
+		number, r, err := binpackReadField(msg, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		switch number {
+		case 1:
+			// ID
+			if err := binpackReadInt(r, binary.LittleEndian, &in.ID); err != nil {
+				return binpackFieldError("ID", err)
+			}
+
+		case 2:
+			// Login
+			if err := binpackReadString(r, binary.LittleEndian, &in.Login); err != nil {
+				return binpackFieldError("Login", err)
+			}
+
+		case 3:
+			// Flags
+			if err := binpackReadInt(r, binary.LittleEndian, &in.Flags); err != nil {
+				return binpackFieldError("Flags", err)
+			}
+
+		case 4:
+			// Email
+			if err := binpackReadString(r, binary.LittleEndian, &in.Email); err != nil {
+				return binpackFieldError("Email", err)
+			}
+
+		default:
+			// a field of a newer version
+			continue
+		}
+		if err := binpackCheckEnd(r, "field "+strconv.Itoa(int(number))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *Avatar) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	if err := in.packTo(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (in *Avatar) Unpack(data []byte) error {
+	return in.unpackFrom(bytes.NewReader(data))
+}
+
+func (in *Avatar) packTo(w *bytes.Buffer) error {
+	// ID
+	if err := binpackWriteInt(w, binary.LittleEndian, in.ID); err != nil {
+		return binpackFieldError("ID", err)
+	}
+
+	// Url
+	if err := binpackWriteString(w, binary.LittleEndian, in.Url); err != nil {
+		return binpackFieldError("Url", err)
+	}
+	return nil
+}
+
+func (in *Avatar) unpackFrom(r *bytes.Reader) error {
+	// ID
+	if err := binpackReadInt(r, binary.LittleEndian, &in.ID); err != nil {
+		return binpackFieldError("ID", err)
+	}
+
+	// Url
+	if err := binpackReadString(r, binary.LittleEndian, &in.Url); err != nil {
+		return binpackFieldError("Url", err)
+	}
+	return nil
+}
+
+func (in *Profile) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	if err := in.packTo(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (in *Profile) Unpack(data []byte) error {
+	return in.unpackFrom(bytes.NewReader(data))
+}
+
+func (in *Profile) packTo(w *bytes.Buffer) error {
+	// UserID
+	binary.Write(w, binary.BigEndian, in.UserID)
+
+	// Rating
+	binary.Write(w, binary.BigEndian, in.Rating)
+
+	// Verified
+	binary.Write(w, binary.BigEndian, in.Verified)
+
+	// Level
+	binary.Write(w, binary.BigEndian, in.Level)
+
+	// Photo
+	if err := binpackWriteBytes(w, binary.BigEndian, in.Photo); err != nil {
+		return binpackFieldError("Photo", err)
+	}
+
+	// Avatars
+	if err := binpackWriteLen(w, binary.BigEndian, len(in.Avatars)); err != nil {
+		return binpackFieldError("Avatars", err)
+	}
+	for _, v1 := range in.Avatars {
+		if err := v1.packTo(w); err != nil {
+			return binpackFieldError("Avatars", err)
+		}
+	}
+
+	// Scores
+	if err := binpackWriteLen(w, binary.BigEndian, len(in.Scores)); err != nil {
+		return binpackFieldError("Scores", err)
+	}
+	keys2 := make([]string, 0, len(in.Scores))
+	for k2 := range in.Scores {
+		keys2 = append(keys2, k2)
+	}
+	sort.Slice(keys2, func(i, j int) bool { return keys2[i] < keys2[j] })
+	for _, k2 := range keys2 {
+		v2 := in.Scores[k2]
+		if err := binpackWriteString(w, binary.BigEndian, k2); err != nil {
+			return binpackFieldError("Scores", err)
+		}
+		binary.Write(w, binary.BigEndian, v2)
+	}
+
+	// Groups
+	if err := binpackWriteLen(w, binary.BigEndian, len(in.Groups)); err != nil {
+		return binpackFieldError("Groups", err)
+	}
+	for _, v3 := range in.Groups {
+		if err := binpackWriteLen(w, binary.BigEndian, len(v3)); err != nil {
+			return binpackFieldError("Groups", err)
+		}
+		for _, v4 := range v3 {
+			binary.Write(w, binary.BigEndian, v4)
+		}
+	}
+
+	// Interests
+	if err := binpackWriteLen(w, binary.BigEndian, len(in.Interests)); err != nil {
+		return binpackFieldError("Interests", err)
+	}
+	keys5 := make([]uint32, 0, len(in.Interests))
+	for k5 := range in.Interests {
+		keys5 = append(keys5, k5)
+	}
+	sort.Slice(keys5, func(i, j int) bool { return keys5[i] < keys5[j] })
+	for _, k5 := range keys5 {
+		v5 := in.Interests[k5]
+		binary.Write(w, binary.BigEndian, k5)
+		if err := binpackWriteLen(w, binary.BigEndian, len(v5)); err != nil {
+			return binpackFieldError("Interests", err)
+		}
+		for _, v6 := range v5 {
+			if err := binpackWriteString(w, binary.BigEndian, v6); err != nil {
+				return binpackFieldError("Interests", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (in *Profile) unpackFrom(r *bytes.Reader) error {
+	// UserID
+	if err := binpackRead(r, binary.BigEndian, &in.UserID); err != nil {
+		return binpackFieldError("UserID", err)
+	}
+
+	// Rating
+	if err := binpackRead(r, binary.BigEndian, &in.Rating); err != nil {
+		return binpackFieldError("Rating", err)
+	}
+
+	// Verified
+	if err := binpackRead(r, binary.BigEndian, &in.Verified); err != nil {
+		return binpackFieldError("Verified", err)
+	}
+
+	// Level
+	if err := binpackRead(r, binary.BigEndian, &in.Level); err != nil {
+		return binpackFieldError("Level", err)
+	}
+
+	// Photo
+	if err := binpackReadBytes(r, binary.BigEndian, &in.Photo); err != nil {
+		return binpackFieldError("Photo", err)
+	}
+
+	// Avatars
+	n1, err := binpackReadLen(r, binary.BigEndian)
+	if err != nil {
+		return binpackFieldError("Avatars", err)
+	}
+	in.Avatars = nil
+	if n1 > 0 {
+		in.Avatars = make([]Avatar, n1)
+	}
+	for i1 := range in.Avatars {
+		if err := in.Avatars[i1].unpackFrom(r); err != nil {
+			return binpackFieldError("Avatars", err)
+		}
+	}
+
+	// Scores
+	n2, err := binpackReadLen(r, binary.BigEndian)
+	if err != nil {
+		return binpackFieldError("Scores", err)
+	}
+	in.Scores = nil
+	if n2 > 0 {
+		in.Scores = make(map[string]int16, n2)
+	}
+	for i2 := 0; i2 < n2; i2++ {
+		var k2 string
+		if err := binpackReadString(r, binary.BigEndian, &k2); err != nil {
+			return binpackFieldError("Scores", err)
+		}
+		var v2 int16
+		if err := binpackRead(r, binary.BigEndian, &v2); err != nil {
+			return binpackFieldError("Scores", err)
+		}
+		in.Scores[k2] = v2
+	}
+
+	// Groups
+	n3, err := binpackReadLen(r, binary.BigEndian)
+	if err != nil {
+		return binpackFieldError("Groups", err)
+	}
+	in.Groups = nil
+	if n3 > 0 {
+		in.Groups = make([][]uint16, n3)
+	}
+	for i3 := range in.Groups {
+		n4, err := binpackReadLen(r, binary.BigEndian)
+		if err != nil {
+			return binpackFieldError("Groups", err)
+		}
+		in.Groups[i3] = nil
+		if n4 > 0 {
+			in.Groups[i3] = make([]uint16, n4)
+		}
+		for i4 := range in.Groups[i3] {
+			if err := binpackRead(r, binary.BigEndian, &in.Groups[i3][i4]); err != nil {
+				return binpackFieldError("Groups", err)
+			}
+		}
+	}
+
+	// Interests
+	n5, err := binpackReadLen(r, binary.BigEndian)
+	if err != nil {
+		return binpackFieldError("Interests", err)
+	}
+	in.Interests = nil
+	if n5 > 0 {
+		in.Interests = make(map[uint32][]string, n5)
+	}
+	for i5 := 0; i5 < n5; i5++ {
+		var k5 uint32
+		if err := binpackRead(r, binary.BigEndian, &k5); err != nil {
+			return binpackFieldError("Interests", err)
+		}
+		var v5 []string
+		n6, err := binpackReadLen(r, binary.BigEndian)
+		if err != nil {
+			return binpackFieldError("Interests", err)
+		}
+		v5 = nil
+		if n6 > 0 {
+			v5 = make([]string, n6)
+		}
+		for i6 := range v5 {
+			if err := binpackReadString(r, binary.BigEndian, &v5[i6]); err != nil {
+				return binpackFieldError("Interests", err)
+			}
+		}
+		in.Interests[k5] = v5
+	}
+	return nil
+}
+
+// versioned data starts with the magic and the version of the format
+var binpackHeader = []byte{'B', 'P', 1}
+
+func binpackReadHeader(r *bytes.Reader) error {
+	header := make([]byte, len(binpackHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("header: %w", io.ErrUnexpectedEOF)
+	}
+	if header[0] != binpackHeader[0] || header[1] != binpackHeader[1] {
+		return fmt.Errorf("header: not a binpack message")
+	}
+	if header[2] != binpackHeader[2] {
+		return fmt.Errorf("header: unsupported format version %d", header[2])
+	}
+	return nil
+}
+
+// binpackBeginLen reserves room for the uint32 length of what is written next and returns where it is
+func binpackBeginLen(w *bytes.Buffer) int {
+	start := w.Len()
+	w.Write([]byte{0, 0, 0, 0})
+	return start
+}
+
+func binpackEndLen(w *bytes.Buffer, order binary.ByteOrder, start int) error {
+	n := w.Len() - start - 4
+	if uint64(n) > math.MaxUint32 {
+		return fmt.Errorf("length %d overflows uint32", n)
+	}
+	order.PutUint32(w.Bytes()[start:], uint32(n))
+	return nil
+}
+
+func binpackBeginField(w *bytes.Buffer, order binary.ByteOrder, number uint16) int {
+	binary.Write(w, order, number)
+	return binpackBeginLen(w)
+}
+
+// binpackReadMessage returns a reader of the length prefixed message, so fields cant read past it
+func binpackReadMessage(r *bytes.Reader, order binary.ByteOrder) (*bytes.Reader, error) {
+	var msg []byte
+	if err := binpackReadBytes(r, order, &msg); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(msg), nil
+}
+
+func binpackReadField(msg *bytes.Reader, order binary.ByteOrder) (uint16, *bytes.Reader, error) {
+	var number uint16
+	if err := binpackRead(msg, order, &number); err != nil {
+		return 0, nil, err
+	}
+	r, err := binpackReadMessage(msg, order)
+	if err != nil {
+		return 0, nil, fmt.Errorf("field %d: %w", number, err)
+	}
+	return number, r, nil
+}
+
+// binpackCheckEnd fails if data is not read to the end, a field with extra bytes has changed its type
+func binpackCheckEnd(r *bytes.Reader, what string) error {
+	if r.Len() > 0 {
+		return fmt.Errorf("%s: %d extra bytes", what, r.Len())
+	}
+	return nil
+}
+
+// binpackFieldError says which field could not be packed or unpacked
+func binpackFieldError(field string, err error) error {
+	return fmt.Errorf("%s: %w", field, err)
+}
+
+// binpackRead reads a fixed size value, data that ends before the value is io.ErrUnexpectedEOF
+func binpackRead(r *bytes.Reader, order binary.ByteOrder, data interface{}) error {
+	err := binary.Read(r, order, data)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// int and uint are packed in 32 bits, as the first version of the format did
+func binpackWriteInt(w *bytes.Buffer, order binary.ByteOrder, v int) error {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return fmt.Errorf("%d overflows int32", v)
+	}
+	binary.Write(w, order, int32(v))
+	return nil
+}
+
+func binpackReadInt(r *bytes.Reader, order binary.ByteOrder, v *int) error {
+	var raw int32
+	if err := binpackRead(r, order, &raw); err != nil {
+		return err
+	}
+	*v = int(raw)
+	return nil
+}
+
+func binpackWriteUint(w *bytes.Buffer, order binary.ByteOrder, v uint) error {
+	if uint64(v) > math.MaxUint32 {
+		return fmt.Errorf("%d overflows uint32", v)
+	}
+	binary.Write(w, order, uint32(v))
+	return nil
+}
+
+func binpackReadUint(r *bytes.Reader, order binary.ByteOrder, v *uint) error {
+	var raw uint32
+	if err := binpackRead(r, order, &raw); err != nil {
+		return err
+	}
+	*v = uint(raw)
+	return nil
+}
+
+// strings, slices and maps start with the uint32 number of their elements
+func binpackWriteLen(w *bytes.Buffer, order binary.ByteOrder, n int) error {
+	if uint64(n) > math.MaxUint32 {
+		return fmt.Errorf("length %d overflows uint32", n)
+	}
+	binary.Write(w, order, uint32(n))
+	return nil
+}
+
+// binpackReadLen checks the length against the rest of data, every element takes at least a byte,
+// so broken data cant make Unpack allocate more than data is
+func binpackReadLen(r *bytes.Reader, order binary.ByteOrder) (int, error) {
+	var n uint32
+	if err := binpackRead(r, order, &n); err != nil {
+		return 0, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func binpackWriteString(w *bytes.Buffer, order binary.ByteOrder, s string) error {
+	if err := binpackWriteLen(w, order, len(s)); err != nil {
+		return err
+	}
+	w.WriteString(s)
+	return nil
+}
+
+func binpackReadString(r *bytes.Reader, order binary.ByteOrder, s *string) error {
+	var raw []byte
+	if err := binpackReadBytes(r, order, &raw); err != nil {
+		return err
+	}
+	*s = string(raw)
+	return nil
+}
+
+func binpackWriteBytes(w *bytes.Buffer, order binary.ByteOrder, data []byte) error {
+	if err := binpackWriteLen(w, order, len(data)); err != nil {
+		return err
+	}
+	w.Write(data)
+	return nil
+}
+
+// binpackReadBytes reads empty slices as nil, as all slices and maps are
+func binpackReadBytes(r *bytes.Reader, order binary.ByteOrder, data *[]byte) error {
+	n, err := binpackReadLen(r, order)
+	if err != nil {
+		return err
+	}
+	*data = nil
+	if n > 0 {
+		*data = make([]byte, n)
+		r.Read(*data)
+	}
 	return nil
 }
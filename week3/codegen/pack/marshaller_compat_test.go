@@ -0,0 +1,148 @@
+// Code generated by codegen. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// userV1 is User as it was in version 1
+type userV1 struct {
+	ID    int    `cgen:"1"`
+	Login string `cgen:"2"`
+	Flags int    `cgen:"3"`
+}
+
+func (in *userV1) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	w.Write(binpackHeader)
+	if err := in.packTo(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (in *userV1) Unpack(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := binpackReadHeader(r); err != nil {
+		return err
+	}
+	if err := in.unpackFrom(r); err != nil {
+		return err
+	}
+	return binpackCheckEnd(r, "message")
+}
+
+func (in *userV1) packTo(w *bytes.Buffer) error {
+	msg := binpackBeginLen(w)
+	var field int
+
+	// ID
+	field = binpackBeginField(w, binary.LittleEndian, 1)
+	if err := binpackWriteInt(w, binary.LittleEndian, in.ID); err != nil {
+		return binpackFieldError("ID", err)
+	}
+	if err := binpackEndLen(w, binary.LittleEndian, field); err != nil {
+		return binpackFieldError("ID", err)
+	}
+
+	// Login
+	field = binpackBeginField(w, binary.LittleEndian, 2)
+	if err := binpackWriteString(w, binary.LittleEndian, in.Login); err != nil {
+		return binpackFieldError("Login", err)
+	}
+	if err := binpackEndLen(w, binary.LittleEndian, field); err != nil {
+		return binpackFieldError("Login", err)
+	}
+
+	// Flags
+	field = binpackBeginField(w, binary.LittleEndian, 3)
+	if err := binpackWriteInt(w, binary.LittleEndian, in.Flags); err != nil {
+		return binpackFieldError("Flags", err)
+	}
+	if err := binpackEndLen(w, binary.LittleEndian, field); err != nil {
+		return binpackFieldError("Flags", err)
+	}
+	return binpackEndLen(w, binary.LittleEndian, msg)
+}
+
+func (in *userV1) unpackFrom(data *bytes.Reader) error {
+	msg, err := binpackReadMessage(data, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	in.ID = 0
+	in.Login = ""
+	in.Flags = 0
+
+	for msg.Len() > 0 {
+		number, r, err := binpackReadField(msg, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		switch number {
+		case 1:
+			// ID
+			if err := binpackReadInt(r, binary.LittleEndian, &in.ID); err != nil {
+				return binpackFieldError("ID", err)
+			}
+
+		case 2:
+			// Login
+			if err := binpackReadString(r, binary.LittleEndian, &in.Login); err != nil {
+				return binpackFieldError("Login", err)
+			}
+
+		case 3:
+			// Flags
+			if err := binpackReadInt(r, binary.LittleEndian, &in.Flags); err != nil {
+				return binpackFieldError("Flags", err)
+			}
+
+		default:
+			// a field of a newer version
+			continue
+		}
+		if err := binpackCheckEnd(r, "field "+strconv.Itoa(int(number))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUserCompatV1(t *testing.T) {
+	current := User{ID: 2, Login: "value2", Flags: 4, Email: "value4"}
+	old := userV1{ID: 2, Login: "value2", Flags: 4}
+
+	// version 1 reads data of the current version, fields it doesnt know are skipped
+	packed, err := current.Pack()
+	if err != nil {
+		t.Fatalf("cant pack User: %s", err)
+	}
+	unpackedOld := userV1{}
+	if err := unpackedOld.Unpack(packed); err != nil {
+		t.Fatalf("version 1 cant unpack User: %s", err)
+	}
+	expectedOld := userV1{ID: 2, Login: "value2", Flags: 4}
+	if !reflect.DeepEqual(unpackedOld, expectedOld) {
+		t.Errorf("version 1 unpacked User wrong\nexpected %#v\ngot %#v", expectedOld, unpackedOld)
+	}
+
+	// the current version reads data of version 1, fields it didnt have stay zero
+	packed, err = old.Pack()
+	if err != nil {
+		t.Fatalf("cant pack User of version 1: %s", err)
+	}
+	unpackedCurrent := User{}
+	if err := unpackedCurrent.Unpack(packed); err != nil {
+		t.Fatalf("cant unpack User of version 1: %s", err)
+	}
+	expectedCurrent := User{ID: 2, Login: "value2", Flags: 4}
+	if !reflect.DeepEqual(unpackedCurrent, expectedCurrent) {
+		t.Errorf("User of version 1 unpacked wrong\nexpected %#v\ngot %#v", expectedCurrent, unpackedCurrent)
+	}
+}
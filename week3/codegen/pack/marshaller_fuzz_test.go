@@ -0,0 +1,246 @@
+// Code generated by codegen. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func randomUser(rnd *rand.Rand, depth int) User {
+	in := User{}
+	if depth > 3 {
+		return in
+	}
+	in.ID = int(int32(rnd.Uint32()))
+	in.Login = string(randomBytes(rnd))
+	in.Flags = int(int32(rnd.Uint32()))
+	in.Email = string(randomBytes(rnd))
+	return in
+}
+
+func TestUserPackUnpack(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		in := randomUser(rnd, 0)
+		packed, err := in.Pack()
+		if err != nil {
+			t.Fatalf("cant pack %#v: %s", in, err)
+		}
+		out := User{}
+		if err := out.Unpack(packed); err != nil {
+			t.Fatalf("cant unpack %#v: %s", in, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("unpacked User differs\nexpected %#v\ngot %#v", in, out)
+		}
+	}
+}
+
+func FuzzUserUnpack(f *testing.F) {
+	sample := User{ID: 2, Login: "value2", Flags: 4, Email: "value4"}
+	packed, err := sample.Pack()
+	if err != nil {
+		f.Fatalf("cant pack the sample: %s", err)
+	}
+	f.Add(packed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		in := User{}
+		if err := in.Unpack(data); err != nil {
+			return
+		}
+		// what is unpacked packs the same way every time, even if data had unknown fields or other bools than 0 and 1
+		packed, err := in.Pack()
+		if err != nil {
+			t.Fatalf("cant pack unpacked %#v: %s", in, err)
+		}
+		out := User{}
+		if err := out.Unpack(packed); err != nil {
+			t.Fatalf("cant unpack packed %#v: %s", in, err)
+		}
+		packedAgain, err := out.Pack()
+		if err != nil || !bytes.Equal(packed, packedAgain) {
+			t.Fatalf("User packs differently after unpack\nexpected %v\ngot %v", packed, packedAgain)
+		}
+	})
+}
+
+func randomAvatar(rnd *rand.Rand, depth int) Avatar {
+	in := Avatar{}
+	if depth > 3 {
+		return in
+	}
+	in.ID = int(int32(rnd.Uint32()))
+	in.Url = string(randomBytes(rnd))
+	return in
+}
+
+func TestAvatarPackUnpack(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		in := randomAvatar(rnd, 0)
+		packed, err := in.Pack()
+		if err != nil {
+			t.Fatalf("cant pack %#v: %s", in, err)
+		}
+		out := Avatar{}
+		if err := out.Unpack(packed); err != nil {
+			t.Fatalf("cant unpack %#v: %s", in, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("unpacked Avatar differs\nexpected %#v\ngot %#v", in, out)
+		}
+	}
+}
+
+func FuzzAvatarUnpack(f *testing.F) {
+	sample := Avatar{ID: 2, Url: "value2"}
+	packed, err := sample.Pack()
+	if err != nil {
+		f.Fatalf("cant pack the sample: %s", err)
+	}
+	f.Add(packed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		in := Avatar{}
+		if err := in.Unpack(data); err != nil {
+			return
+		}
+		// what is unpacked packs the same way every time, even if data had unknown fields or other bools than 0 and 1
+		packed, err := in.Pack()
+		if err != nil {
+			t.Fatalf("cant pack unpacked %#v: %s", in, err)
+		}
+		out := Avatar{}
+		if err := out.Unpack(packed); err != nil {
+			t.Fatalf("cant unpack packed %#v: %s", in, err)
+		}
+		packedAgain, err := out.Pack()
+		if err != nil || !bytes.Equal(packed, packedAgain) {
+			t.Fatalf("Avatar packs differently after unpack\nexpected %v\ngot %v", packed, packedAgain)
+		}
+	})
+}
+
+func randomProfile(rnd *rand.Rand, depth int) Profile {
+	in := Profile{}
+	if depth > 3 {
+		return in
+	}
+	in.UserID = uint64(rnd.Uint64())
+	in.Rating = float32(rnd.NormFloat64())
+	in.Verified = rnd.Intn(2) == 1
+	in.Level = int8(rnd.Uint32())
+	in.Photo = randomBytes(rnd)
+	in.Avatars = func() []Avatar {
+		var v []Avatar
+		for n := rnd.Intn(4); n > 0; n-- {
+			v = append(v, randomAvatar(rnd, depth+1))
+		}
+		return v
+	}()
+	in.Scores = func() map[string]int16 {
+		var v map[string]int16
+		for n := rnd.Intn(4); n > 0; n-- {
+			if v == nil {
+				v = make(map[string]int16)
+			}
+			v[string(randomBytes(rnd))] = int16(rnd.Uint32())
+		}
+		return v
+	}()
+	in.Groups = func() [][]uint16 {
+		var v [][]uint16
+		for n := rnd.Intn(4); n > 0; n-- {
+			v = append(v, func() []uint16 {
+				var v []uint16
+				for n := rnd.Intn(4); n > 0; n-- {
+					v = append(v, uint16(rnd.Uint32()))
+				}
+				return v
+			}())
+		}
+		return v
+	}()
+	in.Interests = func() map[uint32][]string {
+		var v map[uint32][]string
+		for n := rnd.Intn(4); n > 0; n-- {
+			if v == nil {
+				v = make(map[uint32][]string)
+			}
+			v[uint32(rnd.Uint32())] = func() []string {
+				var v []string
+				for n := rnd.Intn(4); n > 0; n-- {
+					v = append(v, string(randomBytes(rnd)))
+				}
+				return v
+			}()
+		}
+		return v
+	}()
+	return in
+}
+
+func TestProfilePackUnpack(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		in := randomProfile(rnd, 0)
+		packed, err := in.Pack()
+		if err != nil {
+			t.Fatalf("cant pack %#v: %s", in, err)
+		}
+		out := Profile{}
+		if err := out.Unpack(packed); err != nil {
+			t.Fatalf("cant unpack %#v: %s", in, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("unpacked Profile differs\nexpected %#v\ngot %#v", in, out)
+		}
+	}
+}
+
+func FuzzProfileUnpack(f *testing.F) {
+	sample := Profile{UserID: 2, Rating: 2.5, Verified: true, Level: 5, Photo: []byte{6}, Avatars: []Avatar{Avatar{ID: 2, Url: "value2"}}, Scores: map[string]int16{"value7": 8}, Groups: [][]uint16{[]uint16{9}}, Interests: map[uint32][]string{10: []string{"value9"}}}
+	packed, err := sample.Pack()
+	if err != nil {
+		f.Fatalf("cant pack the sample: %s", err)
+	}
+	f.Add(packed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		in := Profile{}
+		if err := in.Unpack(data); err != nil {
+			return
+		}
+		// what is unpacked packs the same way every time, even if data had unknown fields or other bools than 0 and 1
+		packed, err := in.Pack()
+		if err != nil {
+			t.Fatalf("cant pack unpacked %#v: %s", in, err)
+		}
+		out := Profile{}
+		if err := out.Unpack(packed); err != nil {
+			t.Fatalf("cant unpack packed %#v: %s", in, err)
+		}
+		packedAgain, err := out.Pack()
+		if err != nil || !bytes.Equal(packed, packedAgain) {
+			t.Fatalf("Profile packs differently after unpack\nexpected %v\ngot %v", packed, packedAgain)
+		}
+	})
+}
+
+// randomBytes returns nil or up to 8 random bytes
+func randomBytes(rnd *rand.Rand) []byte {
+	n := rnd.Intn(9)
+	if n == 0 {
+		return nil
+	}
+	data := make([]byte, n)
+	rnd.Read(data)
+	return data
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestProfileRoundTrip(t *testing.T) {
+	p := Profile{
+		UserID:    42,
+		Rating:    -0.5,
+		Level:     127,
+		Avatars:   []Avatar{{ID: -1, Url: "/a.png"}},
+		Scores:    map[string]int16{"a": 1, "b": -1},
+		Groups:    [][]uint16{nil, {65535}},
+		Interests: map[uint32][]string{0: {""}},
+	}
+	packed, err := p.Pack()
+	if err != nil {
+		t.Fatalf("cant pack: %s", err)
+	}
+
+	// maps are packed in the order of keys
+	packedAgain, _ := p.Pack()
+	if !reflect.DeepEqual(packed, packedAgain) {
+		t.Errorf("same profile is packed differently")
+	}
+
+	unpacked := Profile{}
+	if err := unpacked.Unpack(packed); err != nil {
+		t.Fatalf("cant unpack: %s", err)
+	}
+	if !reflect.DeepEqual(p, unpacked) {
+		t.Errorf("unpacked profile differs\nexpected %#v\ngot %#v", p, unpacked)
+	}
+
+	for size := 0; size < len(packed); size++ {
+		err := unpacked.Unpack(packed[:size])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("data of %d bytes: expected unexpected EOF, got %v", size, err)
+		}
+	}
+}
+
+func TestIntOverflow(t *testing.T) {
+	u := User{ID: 1 << 40}
+	if _, err := u.Pack(); err == nil {
+		t.Errorf("expected error on int that doesnt fit 32 bits")
+	}
+}
@@ -1,39 +1,90 @@
-// go build gen/* && ./codegen.exe pack/packer.go  pack/marshaller.go
+// go build -o codegen.exe ./gen && ./codegen.exe -schema pack/binpack_schema.json -compat pack/marshaller_compat_test.go -fuzz pack/marshaller_fuzz_test.go pack/unpack.go pack/marshaller.go
 package main
 
 import "fmt"
 
-// lets generate code for this struct
-// cgen: binpack
+// lets generate code for this struct,
+// fields have numbers, so data of version 1 without Email is still read
+// cgen: binpack version=2
 type User struct {
-	ID       int
+	ID       int    `cgen:"1"`
 	RealName string `cgen:"-"`
-	Login    string
-	Flags    int
+	Login    string `cgen:"2"`
+	Flags    int    `cgen:"3"`
+	Email    string `cgen:"4"`
 }
 
+// cgen: binpack
 type Avatar struct {
 	ID  int
 	Url string
 }
 
+// cgen: binpack endian=big
+type Profile struct {
+	UserID    uint64
+	Rating    float32
+	Verified  bool
+	Level     int8
+	Photo     []byte
+	Avatars   []Avatar
+	Scores    map[string]int16
+	Groups    [][]uint16
+	Interests map[uint32][]string
+}
+
 var test = 42
 
 func main() {
-	/*
-		perl -E '$b = pack("L L/a* L", 1_123_456, "v.romanov", 16);
-			print map { ord.", "  } split("", $b); '
-	*/
+	// User{ID: 1123456, Login: "v.romanov", Flags: 16} packed by version 1
 	data := []byte{
+		'B', 'P', 1, // header: magic and format version
+		39, 0, 0, 0, // message length
+
+		1, 0, 4, 0, 0, 0, // field 1, its length
 		128, 36, 17, 0,
 
+		2, 0, 13, 0, 0, 0, // field 2, its length
 		9, 0, 0, 0,
 		118, 46, 114, 111, 109, 97, 110, 111, 118,
 
+		3, 0, 4, 0, 0, 0, // field 3, its length
 		16, 0, 0, 0,
 	}
 
 	u := User{}
-	u.Unpack(data)
-	fmt.Printf("Unpacked user %#v", u)
+	if err := u.Unpack(data); err != nil {
+		fmt.Println("cant unpack user:", err)
+		return
+	}
+	fmt.Printf("Unpacked user %#v\n", u)
+
+	p := Profile{
+		UserID:    1123456,
+		Rating:    4.5,
+		Verified:  true,
+		Level:     -3,
+		Photo:     []byte{0xff, 0xd8},
+		Avatars:   []Avatar{{1, "/avatars/1.png"}, {2, "/avatars/2.png"}},
+		Scores:    map[string]int16{"go": 100, "perl": 80},
+		Groups:    [][]uint16{{1, 2}, {3}},
+		Interests: map[uint32][]string{2018: {"golang", "coursera"}},
+	}
+	packed, err := p.Pack()
+	if err != nil {
+		fmt.Println("cant pack profile:", err)
+		return
+	}
+	fmt.Printf("Packed profile to %d bytes\n", len(packed))
+
+	p2 := Profile{}
+	if err := p2.Unpack(packed); err != nil {
+		fmt.Println("cant unpack profile:", err)
+		return
+	}
+	fmt.Printf("Unpacked profile %#v\n", p2)
+
+	if err := p2.Unpack(packed[:len(packed)-5]); err != nil {
+		fmt.Println("truncated profile:", err)
+	}
 }
@@ -100,21 +100,9 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
-	case http.StatusBadRequest:
-		errResp := SearchErrorResponse{}
-		err = json.Unmarshal(body, &errResp)
-		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
-		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
-		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+	err = checkStatus(resp.StatusCode, body, req.OrderField)
+	if err != nil {
+		return nil, err
 	}
 
 	data := []User{}
@@ -133,3 +121,24 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 
 	return &result, err
 }
+
+// checkStatus переводит ошибочные статусы внешней системы в ошибки клиента
+func checkStatus(statusCode int, body []byte, orderField string) error {
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("Bad AccessToken")
+	case http.StatusInternalServerError:
+		return fmt.Errorf("SearchServer fatal error")
+	case http.StatusBadRequest:
+		errResp := SearchErrorResponse{}
+		err := json.Unmarshal(body, &errResp)
+		if err != nil {
+			return fmt.Errorf("cant unpack error json: %s", err)
+		}
+		if errResp.Error == "ErrorBadOrderField" {
+			return fmt.Errorf("OrderFeld %s invalid", orderField)
+		}
+		return fmt.Errorf("unknown bad request error: %s", errResp.Error)
+	}
+	return nil
+}
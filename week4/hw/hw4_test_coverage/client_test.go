@@ -12,6 +12,9 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
+	"math/rand"
+
+	"./searchtest"
 )
 
 type DsPerson struct {
@@ -199,6 +202,48 @@ func SearchServer(w http.ResponseWriter, r *http.Request) {
 	w.Write(usersStr)
 }
 
+func ExportServer(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	orderField := r.URL.Query().Get("order_field")
+	orderBy, _ := strconv.Atoi(r.URL.Query().Get("order_by"))
+	accessToken := r.Header.Get("AccessToken")
+
+	if accessToken == "INVALID" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if query == "QUERY_THAT_BREAKS_EVERYTHING" {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	users := loadUsers()
+	users = filterUsers(users, query)
+	err := sortUsers(users, orderField, orderBy)
+
+	if err != nil {
+		writeBadRequest(w, "ErrorBadOrderField")
+		return
+	}
+
+	w.Header().Set("Content-Type", ExportContentType)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for _, user := range users {
+		enc.Encode(user)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if query == "BREAK_IN_THE_MIDDLE" {
+		w.Write([]byte("abracodabra"))
+	}
+}
+
 func createClientWithoutServer() SearchClient {
 	client := SearchClient{
 		URL: "",
@@ -207,11 +252,29 @@ func createClientWithoutServer() SearchClient {
 	return client
 }
 
+func newSearchHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", SearchServer)
+	mux.HandleFunc(ExportPath, ExportServer)
+	return mux
+}
+
+func createClientForHandler(handler http.Handler) SearchClient {
+	ts := httptest.NewServer(handler)
+
+	client := SearchClient{
+		URL: ts.URL,
+		AccessToken: "VALID",
+	}
+
+	return client
+}
+
 func createServerAndClient(accessToken string) SearchClient {
 	if accessToken == "" {
 		accessToken = "VALID"
 	}
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts := httptest.NewServer(newSearchHandler())
 	
 	client := SearchClient{
 		URL: ts.URL,
@@ -404,4 +467,191 @@ func TestUnknownBadRequest(t *testing.T) {
 	if resp != nil || err == nil || !strings.Contains(err.Error(), "unknown bad request error") {
 		t.Fail()
 	}
-}
\ No newline at end of file
+}
+
+func TestExportAllUsers(t *testing.T) {
+	client := createServerAndClient("")
+
+	stream, err := client.ExportUsers(ExportRequest{
+		OrderField: "Id",
+		OrderBy: OrderByDesc,
+	})
+
+	if stream == nil || err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	users := make([]User, 0)
+	for stream.Next() {
+		users = append(users, stream.User())
+	}
+
+	if stream.Err() != nil || len(users) != 35 {
+		t.Fail()
+	}
+
+	for i := 1; i < len(users); i++ {
+		if users[i-1].Id < users[i].Id {
+			t.Fail()
+		}
+	}
+}
+
+func TestExportFiltered(t *testing.T) {
+	client := createServerAndClient("")
+
+	stream, err := client.ExportUsers(ExportRequest{
+		Query: "Boyd",
+	})
+
+	if stream == nil || err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	count := 0
+	for stream.Next() {
+		if !strings.Contains(stream.User().Name, "Boyd") && !strings.Contains(stream.User().About, "Boyd") {
+			t.Fail()
+		}
+		count++
+	}
+
+	if stream.Err() != nil || count == 0 {
+		t.Fail()
+	}
+}
+
+func TestExportBadAccessToken(t *testing.T) {
+	client := createServerAndClient("INVALID")
+
+	stream, err := client.ExportUsers(ExportRequest{})
+
+	if stream != nil || err == nil || !strings.Contains(err.Error(), "Bad AccessToken") {
+		t.Fail()
+	}
+}
+
+func TestExportInternalServerError(t *testing.T) {
+	client := createServerAndClient("")
+
+	stream, err := client.ExportUsers(ExportRequest{
+		Query: "QUERY_THAT_BREAKS_EVERYTHING",
+	})
+
+	if stream != nil || err == nil || !strings.Contains(err.Error(), "SearchServer fatal error") {
+		t.Fail()
+	}
+}
+
+func TestExportOrderByWrongField(t *testing.T) {
+	client := createServerAndClient("")
+
+	stream, err := client.ExportUsers(ExportRequest{
+		OrderField: "About",
+	})
+
+	if stream != nil || err == nil || !strings.Contains(err.Error(), "OrderFeld About invalid") {
+		t.Fail()
+	}
+}
+
+func TestExportUnknownSendError(t *testing.T) {
+	client := createClientWithoutServer()
+
+	stream, err := client.ExportUsers(ExportRequest{})
+
+	if stream != nil || err == nil || !strings.Contains(err.Error(), "unknown error") {
+		t.Fail()
+	}
+}
+
+func TestExportInvalidJsonInTheMiddle(t *testing.T) {
+	client := createServerAndClient("")
+
+	stream, err := client.ExportUsers(ExportRequest{
+		Query: "BREAK_IN_THE_MIDDLE",
+	})
+
+	if stream == nil || err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for stream.Next() {
+	}
+
+	if stream.Err() == nil || !strings.Contains(stream.Err().Error(), "cant unpack result json") {
+		t.Fail()
+	}
+}
+
+func TestSearchServerConformance(t *testing.T) {
+	searchtest.Run(t, newSearchHandler(), searchtest.Config{
+		ValidToken: "VALID",
+		InvalidToken: "INVALID",
+		TotalUsers: 35,
+		ExportPath: ExportPath,
+	})
+}
+
+func TestLatencyFault(t *testing.T) {
+	client := createClientForHandler(searchtest.Latency(newSearchHandler(), time.Second * 2))
+
+	resp, err := client.FindUsers(SearchRequest{
+		Limit: 10,
+	})
+
+	if resp != nil || err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fail()
+	}
+}
+
+func TestMalformedBodyFault(t *testing.T) {
+	client := createClientForHandler(searchtest.MalformedBody(newSearchHandler()))
+
+	resp, err := client.FindUsers(SearchRequest{
+		Limit: 10,
+	})
+
+	if resp != nil || err == nil || !strings.Contains(err.Error(), "cant unpack result json") {
+		t.Fail()
+	}
+
+	stream, err := client.ExportUsers(ExportRequest{})
+	if stream == nil || err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for stream.Next() {
+	}
+
+	if stream.Err() == nil {
+		t.Fail()
+	}
+}
+
+func TestRandom5xxFault(t *testing.T) {
+	client := createClientForHandler(searchtest.Random5xx(newSearchHandler(), 0.5, rand.New(rand.NewSource(1))))
+
+	failed, succeeded := 0, 0
+	for i := 0; i < 20; i++ {
+		resp, err := client.FindUsers(SearchRequest{
+			Limit: 10,
+		})
+		if err != nil {
+			if resp != nil {
+				t.Fail()
+			}
+			failed++
+		} else {
+			succeeded++
+		}
+	}
+
+	if failed == 0 || succeeded == 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// ExportPath добавляется к URL внешней системы для выгрузки всех пользователей
+	ExportPath = "/export"
+
+	ExportContentType = "application/x-ndjson"
+)
+
+var (
+	// выгрузка может идти сколько угодно долго, поэтому ограничиваем только ожидание заголовков
+	exportClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: time.Second,
+		},
+	}
+)
+
+type ExportRequest struct {
+	Query      string // подстрока в 1 из полей
+	OrderField string
+	// OrderByAsc, OrderByAsIs или OrderByDesc, уходит в сервис как order_by
+	OrderBy int
+}
+
+// UserStream читает пользователей из ответа по одному, не загружая весь ответ в память
+type UserStream struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+	user User
+	err  error
+}
+
+// Next читает следующего пользователя, false - если данные кончились или произошла ошибка
+func (s *UserStream) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	user := User{}
+	err := s.dec.Decode(&user)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		s.err = fmt.Errorf("cant unpack result json: %s", err)
+		return false
+	}
+	s.user = user
+	return true
+}
+
+// User возвращает пользователя, прочитанного последним вызовом Next
+func (s *UserStream) User() User {
+	return s.user
+}
+
+// Err возвращает ошибку, на которой остановилось чтение
+func (s *UserStream) Err() error {
+	return s.err
+}
+
+func (s *UserStream) Close() error {
+	return s.body.Close()
+}
+
+// ExportUsers запрашивает у внешней системы всех подходящих под запрос пользователей одним потоком NDJSON
+func (srv *SearchClient) ExportUsers(req ExportRequest) (*UserStream, error) {
+	searcherParams := url.Values{}
+	searcherParams.Add("query", req.Query)
+	searcherParams.Add("order_field", req.OrderField)
+	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+
+	searcherReq, err := http.NewRequest("GET", srv.URL+ExportPath+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cant create request: %s", err)
+	}
+	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	searcherReq.Header.Add("Accept", ExportContentType)
+
+	resp, err := exportClient.Do(searcherReq)
+	if err != nil {
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+		}
+		return nil, fmt.Errorf("unknown error %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		err = checkStatus(resp.StatusCode, body, req.OrderField)
+		if err == nil {
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil, err
+	}
+
+	return &UserStream{
+		body: resp.Body,
+		dec:  json.NewDecoder(resp.Body),
+	}, nil
+}
@@ -0,0 +1,250 @@
+// Package searchtest is a contract test kit for search server implementations:
+// a conformance suite for any http.Handler that claims to speak the SearchServer
+// protocol and a set of fault-injecting wrappers to check clients against.
+package searchtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	orderByAsc  = -1
+	orderByAsIs = 0
+	orderByDesc = 1
+)
+
+// User is the record every search server returns
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+type searchErrorResponse struct {
+	Error string
+}
+
+// Config describes the server under test
+type Config struct {
+	// token that must be accepted
+	ValidToken string
+	// token that must be rejected with 401
+	InvalidToken string
+	// number of users the server returns for an empty query
+	TotalUsers int
+	// path of the streaming NDJSON export, empty if the server has none
+	ExportPath string
+}
+
+type suite struct {
+	t   *testing.T
+	srv *httptest.Server
+	cfg Config
+}
+
+// Run checks that h behaves like a search server
+func Run(t *testing.T, h http.Handler, cfg Config) {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	s := &suite{t: t, srv: srv, cfg: cfg}
+
+	t.Run("BadAccessToken", s.testBadAccessToken)
+	t.Run("BadOrderField", s.testBadOrderField)
+	t.Run("Pagination", s.testPagination)
+	t.Run("OffsetBeyondEnd", s.testOffsetBeyondEnd)
+	t.Run("OrderById", func(t *testing.T) { s.testOrder(t, "Id") })
+	t.Run("OrderByAge", func(t *testing.T) { s.testOrder(t, "Age") })
+	t.Run("OrderByName", func(t *testing.T) { s.testOrder(t, "Name") })
+	t.Run("Query", s.testQuery)
+	if cfg.ExportPath != "" {
+		t.Run("Export", s.testExport)
+	}
+}
+
+func (s *suite) get(t *testing.T, path string, params url.Values, token string) (int, []byte) {
+	req, err := http.NewRequest("GET", s.srv.URL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("AccessToken", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func searchParams(limit, offset int, query, orderField string, orderBy int) url.Values {
+	params := url.Values{}
+	params.Add("limit", strconv.Itoa(limit))
+	params.Add("offset", strconv.Itoa(offset))
+	params.Add("query", query)
+	params.Add("order_field", orderField)
+	params.Add("order_by", strconv.Itoa(orderBy))
+	return params
+}
+
+func (s *suite) search(t *testing.T, params url.Values) []User {
+	status, body := s.get(t, "/", params, s.cfg.ValidToken)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, status, body)
+	}
+	users := []User{}
+	err := json.Unmarshal(body, &users)
+	if err != nil {
+		t.Fatalf("cant unpack result json: %s", err)
+	}
+	return users
+}
+
+func (s *suite) all(t *testing.T, orderField string, orderBy int) []User {
+	return s.search(t, searchParams(s.cfg.TotalUsers+1, 0, "", orderField, orderBy))
+}
+
+func (s *suite) testBadAccessToken(t *testing.T) {
+	status, _ := s.get(t, "/", searchParams(10, 0, "", "", orderByAsIs), s.cfg.InvalidToken)
+	if status != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func (s *suite) testBadOrderField(t *testing.T) {
+	status, body := s.get(t, "/", searchParams(10, 0, "", "About", orderByAsc), s.cfg.ValidToken)
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, status)
+	}
+	errResp := searchErrorResponse{}
+	err := json.Unmarshal(body, &errResp)
+	if err != nil {
+		t.Fatalf("cant unpack error json: %s", err)
+	}
+	if errResp.Error != "ErrorBadOrderField" {
+		t.Errorf("expected ErrorBadOrderField, got %q", errResp.Error)
+	}
+}
+
+func (s *suite) testPagination(t *testing.T) {
+	all := s.all(t, "Id", orderByAsc)
+	if len(all) != s.cfg.TotalUsers {
+		t.Fatalf("expected %d users, got %d", s.cfg.TotalUsers, len(all))
+	}
+
+	const pageSize = 7
+	paged := make([]User, 0, len(all))
+	for offset := 0; offset < len(all); offset += pageSize {
+		page := s.search(t, searchParams(pageSize, offset, "", "Id", orderByAsc))
+		if len(page) > pageSize {
+			t.Fatalf("page at offset %d has %d users, limit is %d", offset, len(page), pageSize)
+		}
+		paged = append(paged, page...)
+	}
+
+	if len(paged) != len(all) {
+		t.Fatalf("pages contain %d users, expected %d", len(paged), len(all))
+	}
+	for i := range all {
+		if paged[i].Id != all[i].Id {
+			t.Fatalf("user %d: page has id %d, full list has id %d", i, paged[i].Id, all[i].Id)
+		}
+	}
+}
+
+func (s *suite) testOffsetBeyondEnd(t *testing.T) {
+	users := s.search(t, searchParams(10, s.cfg.TotalUsers, "", "", orderByAsIs))
+	if len(users) != 0 {
+		t.Errorf("expected no users past the end, got %d", len(users))
+	}
+}
+
+func less(a, b User, orderField string) bool {
+	switch orderField {
+	case "Id":
+		return a.Id < b.Id
+	case "Age":
+		return a.Age < b.Age
+	default:
+		return a.Name < b.Name
+	}
+}
+
+func checkOrder(t *testing.T, users []User, orderField string, orderBy int) {
+	for i := 1; i < len(users); i++ {
+		prev, curr := users[i-1], users[i]
+		if orderBy == orderByAsc && less(curr, prev, orderField) {
+			t.Fatalf("users %d and %d are not in ascending %s order", i-1, i, orderField)
+		}
+		if orderBy == orderByDesc && less(prev, curr, orderField) {
+			t.Fatalf("users %d and %d are not in descending %s order", i-1, i, orderField)
+		}
+	}
+}
+
+func (s *suite) testOrder(t *testing.T, orderField string) {
+	checkOrder(t, s.all(t, orderField, orderByAsc), orderField, orderByAsc)
+	checkOrder(t, s.all(t, orderField, orderByDesc), orderField, orderByDesc)
+}
+
+func (s *suite) testQuery(t *testing.T) {
+	all := s.all(t, "", orderByAsIs)
+	if len(all) == 0 {
+		t.Skip("server has no users")
+	}
+	query := strings.Fields(all[0].Name)[0]
+
+	users := s.search(t, searchParams(s.cfg.TotalUsers, 0, query, "", orderByAsIs))
+	if len(users) == 0 {
+		t.Fatalf("query %q found nothing", query)
+	}
+	for _, user := range users {
+		if !strings.Contains(user.Name, query) && !strings.Contains(user.About, query) {
+			t.Errorf("user %d does not match query %q", user.Id, query)
+		}
+	}
+}
+
+func (s *suite) testExport(t *testing.T) {
+	params := url.Values{}
+	params.Add("order_field", "Age")
+	params.Add("order_by", strconv.Itoa(orderByDesc))
+	status, body := s.get(t, s.cfg.ExportPath, params, s.cfg.ValidToken)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	users := make([]User, 0, s.cfg.TotalUsers)
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		user := User{}
+		err := json.Unmarshal(scanner.Bytes(), &user)
+		if err != nil {
+			t.Fatalf("line %d is not a json object: %s", len(users)+1, err)
+		}
+		users = append(users, user)
+	}
+
+	if len(users) != s.cfg.TotalUsers {
+		t.Fatalf("expected %d exported users, got %d", s.cfg.TotalUsers, len(users))
+	}
+	checkOrder(t, users, "Age", orderByDesc)
+
+	status, _ = s.get(t, s.cfg.ExportPath, params, s.cfg.InvalidToken)
+	if status != http.StatusUnauthorized {
+		t.Errorf("expected status %d for bad token, got %d", http.StatusUnauthorized, status)
+	}
+}
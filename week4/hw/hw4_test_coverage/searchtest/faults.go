@@ -0,0 +1,71 @@
+package searchtest
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Latency delays every response of h by d
+func Latency(h http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// MalformedBody keeps the status code of h but replaces its body with something that is not json
+func MalformedBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{header: w.Header(), status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rec.status)
+		w.Write([]byte("abracodabra"))
+	})
+}
+
+// Random5xx answers with a random 5xx status instead of calling h with the given probability
+func Random5xx(h http.Handler, rate float64, rnd *rand.Rand) http.Handler {
+	statuses := []int{
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+	mu := &sync.Mutex{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := rnd.Float64() < rate
+		status := statuses[rnd.Intn(len(statuses))]
+		mu.Unlock()
+
+		if fail {
+			w.WriteHeader(status)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *statusRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	return len(b), nil
+}
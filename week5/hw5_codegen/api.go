@@ -1,10 +1,14 @@
 package main
 
+//go:generate go run ./handlers_gen -out api_handlers.go -openapi openapi -client apiclient -fuzz api_fuzz_test.go
+
 import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 )
 
 // вы можете использовать ApiError в коде, который получается в результате генерации
@@ -153,3 +157,175 @@ func (srv *OtherApi) Create(ctx context.Context, in OtherCreateParams) (*OtherUs
 		Level:    in.Level,
 	}, nil
 }
+
+// 3-я часть
+// расширенные правила apivalidator: float, bool, time, слайсы, вложенные структуры и свои валидаторы
+
+func init() {
+	RegisterApiValidator("phone", func(value interface{}) error {
+		phone, _ := value.(string)
+		if !strings.HasPrefix(phone, "+") || len(phone) < 11 {
+			return fmt.Errorf("must be a phone number in international format")
+		}
+		return nil
+	})
+}
+
+type SearchApi struct {
+}
+
+func NewSearchApi() *SearchApi {
+	return &SearchApi{}
+}
+
+type PeriodParams struct {
+	From time.Time `apivalidator:"required"`
+	To   time.Time `apivalidator:"layout=2006-01-02"`
+}
+
+type SearchParams struct {
+	Query    string       `apivalidator:"regexp=^[a-z_.]*$"`
+	Email    string       `apivalidator:"email"`
+	Phone    string       `apivalidator:"validator=phone"`
+	Country  string       `apivalidator:"len=2,default=RU"`
+	Rating   float64      `apivalidator:"min=0,max=5"`
+	Verified bool         `apivalidator:"default=true"`
+	Statuses []string     `apivalidator:"paramname=status,enum=user|moderator|admin"`
+	IDs      []uint64     `apivalidator:"paramname=id,max=1000"`
+	Period   PeriodParams `apivalidator:"paramname=period"`
+}
+
+type SearchResult struct {
+	Query SearchParams `json:"query"`
+}
+
+// apigen:api {"url": "/user/search", "auth": false}
+func (srv *SearchApi) Search(ctx context.Context, in SearchParams) (*SearchResult, error) {
+	return &SearchResult{Query: in}, nil
+}
+
+// 4-я часть
+// своя аутентификация: bearer-токены, сессии в куках, подписанные HMAC запросы и роли
+
+type AdminApi struct {
+	auth        Authenticator
+	middlewares []Middleware
+	hooks       map[string]ApiHooks
+	mu          *sync.Mutex
+	banned      map[string]string
+}
+
+func NewAdminApi() *AdminApi {
+	tokens := TokenMap{
+		"admin-token": &Principal{ID: "rvasily", Roles: []string{"admin"}},
+		"user-token":  &Principal{ID: "ivan", Roles: []string{"user"}},
+	}
+	sessions := TokenMap{
+		"admin-session": &Principal{ID: "rvasily", Roles: []string{"admin"}},
+	}
+	keys := func(ctx context.Context, keyID string) ([]byte, *Principal, error) {
+		if keyID != "robot" {
+			return nil, nil, nil
+		}
+		return []byte("robot-secret"), &Principal{ID: "robot", Roles: []string{"admin"}}, nil
+	}
+
+	return &AdminApi{
+		auth: AuthenticatorChain{
+			BearerAuthenticator{Lookup: tokens.Lookup},
+			CookieSessionAuthenticator{Cookie: "session", Lookup: sessions.Lookup},
+			HMACAuthenticator{Keys: keys},
+		},
+		middlewares: []Middleware{RecoverMiddleware},
+		hooks:       map[string]ApiHooks{},
+		mu:          &sync.Mutex{},
+		banned:      map[string]string{},
+	}
+}
+
+func (srv *AdminApi) Authenticator() Authenticator {
+	return srv.auth
+}
+
+// Use adds middlewares inside the already added ones, it is not safe to call while serving
+func (srv *AdminApi) Use(middlewares ...Middleware) {
+	srv.middlewares = append(srv.middlewares, middlewares...)
+}
+
+// Hook sets hooks of an api method, it is not safe to call while serving
+func (srv *AdminApi) Hook(method string, hooks ApiHooks) {
+	srv.hooks[method] = hooks
+}
+
+func (srv *AdminApi) Middlewares() []Middleware {
+	return srv.middlewares
+}
+
+func (srv *AdminApi) Hooks(method string) ApiHooks {
+	return srv.hooks[method]
+}
+
+type WhoamiParams struct {
+}
+
+type AdminUser struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+type BanParams struct {
+	Login string `apivalidator:"required"`
+}
+
+type BanResult struct {
+	Login    string `json:"login"`
+	BannedBy string `json:"banned_by"`
+}
+
+// apigen:api {"url": "/admin/whoami", "auth": true}
+func (srv *AdminApi) Whoami(ctx context.Context, in WhoamiParams) (*AdminUser, error) {
+	p, _ := PrincipalFromContext(ctx)
+	return &AdminUser{ID: p.ID, Roles: p.Roles}, nil
+}
+
+// apigen:api {"url": "/admin/ban", "auth": {"role": "admin"}, "method": "POST"}
+func (srv *AdminApi) Ban(ctx context.Context, in BanParams) (*BanResult, error) {
+	p, _ := PrincipalFromContext(ctx)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.banned[in.Login] = p.ID
+
+	return &BanResult{Login: in.Login, BannedBy: p.ID}, nil
+}
+
+type UserParams struct {
+	Login string `apivalidator:"required"`
+}
+
+type BanStatus struct {
+	Login    string `json:"login"`
+	Banned   bool   `json:"banned"`
+	BannedBy string `json:"banned_by,omitempty"`
+}
+
+// apigen:api {"url": "/admin/users/{login}", "auth": true, "method": "GET"}
+func (srv *AdminApi) BanStatus(ctx context.Context, in UserParams) (*BanStatus, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	bannedBy, banned := srv.banned[in.Login]
+
+	return &BanStatus{Login: in.Login, Banned: banned, BannedBy: bannedBy}, nil
+}
+
+// apigen:api {"url": "/admin/users/{login}", "auth": {"role": "admin"}, "method": "DELETE"}
+func (srv *AdminApi) Unban(ctx context.Context, in UserParams) (*BanStatus, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if _, banned := srv.banned[in.Login]; !banned {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not banned")}
+	}
+	delete(srv.banned, in.Login)
+
+	return &BanStatus{Login: in.Login}, nil
+}
@@ -0,0 +1,318 @@
+// Code generated by handlers_gen. DO NOT EDIT.
+
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// fuzzParamValues are values deserializers have to tell from valid ones
+var fuzzParamValues = []string{
+	"", "0", "1", "-1", "1.5", "1e400", "NaN", "true", "abc", " 1",
+	"9223372036854775807", "9223372036854775808", "-9223372036854775809",
+	"2018-04-02T15:04:05Z", "2018-13-45", "\x00", "\xff", "привет", "<script>",
+}
+
+// randomQuery is a query string mostly of known params, with repeated and unknown ones
+func randomQuery(rnd *rand.Rand, names []string) string {
+	params := url.Values{}
+	for n := rnd.Intn(len(names) + 2); n > 0; n-- {
+		name := "unknown"
+		if len(names) > 0 && rnd.Intn(5) > 0 {
+			name = names[rnd.Intn(len(names))]
+		}
+		value := fuzzParamValues[rnd.Intn(len(fuzzParamValues))]
+		if rnd.Intn(4) == 0 {
+			raw := make([]byte, rnd.Intn(8))
+			rnd.Read(raw)
+			value = string(raw)
+		}
+		params.Add(name, value)
+	}
+	return params.Encode()
+}
+
+// checkDeserializeQuery fails the test if the deserializer panics or its error would be answered with 5xx
+func checkDeserializeQuery(t *testing.T, query string, deserialize func(r *http.Request) error) {
+	t.Helper()
+	r := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: "/", RawQuery: query},
+		Header: http.Header{},
+	}
+	err := deserialize(r)
+	if err == nil {
+		return
+	}
+	apiErr, isApiError := err.(ApiError)
+	if !isApiError || apiErr.HTTPStatus >= http.StatusInternalServerError {
+		t.Fatalf("query %q: error %q would be answered with 500", query, err)
+	}
+}
+
+func TestDeserializeProfileParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"login"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeProfileParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeProfileParams(f *testing.F) {
+	f.Add("")
+	f.Add("login=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeProfileParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeCreateParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"login", "full_name", "status", "age"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeCreateParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeCreateParams(f *testing.F) {
+	f.Add("")
+	f.Add("login=1&full_name=1&status=1&age=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeCreateParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeOtherCreateParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"username", "account_name", "class", "level"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeOtherCreateParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeOtherCreateParams(f *testing.F) {
+	f.Add("")
+	f.Add("username=1&account_name=1&class=1&level=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeOtherCreateParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeSearchParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"query", "email", "phone", "country", "rating", "verified", "status", "id", "period.from", "period.to"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeSearchParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeSearchParams(f *testing.F) {
+	f.Add("")
+	f.Add("query=1&email=1&phone=1&country=1&rating=1&verified=1&status=1&id=1&period.from=1&period.to=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeSearchParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeWhoamiParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeWhoamiParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeWhoamiParams(f *testing.F) {
+	f.Add("")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeWhoamiParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeBanParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"login"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeBanParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeBanParams(f *testing.F) {
+	f.Add("")
+	f.Add("login=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeBanParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeUserParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"login"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeUserParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeUserParams(f *testing.F) {
+	f.Add("")
+	f.Add("login=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeUserParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeCatalogListParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"category", "page.limit", "page.offset"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeCatalogListParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeCatalogListParams(f *testing.F) {
+	f.Add("")
+	f.Add("category=1&page.limit=1&page.offset=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeCatalogListParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeUploadParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"title"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeUploadParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeUploadParams(f *testing.F) {
+	f.Add("")
+	f.Add("title=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeUploadParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeProgressParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"steps"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeProgressParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeProgressParams(f *testing.F) {
+	f.Add("")
+	f.Add("steps=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeProgressParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeTagsParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"prefix"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeTagsParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeTagsParams(f *testing.F) {
+	f.Add("")
+	f.Add("prefix=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeTagsParams(r)
+			return err
+		})
+	})
+}
+
+func TestDeserializeEventsParamsRandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"count", "fail_at"}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserializeEventsParams(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserializeEventsParams(f *testing.F) {
+	f.Add("")
+	f.Add("count=1&fail_at=1")
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserializeEventsParams(r)
+			return err
+		})
+	})
+}
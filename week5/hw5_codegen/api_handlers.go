@@ -1,22 +1,172 @@
-package main
-
-import "fmt"
-import "net/http"
-import "strconv"
-import "encoding/json"
+// Code generated by handlers_gen. DO NOT EDIT.
 
+package main
 
+import (
+	"./catalog"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type finalResponse struct {
-	Error string `json:"error"`
+	Error    string      `json:"error"`
 	Response interface{} `json:"response,omitempty"`
 }
 
-func checkAuth(w http.ResponseWriter, r *http.Request) error {
-	if (r.Header.Get("X-Auth") != "100500") {
-		return ApiError{http.StatusForbidden, fmt.Errorf("unauthorized")}
+const maxMultipartMemory = 32 << 20
+
+// requestParams reads params from the request and puts path params over them
+func requestParams(r *http.Request) (url.Values, error) {
+	params, err := requestBodyParams(r)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	for name, vals := range PathParams(r) {
+		params[name] = vals
+	}
+	return params, nil
+}
+
+// requestBodyParams picks where params come from by http method and Content-Type
+func requestBodyParams(r *http.Request) (url.Values, error) {
+	if r.Method == "GET" {
+		return r.URL.Query(), nil
+	}
+
+	var mediaType string
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, ApiError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported media type")}
+		}
+	}
+
+	switch mediaType {
+	case "", "application/x-www-form-urlencoded":
+		err := r.ParseForm()
+		if err != nil {
+			return nil, formError(err)
+		}
+		return r.Form, nil
+	case "multipart/form-data":
+		err := r.ParseMultipartForm(maxMultipartMemory)
+		if err != nil {
+			return nil, formError(err)
+		}
+		return r.Form, nil
+	case "application/json":
+		return jsonParams(r)
+	}
+	return nil, ApiError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported media type %s", mediaType)}
+}
+
+// jsonParams flattens a json object body into params so the same validation applies to it:
+// arrays become repeated params and nested objects become "parent.child" params,
+// url query params are used for keys missing in the body
+func jsonParams(r *http.Request) (url.Values, error) {
+	body := map[string]json.RawMessage{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("cant unpack json body: %s", err)}
+	}
+
+	bodyParams := url.Values{}
+	for name, raw := range body {
+		flattenJsonParam(bodyParams, name, raw)
+	}
+
+	params := r.URL.Query()
+	for name, vals := range bodyParams {
+		if len(vals) == 0 {
+			params.Del(name)
+		} else {
+			params[name] = vals
+		}
+	}
+	return params, nil
+}
+
+func flattenJsonParam(params url.Values, name string, raw json.RawMessage) {
+	var str string
+	var arr []json.RawMessage
+	var obj map[string]json.RawMessage
+	switch {
+	case string(raw) == "null":
+		if _, exists := params[name]; !exists {
+			params[name] = []string{}
+		}
+	case json.Unmarshal(raw, &str) == nil:
+		params.Add(name, str)
+	case json.Unmarshal(raw, &arr) == nil:
+		for _, item := range arr {
+			flattenJsonParam(params, name, item)
+		}
+	case json.Unmarshal(raw, &obj) == nil:
+		for key, item := range obj {
+			flattenJsonParam(params, name+"."+key, item)
+		}
+	default:
+		params.Add(name, string(raw))
+	}
+}
+
+// validationErrors collects errors of all fields so they are reported at once
+type validationErrors []error
+
+func (errs *validationErrors) add(err error) {
+	if err != nil {
+		*errs = append(*errs, err)
+	}
+}
+
+func (errs validationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, ", ")
+}
+
+func fieldError(prefix string, msg string) error {
+	return fmt.Errorf("%s%s", prefix, msg)
+}
+
+// ApiValidatorFunc checks a single value of a field tagged with validator=name
+type ApiValidatorFunc func(value interface{}) error
+
+var apiValidators = map[string]ApiValidatorFunc{}
+
+// RegisterApiValidator makes a validator available to apivalidator tags,
+// it is not safe for concurrent use and should be called on init
+func RegisterApiValidator(name string, validator ApiValidatorFunc) {
+	apiValidators[name] = validator
+}
+
+func runApiValidator(name string, value interface{}) error {
+	validator, exists := apiValidators[name]
+	if !exists {
+		return fmt.Errorf("has unknown validator %s", name)
+	}
+	return validator(value)
 }
 
 func handleError(err error, w http.ResponseWriter) {
@@ -31,31 +181,791 @@ func handleError(err error, w http.ResponseWriter) {
 		httpStatus = http.StatusInternalServerError
 	}
 	resp := finalResponse{
-		Error: errorText,
+		Error:    errorText,
 		Response: nil,
 	}
 	respText, _ := json.Marshal(resp)
-	w.WriteHeader(httpStatus)
+	w.WriteHeader(httpStatus)
+	w.Write([]byte(respText))
+}
+
+// Principal is the authenticated caller, handlers put it into the context passed to api methods
+type Principal struct {
+	ID    string
+	Roles []string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalCtxKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(*Principal)
+	return p, ok
+}
+
+// Authenticator finds out who made the request, nil principal and nil error mean anonymous request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorProvider is implemented by api structs that bring their own authentication,
+// others get DefaultAuthenticator
+type AuthenticatorProvider interface {
+	Authenticator() Authenticator
+}
+
+// TokenLookupFunc resolves a token or a session id into a principal, nil if it is unknown
+type TokenLookupFunc func(ctx context.Context, token string) (*Principal, error)
+
+// TokenMap is the simplest TokenLookupFunc source
+type TokenMap map[string]*Principal
+
+func (tm TokenMap) Lookup(ctx context.Context, token string) (*Principal, error) {
+	return tm[token], nil
+}
+
+// HeaderTokenAuthenticator takes the token as is from a header
+type HeaderTokenAuthenticator struct {
+	Header string
+	Lookup TokenLookupFunc
+}
+
+func (a HeaderTokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get(a.Header)
+	if token == "" {
+		return nil, nil
+	}
+	return a.Lookup(r.Context(), token)
+}
+
+// BearerAuthenticator takes the token from "Authorization: Bearer <token>"
+type BearerAuthenticator struct {
+	Lookup TokenLookupFunc
+}
+
+func (a BearerAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, nil
+	}
+	return a.Lookup(r.Context(), header[len(prefix):])
+}
+
+// CookieSessionAuthenticator takes the session id from a cookie
+type CookieSessionAuthenticator struct {
+	Cookie string
+	Lookup TokenLookupFunc
+}
+
+func (a CookieSessionAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	cookie, err := r.Cookie(a.Cookie)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	return a.Lookup(r.Context(), cookie.Value)
+}
+
+const (
+	hmacKeyIDHeader     = "X-Key-Id"
+	hmacTimestampHeader = "X-Timestamp"
+	hmacSignatureHeader = "X-Signature"
+)
+
+// HMACKeyFunc returns the secret of a key and the principal the key belongs to
+type HMACKeyFunc func(ctx context.Context, keyID string) (secret []byte, p *Principal, err error)
+
+// HMACAuthenticator checks requests signed with SignRequest
+type HMACAuthenticator struct {
+	Keys HMACKeyFunc
+	// how old a signature may be, a minute if not set
+	MaxSkew time.Duration
+}
+
+func (a HMACAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(hmacKeyIDHeader)
+	if keyID == "" {
+		return nil, nil
+	}
+
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = time.Minute
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(hmacTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, nil
+	}
+
+	secret, p, err := a.Keys(r.Context(), keyID)
+	if err != nil || p == nil {
+		return nil, err
+	}
+	expected, err := requestSignature(r, secret)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(r.Header.Get(hmacSignatureHeader))
+	if err != nil || !hmac.Equal(signature, expected) {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// SignRequest signs method, url, timestamp and body of the request for HMACAuthenticator
+func SignRequest(r *http.Request, keyID string, secret []byte) error {
+	r.Header.Set(hmacKeyIDHeader, keyID)
+	r.Header.Set(hmacTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	signature, err := requestSignature(r, secret)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(hmacSignatureHeader, hex.EncodeToString(signature))
+	return nil
+}
+
+func requestSignature(r *http.Request, secret []byte) ([]byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n" + r.Header.Get(hmacTimestampHeader) + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+// AuthenticatorChain asks authenticators in order, the first found principal wins
+type AuthenticatorChain []Authenticator
+
+func (chain AuthenticatorChain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range chain {
+		p, err := a.Authenticate(r)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+// DefaultAuthenticator accepts the X-Auth: 100500 header
+var DefaultAuthenticator Authenticator = HeaderTokenAuthenticator{
+	Header: "X-Auth",
+	Lookup: TokenMap{"100500": &Principal{ID: "100500"}}.Lookup,
+}
+
+func authenticate(api interface{}, r *http.Request, role string) (context.Context, error) {
+	authenticator := DefaultAuthenticator
+	if provider, ok := api.(AuthenticatorProvider); ok {
+		authenticator = provider.Authenticator()
+	}
+
+	p, err := authenticator.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("unauthorized")}
+	}
+	if role != "" && !p.HasRole(role) {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("role %s required", role)}
+	}
+	return ContextWithPrincipal(r.Context(), p), nil
+}
+
+type route struct {
+	method string
+	path   string
+	id     int
+}
+
+// routeNode is a node of the path trie, one level per path segment
+type routeNode struct {
+	static    map[string]*routeNode
+	param     *routeNode
+	paramName string
+	// route ids by http method, "" serves the rest of methods
+	routes map[string]int
+}
+
+func newRouter(routes ...route) *routeNode {
+	root := &routeNode{}
+	for _, rt := range routes {
+		node := root
+		for _, segment := range strings.Split(rt.path[1:], "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				if node.param == nil {
+					node.param = &routeNode{paramName: segment[1 : len(segment)-1]}
+				}
+				node = node.param
+				continue
+			}
+			if node.static == nil {
+				node.static = make(map[string]*routeNode)
+			}
+			child, exists := node.static[segment]
+			if !exists {
+				child = &routeNode{}
+				node.static[segment] = child
+			}
+			node = child
+		}
+		if node.routes == nil {
+			node.routes = make(map[string]int)
+		}
+		node.routes[rt.method] = rt.id
+	}
+	return root
+}
+
+// lookup prefers static segments over params and backtracks if the static branch is a dead end
+func (n *routeNode) lookup(segments []string, pathParams url.Values) *routeNode {
+	if len(segments) == 0 {
+		if len(n.routes) == 0 {
+			return nil
+		}
+		return n
+	}
+	if child, exists := n.static[segments[0]]; exists {
+		if found := child.lookup(segments[1:], pathParams); found != nil {
+			return found
+		}
+	}
+	if n.param != nil && segments[0] != "" {
+		if found := n.param.lookup(segments[1:], pathParams); found != nil {
+			pathParams.Set(n.param.paramName, segments[0])
+			return found
+		}
+	}
+	return nil
+}
+
+// match finds the route of the request and puts path params into its context,
+// the error response is already written if the route is not found
+func (n *routeNode) match(w http.ResponseWriter, r *http.Request) (int, *http.Request, bool) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for idx, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			handleError(ApiError{http.StatusNotFound, fmt.Errorf("unknown method")}, w)
+			return 0, r, false
+		}
+		segments[idx] = unescaped
+	}
+
+	pathParams := url.Values{}
+	node := n.lookup(segments, pathParams)
+	if node == nil {
+		handleError(ApiError{http.StatusNotFound, fmt.Errorf("unknown method")}, w)
+		return 0, r, false
+	}
+
+	id, exists := node.routes[r.Method]
+	if !exists {
+		id, exists = node.routes[""]
+	}
+	if !exists {
+		allowed := make([]string, 0, len(node.routes))
+		for method := range node.routes {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		handleError(ApiError{http.StatusMethodNotAllowed, fmt.Errorf("bad method")}, w)
+		return 0, r, false
+	}
+
+	if len(pathParams) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsCtxKey{}, pathParams))
+	}
+	return id, r, true
+}
+
+type pathParamsCtxKey struct{}
+
+// PathParams returns values of {name} url segments matched by the router
+func PathParams(r *http.Request) url.Values {
+	pathParams, _ := r.Context().Value(pathParamsCtxKey{}).(url.Values)
+	return pathParams
+}
+
+// Middleware wraps the generated ServeHTTP of an api
+type Middleware func(next http.Handler) http.Handler
+
+// MiddlewareProvider is implemented by api structs that want middlewares, the first one is the outermost.
+// It is called on every request, so the middlewares should be created once and only returned here
+type MiddlewareProvider interface {
+	Middlewares() []Middleware
+}
+
+func serveWithMiddlewares(api interface{}, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	provider, ok := api.(MiddlewareProvider)
+	if !ok {
+		next(w, r)
+		return
+	}
+	var handler http.Handler = next
+	middlewares := provider.Middlewares()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// RecoverMiddleware turns a panic into the 500 response
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				handleError(fmt.Errorf("internal error"), w)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ApiHooks are called by the generated handler of an api method, any of them may be nil
+type ApiHooks struct {
+	// BeforeValidate runs after authentication, an error stops the request
+	BeforeValidate func(ctx context.Context, r *http.Request) error
+	// AfterCall sees params, result and error of the method and returns the error to respond with
+	AfterCall func(ctx context.Context, params interface{}, result interface{}, err error) error
+	// OnError may replace any error of the handler before it is written to the response, nil keeps the error
+	OnError func(ctx context.Context, err error) error
+}
+
+// HooksProvider is implemented by api structs that want hooks, method is the name of the api method
+type HooksProvider interface {
+	Hooks(method string) ApiHooks
+}
+
+func hooksFor(api interface{}, method string) ApiHooks {
+	if provider, ok := api.(HooksProvider); ok {
+		return provider.Hooks(method)
+	}
+	return ApiHooks{}
+}
+
+func (hooks ApiHooks) beforeValidate(ctx context.Context, r *http.Request) error {
+	if hooks.BeforeValidate == nil {
+		return nil
+	}
+	return hooks.BeforeValidate(ctx, r)
+}
+
+func (hooks ApiHooks) afterCall(ctx context.Context, params interface{}, result interface{}, err error) error {
+	if hooks.AfterCall == nil {
+		return err
+	}
+	return hooks.AfterCall(ctx, params, result, err)
+}
+
+func (hooks ApiHooks) fail(ctx context.Context, err error, w http.ResponseWriter) {
+	if hooks.OnError != nil {
+		if replaced := hooks.OnError(ctx, err); replaced != nil {
+			err = replaced
+		}
+	}
+	handleError(err, w)
+}
+
+// streamWriter writes items of a streaming method as soon as they come,
+// every item is a finalResponse, a line of NDJSON or data of a Server-Sent Event
+type streamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+}
+
+func startStream(w http.ResponseWriter, format string) *streamWriter {
+	stream := &streamWriter{w: w, sse: format == "sse"}
+	stream.flusher, _ = w.(http.Flusher)
+	if stream.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	stream.flush()
+	return stream
+}
+
+func (s *streamWriter) send(item interface{}) error {
+	data, err := json.Marshal(finalResponse{Response: item})
+	if err != nil {
+		s.fail(err)
+		return err
+	}
+	return s.write("", data)
+}
+
+// fail ends the stream with an error, the status is already sent, so the error goes to the body
+func (s *streamWriter) fail(err error) {
+	data, _ := json.Marshal(finalResponse{Error: err.Error()})
+	s.write("error", data)
+}
+
+func (s *streamWriter) write(event string, data []byte) error {
+	var buf bytes.Buffer
+	if s.sse {
+		if event != "" {
+			buf.WriteString("event: " + event + "\n")
+		}
+		buf.WriteString("data: ")
+		buf.Write(data)
+		buf.WriteString("\n\n")
+	} else {
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+	_, err := s.w.Write(buf.Bytes())
+	s.flush()
+	return err
+}
+
+func (s *streamWriter) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// formError tells a too large body from a broken one
+func formError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ApiError{http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large")}
+	}
+	return ApiError{http.StatusBadRequest, fmt.Errorf("cant parse form: %s", err)}
+}
+
+func requestFiles(r *http.Request) map[string][]*multipart.FileHeader {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	return r.MultipartForm.File
+}
+
+// closeUploads closes files opened for io.Reader and multipart.File params
+func closeUploads(uploads ...interface{}) {
+	for _, upload := range uploads {
+		if closer, ok := upload.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+func (api *MyApi) handlerProfile(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Profile")
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeProfileParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Profile(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	resp := finalResponse{
+		Error:    "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(respText))
+}
+
+func (api *MyApi) handlerCreate(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Create")
+
+	// checking authentication
+	authCtx, err := authenticate(api, r, "")
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	ctx = authCtx
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeCreateParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Create(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	resp := finalResponse{
+		Error:    "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(respText))
+}
+
+func (api *OtherApi) handlerCreate(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Create")
+
+	// checking authentication
+	authCtx, err := authenticate(api, r, "")
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	ctx = authCtx
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeOtherCreateParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Create(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	resp := finalResponse{
+		Error:    "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(respText))
+}
+
+func (api *SearchApi) handlerSearch(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Search")
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeSearchParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Search(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	resp := finalResponse{
+		Error:    "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(respText))
+}
+
+func (api *AdminApi) handlerWhoami(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Whoami")
+
+	// checking authentication
+	authCtx, err := authenticate(api, r, "")
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	ctx = authCtx
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeWhoamiParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Whoami(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	resp := finalResponse{
+		Error:    "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(respText))
+}
+
+func (api *AdminApi) handlerBan(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Ban")
+
+	// checking authentication
+	authCtx, err := authenticate(api, r, "admin")
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	ctx = authCtx
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeBanParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Ban(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	resp := finalResponse{
+		Error:    "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(respText))
+}
+
+func (api *AdminApi) handlerBanStatus(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "BanStatus")
+
+	// checking authentication
+	authCtx, err := authenticate(api, r, "")
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	ctx = authCtx
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeUserParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.BanStatus(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	resp := finalResponse{
+		Error:    "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(respText))
 }
 
-
-func (api *MyApi) handlerProfile(w http.ResponseWriter, r *http.Request) {
+func (api *AdminApi) handlerUnban(w http.ResponseWriter, r *http.Request) {
 	var err error
-	
-	
-	deserializedParams, err := deserializeProfileParams(r)
+	ctx := r.Context()
+	hooks := hooksFor(api, "Unban")
+
+	// checking authentication
+	authCtx, err := authenticate(api, r, "admin")
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	ctx = authCtx
+
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+
+	deserializedParams, err := deserializeUserParams(r)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
-	result, err := api.Profile(r.Context(), deserializedParams)
+	result, err := api.Unban(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
 	resp := finalResponse{
-		Error: "",
+		Error:    "",
 		Response: result,
 	}
 	respText, _ := json.Marshal(resp)
@@ -63,35 +973,30 @@ func (api *MyApi) handlerProfile(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(respText))
 }
 
-func (api *MyApi) handlerCreate(w http.ResponseWriter, r *http.Request) {
+func (api *CatalogApi) handlerItems(w http.ResponseWriter, r *http.Request) {
 	var err error
-	
-	// checking http method
-	if r.Method != "POST" {
-		handleError(ApiError{http.StatusNotAcceptable,fmt.Errorf("bad method")}, w)
-		return
-	}
+	ctx := r.Context()
+	hooks := hooksFor(api, "Items")
 
-	
-	// checking authentication
-	err = checkAuth(w, r)
+	err = hooks.beforeValidate(ctx, r)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
 
-	deserializedParams, err := deserializeCreateParams(r)
+	deserializedParams, err := deserializeCatalogListParams(r)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
-	result, err := api.Create(r.Context(), deserializedParams)
+	result, err := api.Items(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
 	resp := finalResponse{
-		Error: "",
+		Error:    "",
 		Response: result,
 	}
 	respText, _ := json.Marshal(resp)
@@ -99,236 +1004,993 @@ func (api *MyApi) handlerCreate(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(respText))
 }
 
-func (api *OtherApi) handlerCreate(w http.ResponseWriter, r *http.Request) {
+func (api *MediaApi) handlerUpload(w http.ResponseWriter, r *http.Request) {
 	var err error
-	
-	// checking http method
-	if r.Method != "POST" {
-		handleError(ApiError{http.StatusNotAcceptable,fmt.Errorf("bad method")}, w)
-		return
-	}
+	ctx := r.Context()
+	hooks := hooksFor(api, "Upload")
 
-	
-	// checking authentication
-	err = checkAuth(w, r)
+	err = hooks.beforeValidate(ctx, r)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
 
-	deserializedParams, err := deserializeOtherCreateParams(r)
+	// uploads are limited by their maxsize, the rest of the form by 1048576 bytes
+	r.Body = http.MaxBytesReader(w, r.Body, 1049856)
+
+	deserializedParams, err := deserializeUploadParams(r)
+	defer closeUploads(deserializedParams.File)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
-	result, err := api.Create(r.Context(), deserializedParams)
+	result, err := api.Upload(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
 	resp := finalResponse{
-		Error: "",
+		Error:    "",
 		Response: result,
 	}
 	respText, _ := json.Marshal(resp)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(respText))
 }
-//Deserializer for struct ProfileParams
 
-func deserializeProfileParams(r *http.Request) (ProfileParams, error) {
-	model := ProfileParams{}
-	
-	var valStr string
+func (api *MediaApi) handlerProgress(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Progress")
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("login")
-	} else {
-		valStr = r.FormValue("login")
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
 	}
 
-	if valStr == "" {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("login must me not empty")}
+	deserializedParams, err := deserializeProgressParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Progress(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	stream := startStream(w, "ndjson")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item, ok := <-result:
+			if !ok {
+				return
+			}
+			if stream.send(item) != nil {
+				return
+			}
+		}
 	}
+}
 
-	model.Login = valStr
+func (api *MediaApi) handlerTags(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Tags")
 
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
 
-	return model, nil
+	deserializedParams, err := deserializeTagsParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Tags(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	stream := startStream(w, "ndjson")
+	if result != nil {
+		result(func(item string) bool {
+			return stream.send(item) == nil && ctx.Err() == nil
+		})
+	}
 }
-//Deserializer for struct CreateParams
 
-func deserializeCreateParams(r *http.Request) (CreateParams, error) {
-	model := CreateParams{}
-	
-	var valStr string
+func (api *MediaApi) handlerEvents(w http.ResponseWriter, r *http.Request) {
+	var err error
+	ctx := r.Context()
+	hooks := hooksFor(api, "Events")
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("login")
-	} else {
-		valStr = r.FormValue("login")
+	err = hooks.beforeValidate(ctx, r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
 	}
 
-	if valStr == "" {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("login must me not empty")}
+	deserializedParams, err := deserializeEventsParams(r)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	result, err := api.Events(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
 	}
+	stream := startStream(w, "sse")
+	if result != nil {
+		result(func(item Event, err error) bool {
+			if err != nil {
+				stream.fail(err)
+				return false
+			}
+			return stream.send(item) == nil && ctx.Err() == nil
+		})
+	}
+}
 
-	model.Login = valStr
+//Deserializer for struct ProfileParams
 
-	if len(model.Login) < 10 {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("login len must be >= 10")}
+func deserializeProfileParams(r *http.Request) (ProfileParams, error) {
+	model := ProfileParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
 	}
+	errs := validationErrors{}
+	deserializeProfileParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
+	return model, nil
+}
 
+func deserializeProfileParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *ProfileParams, errs *validationErrors) {
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("full_name")
-	} else {
-		valStr = r.FormValue("full_name")
+	// Login
+	errs.add(func() error {
+		valStr := params.Get(prefix + "login")
+		if valStr == "" {
+			return fieldError(prefix, "login must me not empty")
+		}
+		v := valStr
+		model.Login = v
+		return nil
+	}())
+}
+
+//Deserializer for struct CreateParams
+
+func deserializeCreateParams(r *http.Request) (CreateParams, error) {
+	model := CreateParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
 	}
+	errs := validationErrors{}
+	deserializeCreateParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
+	return model, nil
+}
 
-	model.Name = valStr
+func deserializeCreateParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *CreateParams, errs *validationErrors) {
 
+	// Login
+	errs.add(func() error {
+		valStr := params.Get(prefix + "login")
+		if valStr == "" {
+			return fieldError(prefix, "login must me not empty")
+		}
+		v := valStr
+		if len(v) < 10 {
+			return fieldError(prefix, "login len must be >= 10")
+		}
+		model.Login = v
+		return nil
+	}())
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("status")
-	} else {
-		valStr = r.FormValue("status")
-	}
+	// Name
+	errs.add(func() error {
+		valStr := params.Get(prefix + "full_name")
+		v := valStr
+		model.Name = v
+		return nil
+	}())
 
-	
-	if valStr == "" {
-		valStr = "user"
-	}
+	// Status
+	errs.add(func() error {
+		valStr := params.Get(prefix + "status")
+		if valStr == "" {
+			valStr = "user"
+		}
+		switch valStr {
+		case "user", "moderator", "admin":
+			//ok!
+		default:
+			return fieldError(prefix, "status must be one of [user, moderator, admin]")
+		}
+		v := valStr
+		model.Status = v
+		return nil
+	}())
 
-	switch (valStr) {
-	case "user", "moderator", "admin":
-		//ok!
-	default:
-		err := fmt.Errorf("status must be one of [user, moderator, admin]")
-		return model, ApiError{http.StatusBadRequest, err}
+	// Age
+	errs.add(func() error {
+		valStr := params.Get(prefix + "age")
+		if valStr == "" {
+			return nil
+		}
+		v, convErr := strconv.Atoi(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "age must be int")
+		}
+		if v < 0 {
+			return fieldError(prefix, "age must be >= 0")
+		}
+		if v > 128 {
+			return fieldError(prefix, "age must be <= 128")
+		}
+		model.Age = v
+		return nil
+	}())
+}
+
+//Deserializer for struct OtherCreateParams
+
+func deserializeOtherCreateParams(r *http.Request) (OtherCreateParams, error) {
+	model := OtherCreateParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
+	}
+	errs := validationErrors{}
+	deserializeOtherCreateParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
 	}
+	return model, nil
+}
 
-	model.Status = valStr
+func deserializeOtherCreateParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *OtherCreateParams, errs *validationErrors) {
 
+	// Username
+	errs.add(func() error {
+		valStr := params.Get(prefix + "username")
+		if valStr == "" {
+			return fieldError(prefix, "username must me not empty")
+		}
+		v := valStr
+		if len(v) < 3 {
+			return fieldError(prefix, "username len must be >= 3")
+		}
+		model.Username = v
+		return nil
+	}())
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("age")
-	} else {
-		valStr = r.FormValue("age")
-	}
+	// Name
+	errs.add(func() error {
+		valStr := params.Get(prefix + "account_name")
+		v := valStr
+		model.Name = v
+		return nil
+	}())
 
-	var convErr error
-	model.Age, convErr = strconv.Atoi(valStr)
-	if convErr != nil {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("age must be int")}
-	}
+	// Class
+	errs.add(func() error {
+		valStr := params.Get(prefix + "class")
+		if valStr == "" {
+			valStr = "warrior"
+		}
+		switch valStr {
+		case "warrior", "sorcerer", "rouge":
+			//ok!
+		default:
+			return fieldError(prefix, "class must be one of [warrior, sorcerer, rouge]")
+		}
+		v := valStr
+		model.Class = v
+		return nil
+	}())
 
-	if model.Age < 0 {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("age must be >= 0")}
-	}
+	// Level
+	errs.add(func() error {
+		valStr := params.Get(prefix + "level")
+		if valStr == "" {
+			return nil
+		}
+		v, convErr := strconv.Atoi(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "level must be int")
+		}
+		if v < 1 {
+			return fieldError(prefix, "level must be >= 1")
+		}
+		if v > 50 {
+			return fieldError(prefix, "level must be <= 50")
+		}
+		model.Level = v
+		return nil
+	}())
+}
 
-	if model.Age > 128 {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("age must be <= 128")}
-	}
+//Deserializer for struct PeriodParams
 
+func deserializePeriodParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *PeriodParams, errs *validationErrors) {
 
-	return model, nil
+	// From
+	errs.add(func() error {
+		valStr := params.Get(prefix + "from")
+		if valStr == "" {
+			return fieldError(prefix, "from must me not empty")
+		}
+		if valStr == "" {
+			return nil
+		}
+		v, convErr := time.Parse("2006-01-02T15:04:05Z07:00", valStr)
+		if convErr != nil {
+			return fieldError(prefix, "from must be time in format 2006-01-02T15:04:05Z07:00")
+		}
+		model.From = v
+		return nil
+	}())
+
+	// To
+	errs.add(func() error {
+		valStr := params.Get(prefix + "to")
+		if valStr == "" {
+			return nil
+		}
+		v, convErr := time.Parse("2006-01-02", valStr)
+		if convErr != nil {
+			return fieldError(prefix, "to must be time in format 2006-01-02")
+		}
+		model.To = v
+		return nil
+	}())
 }
-//Deserializer for struct OtherCreateParams
 
-func deserializeOtherCreateParams(r *http.Request) (OtherCreateParams, error) {
-	model := OtherCreateParams{}
-	
-	var valStr string
+//Deserializer for struct SearchParams
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("username")
-	} else {
-		valStr = r.FormValue("username")
-	}
+var apivalidatorSearchParamsQueryRegexp = regexp.MustCompile("^[a-z_.]*$")
 
-	if valStr == "" {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("username must me not empty")}
+func deserializeSearchParams(r *http.Request) (SearchParams, error) {
+	model := SearchParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
 	}
+	errs := validationErrors{}
+	deserializeSearchParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
+	return model, nil
+}
 
-	model.Username = valStr
+func deserializeSearchParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *SearchParams, errs *validationErrors) {
 
-	if len(model.Username) < 3 {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("username len must be >= 3")}
-	}
+	// Query
+	errs.add(func() error {
+		valStr := params.Get(prefix + "query")
+		v := valStr
+		if v != "" && !apivalidatorSearchParamsQueryRegexp.MatchString(v) {
+			return fieldError(prefix, "query must match ^[a-z_.]*$")
+		}
+		model.Query = v
+		return nil
+	}())
 
+	// Email
+	errs.add(func() error {
+		valStr := params.Get(prefix + "email")
+		v := valStr
+		if v != "" && !isEmail(v) {
+			return fieldError(prefix, "email must be email")
+		}
+		model.Email = v
+		return nil
+	}())
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("account_name")
-	} else {
-		valStr = r.FormValue("account_name")
-	}
+	// Phone
+	errs.add(func() error {
+		valStr := params.Get(prefix + "phone")
+		v := valStr
+		if v != "" {
+			if err := runApiValidator("phone", v); err != nil {
+				return fieldError(prefix, "phone "+err.Error())
+			}
+		}
+		model.Phone = v
+		return nil
+	}())
 
-	model.Name = valStr
+	// Country
+	errs.add(func() error {
+		valStr := params.Get(prefix + "country")
+		if valStr == "" {
+			valStr = "RU"
+		}
+		v := valStr
+		if len(v) != 2 {
+			return fieldError(prefix, "country len must be 2")
+		}
+		model.Country = v
+		return nil
+	}())
 
+	// Rating
+	errs.add(func() error {
+		valStr := params.Get(prefix + "rating")
+		if valStr == "" {
+			return nil
+		}
+		parsed, convErr := strconv.ParseFloat(valStr, 64)
+		if convErr != nil {
+			return fieldError(prefix, "rating must be float64")
+		}
+		v := float64(parsed)
+		if v < 0 {
+			return fieldError(prefix, "rating must be >= 0")
+		}
+		if v > 5 {
+			return fieldError(prefix, "rating must be <= 5")
+		}
+		model.Rating = v
+		return nil
+	}())
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("class")
-	} else {
-		valStr = r.FormValue("class")
+	// Verified
+	errs.add(func() error {
+		valStr := params.Get(prefix + "verified")
+		if valStr == "" {
+			valStr = "true"
+		}
+		v, convErr := strconv.ParseBool(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "verified must be bool")
+		}
+		model.Verified = v
+		return nil
+	}())
+
+	// Statuses
+	errs.add(func() error {
+		vals := params[prefix+"status"]
+		model.Statuses = make([]string, 0, len(vals))
+		for _, valStr := range vals {
+			switch valStr {
+			case "user", "moderator", "admin":
+				//ok!
+			default:
+				return fieldError(prefix, "status must be one of [user, moderator, admin]")
+			}
+			v := valStr
+			model.Statuses = append(model.Statuses, v)
+		}
+		return nil
+	}())
+
+	// IDs
+	errs.add(func() error {
+		vals := params[prefix+"id"]
+		model.IDs = make([]uint64, 0, len(vals))
+		for _, valStr := range vals {
+			parsed, convErr := strconv.ParseUint(valStr, 10, 64)
+			if convErr != nil {
+				return fieldError(prefix, "id must be uint64")
+			}
+			v := uint64(parsed)
+			if v > 1000 {
+				return fieldError(prefix, "id must be <= 1000")
+			}
+			model.IDs = append(model.IDs, v)
+		}
+		return nil
+	}())
+
+	// Period
+	deserializePeriodParamsFields(params, files, prefix+"period.", &model.Period, errs)
+}
+
+//Deserializer for struct WhoamiParams
+
+func deserializeWhoamiParams(r *http.Request) (WhoamiParams, error) {
+	model := WhoamiParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
 	}
+	errs := validationErrors{}
+	deserializeWhoamiParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
+	return model, nil
+}
+
+func deserializeWhoamiParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *WhoamiParams, errs *validationErrors) {
+}
+
+//Deserializer for struct BanParams
 
-	
-	if valStr == "" {
-		valStr = "warrior"
+func deserializeBanParams(r *http.Request) (BanParams, error) {
+	model := BanParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
+	}
+	errs := validationErrors{}
+	deserializeBanParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
 	}
+	return model, nil
+}
 
-	switch (valStr) {
-	case "warrior", "sorcerer", "rouge":
-		//ok!
-	default:
-		err := fmt.Errorf("class must be one of [warrior, sorcerer, rouge]")
-		return model, ApiError{http.StatusBadRequest, err}
+func deserializeBanParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *BanParams, errs *validationErrors) {
+
+	// Login
+	errs.add(func() error {
+		valStr := params.Get(prefix + "login")
+		if valStr == "" {
+			return fieldError(prefix, "login must me not empty")
+		}
+		v := valStr
+		model.Login = v
+		return nil
+	}())
+}
+
+//Deserializer for struct UserParams
+
+func deserializeUserParams(r *http.Request) (UserParams, error) {
+	model := UserParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
 	}
+	errs := validationErrors{}
+	deserializeUserParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
+	return model, nil
+}
 
-	model.Class = valStr
+func deserializeUserParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *UserParams, errs *validationErrors) {
 
+	// Login
+	errs.add(func() error {
+		valStr := params.Get(prefix + "login")
+		if valStr == "" {
+			return fieldError(prefix, "login must me not empty")
+		}
+		v := valStr
+		model.Login = v
+		return nil
+	}())
+}
 
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("level")
-	} else {
-		valStr = r.FormValue("level")
+//Deserializer for struct catalog.Page
+
+func deserializeCatalogPageFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *catalog.Page, errs *validationErrors) {
+
+	// Limit
+	errs.add(func() error {
+		valStr := params.Get(prefix + "limit")
+		if valStr == "" {
+			valStr = "10"
+		}
+		v, convErr := strconv.Atoi(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "limit must be int")
+		}
+		if v < 1 {
+			return fieldError(prefix, "limit must be >= 1")
+		}
+		if v > 100 {
+			return fieldError(prefix, "limit must be <= 100")
+		}
+		model.Limit = v
+		return nil
+	}())
+
+	// Offset
+	errs.add(func() error {
+		valStr := params.Get(prefix + "offset")
+		if valStr == "" {
+			return nil
+		}
+		v, convErr := strconv.Atoi(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "offset must be int")
+		}
+		if v < 0 {
+			return fieldError(prefix, "offset must be >= 0")
+		}
+		model.Offset = v
+		return nil
+	}())
+}
+
+//Deserializer for struct catalog.ListParams
+
+func deserializeCatalogListParams(r *http.Request) (catalog.ListParams, error) {
+	model := catalog.ListParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
+	}
+	errs := validationErrors{}
+	deserializeCatalogListParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
 	}
+	return model, nil
+}
+
+func deserializeCatalogListParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *catalog.ListParams, errs *validationErrors) {
+
+	// Category
+	errs.add(func() error {
+		valStr := params.Get(prefix + "category")
+		if valStr == "" {
+			return fieldError(prefix, "category must me not empty")
+		}
+		switch valStr {
+		case "books", "music":
+			//ok!
+		default:
+			return fieldError(prefix, "category must be one of [books, music]")
+		}
+		v := valStr
+		model.Category = v
+		return nil
+	}())
 
-	var convErr error
-	model.Level, convErr = strconv.Atoi(valStr)
-	if convErr != nil {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("level must be int")}
+	// Page
+	deserializeCatalogPageFields(params, files, prefix+"page.", &model.Page, errs)
+}
+
+//Deserializer for struct UploadParams
+
+func deserializeUploadParams(r *http.Request) (UploadParams, error) {
+	model := UploadParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
 	}
+	errs := validationErrors{}
+	deserializeUploadParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
+	return model, nil
+}
+
+func deserializeUploadParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *UploadParams, errs *validationErrors) {
 
-	if model.Level < 1 {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("level must be >= 1")}
+	// Title
+	errs.add(func() error {
+		valStr := params.Get(prefix + "title")
+		if valStr == "" {
+			return fieldError(prefix, "title must me not empty")
+		}
+		v := valStr
+		model.Title = v
+		return nil
+	}())
+
+	// File
+	errs.add(func() error {
+		fileHeaders := files[prefix+"file"]
+		if len(fileHeaders) == 0 {
+			return fieldError(prefix, "file must me not empty")
+		}
+		fileHeader := fileHeaders[0]
+		if fileHeader.Size > 1024 {
+			return fieldError(prefix, "file must be <= 1024 bytes")
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return fieldError(prefix, "file cant be opened")
+		}
+		model.File = file
+		return nil
+	}())
+
+	// Thumbnail
+	errs.add(func() error {
+		fileHeaders := files[prefix+"thumbnail"]
+		if len(fileHeaders) == 0 {
+			return nil
+		}
+		fileHeader := fileHeaders[0]
+		if fileHeader.Size > 256 {
+			return fieldError(prefix, "thumbnail must be <= 256 bytes")
+		}
+		model.Thumbnail = fileHeader
+		return nil
+	}())
+}
+
+//Deserializer for struct ProgressParams
+
+func deserializeProgressParams(r *http.Request) (ProgressParams, error) {
+	model := ProgressParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
 	}
+	errs := validationErrors{}
+	deserializeProgressParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
+	return model, nil
+}
+
+func deserializeProgressParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *ProgressParams, errs *validationErrors) {
+
+	// Steps
+	errs.add(func() error {
+		valStr := params.Get(prefix + "steps")
+		if valStr == "" {
+			valStr = "3"
+		}
+		v, convErr := strconv.Atoi(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "steps must be int")
+		}
+		if v < 1 {
+			return fieldError(prefix, "steps must be >= 1")
+		}
+		if v > 10 {
+			return fieldError(prefix, "steps must be <= 10")
+		}
+		model.Steps = v
+		return nil
+	}())
+}
 
-	if model.Level > 50 {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("level must be <= 50")}
+//Deserializer for struct TagsParams
+
+var apivalidatorTagsParamsPrefixRegexp = regexp.MustCompile("^[a-z]*$")
+
+func deserializeTagsParams(r *http.Request) (TagsParams, error) {
+	model := TagsParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
+	}
+	errs := validationErrors{}
+	deserializeTagsParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
 	}
+	return model, nil
+}
+
+func deserializeTagsParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *TagsParams, errs *validationErrors) {
+
+	// Prefix
+	errs.add(func() error {
+		valStr := params.Get(prefix + "prefix")
+		v := valStr
+		if v != "" && !apivalidatorTagsParamsPrefixRegexp.MatchString(v) {
+			return fieldError(prefix, "prefix must match ^[a-z]*$")
+		}
+		model.Prefix = v
+		return nil
+	}())
+}
 
+//Deserializer for struct EventsParams
 
+func deserializeEventsParams(r *http.Request) (EventsParams, error) {
+	model := EventsParams{}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
+	}
+	errs := validationErrors{}
+	deserializeEventsParamsFields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
 	return model, nil
 }
 
-func (h *MyApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	case "/user/profile":
+func deserializeEventsParamsFields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *EventsParams, errs *validationErrors) {
+
+	// Count
+	errs.add(func() error {
+		valStr := params.Get(prefix + "count")
+		if valStr == "" {
+			valStr = "3"
+		}
+		v, convErr := strconv.Atoi(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "count must be int")
+		}
+		if v < 0 {
+			return fieldError(prefix, "count must be >= 0")
+		}
+		if v > 100 {
+			return fieldError(prefix, "count must be <= 100")
+		}
+		model.Count = v
+		return nil
+	}())
+
+	// FailAt
+	errs.add(func() error {
+		valStr := params.Get(prefix + "fail_at")
+		if valStr == "" {
+			return nil
+		}
+		v, convErr := strconv.Atoi(valStr)
+		if convErr != nil {
+			return fieldError(prefix, "fail_at must be int")
+		}
+		if v < 0 {
+			return fieldError(prefix, "fail_at must be >= 0")
+		}
+		model.FailAt = v
+		return nil
+	}())
+}
+
+func isEmail(v string) bool {
+	addr, err := mail.ParseAddress(v)
+	return err == nil && addr.Address == v
+}
+
+var routerMyApi = newRouter(
+	route{"", "/user/profile", 0},
+	route{"POST", "/user/create", 1},
+)
+
+func (h *MyApi) route(w http.ResponseWriter, r *http.Request) {
+	id, r, ok := routerMyApi.match(w, r)
+	if !ok {
+		return
+	}
+	switch id {
+	case 0:
 		h.handlerProfile(w, r)
-	case "/user/create":
+	case 1:
 		h.handlerCreate(w, r)
-	default:
-		handleError(ApiError{http.StatusNotFound, fmt.Errorf("unknown method")}, w)
 	}
 }
 
-func (h *OtherApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	case "/user/create":
+func (h *MyApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithMiddlewares(h, w, r, h.route)
+}
+
+var routerOtherApi = newRouter(
+	route{"POST", "/user/create", 0},
+)
+
+func (h *OtherApi) route(w http.ResponseWriter, r *http.Request) {
+	id, r, ok := routerOtherApi.match(w, r)
+	if !ok {
+		return
+	}
+	switch id {
+	case 0:
 		h.handlerCreate(w, r)
-	default:
-		handleError(ApiError{http.StatusNotFound, fmt.Errorf("unknown method")}, w)
 	}
 }
+
+func (h *OtherApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithMiddlewares(h, w, r, h.route)
+}
+
+var routerSearchApi = newRouter(
+	route{"", "/user/search", 0},
+)
+
+func (h *SearchApi) route(w http.ResponseWriter, r *http.Request) {
+	id, r, ok := routerSearchApi.match(w, r)
+	if !ok {
+		return
+	}
+	switch id {
+	case 0:
+		h.handlerSearch(w, r)
+	}
+}
+
+func (h *SearchApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithMiddlewares(h, w, r, h.route)
+}
+
+var routerAdminApi = newRouter(
+	route{"", "/admin/whoami", 0},
+	route{"POST", "/admin/ban", 1},
+	route{"GET", "/admin/users/{login}", 2},
+	route{"DELETE", "/admin/users/{login}", 3},
+)
+
+func (h *AdminApi) route(w http.ResponseWriter, r *http.Request) {
+	id, r, ok := routerAdminApi.match(w, r)
+	if !ok {
+		return
+	}
+	switch id {
+	case 0:
+		h.handlerWhoami(w, r)
+	case 1:
+		h.handlerBan(w, r)
+	case 2:
+		h.handlerBanStatus(w, r)
+	case 3:
+		h.handlerUnban(w, r)
+	}
+}
+
+func (h *AdminApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithMiddlewares(h, w, r, h.route)
+}
+
+var routerCatalogApi = newRouter(
+	route{"GET", "/catalog/{category}/items", 0},
+)
+
+func (h *CatalogApi) route(w http.ResponseWriter, r *http.Request) {
+	id, r, ok := routerCatalogApi.match(w, r)
+	if !ok {
+		return
+	}
+	switch id {
+	case 0:
+		h.handlerItems(w, r)
+	}
+}
+
+func (h *CatalogApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithMiddlewares(h, w, r, h.route)
+}
+
+var routerMediaApi = newRouter(
+	route{"POST", "/media/upload", 0},
+	route{"GET", "/media/progress", 1},
+	route{"GET", "/media/tags", 2},
+	route{"GET", "/media/events", 3},
+)
+
+func (h *MediaApi) route(w http.ResponseWriter, r *http.Request) {
+	id, r, ok := routerMediaApi.match(w, r)
+	if !ok {
+		return
+	}
+	switch id {
+	case 0:
+		h.handlerUpload(w, r)
+	case 1:
+		h.handlerProgress(w, r)
+	case 2:
+		h.handlerTags(w, r)
+	case 3:
+		h.handlerEvents(w, r)
+	}
+}
+
+func (h *MediaApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithMiddlewares(h, w, r, h.route)
+}
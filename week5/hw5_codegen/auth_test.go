@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"./apiclient"
+)
+
+const (
+	ApiAdminWhoami = "/admin/whoami"
+	ApiAdminBan    = "/admin/ban"
+)
+
+func TestAdminApiAuth(t *testing.T) {
+	ts := httptest.NewServer(NewAdminApi())
+	defer ts.Close()
+
+	cases := []struct {
+		Path      string
+		Body      string
+		Authorize func(req *http.Request)
+		Status    int
+		Result    CR
+	}{
+		{
+			Path:   ApiAdminWhoami,
+			Status: http.StatusForbidden,
+			Result: CR{"error": "unauthorized"},
+		},
+		{ // the default X-Auth is replaced by the api authenticator
+			Path: ApiAdminWhoami,
+			Authorize: func(req *http.Request) {
+				req.Header.Set("X-Auth", "100500")
+			},
+			Status: http.StatusForbidden,
+			Result: CR{"error": "unauthorized"},
+		},
+		{
+			Path: ApiAdminWhoami,
+			Authorize: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer user-token")
+			},
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"id": "ivan", "roles": []string{"user"}}},
+		},
+		{
+			Path: ApiAdminWhoami,
+			Authorize: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "session", Value: "admin-session"})
+			},
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"id": "rvasily", "roles": []string{"admin"}}},
+		},
+		{
+			Path: ApiAdminBan,
+			Body: "login=bad_user",
+			Authorize: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer user-token")
+			},
+			Status: http.StatusForbidden,
+			Result: CR{"error": "role admin required"},
+		},
+		{
+			Path: ApiAdminBan,
+			Body: "login=bad_user",
+			Authorize: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer admin-token")
+			},
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "bad_user", "banned_by": "rvasily"}},
+		},
+		{
+			Path: ApiAdminBan,
+			Body: "login=bad_user",
+			Authorize: func(req *http.Request) {
+				SignRequest(req, "robot", []byte("robot-secret"))
+			},
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "bad_user", "banned_by": "robot"}},
+		},
+		{ // the body was changed after signing
+			Path: ApiAdminBan,
+			Body: "login=bad_user",
+			Authorize: func(req *http.Request) {
+				SignRequest(req, "robot", []byte("robot-secret"))
+				req.Body = http.NoBody
+			},
+			Status: http.StatusForbidden,
+			Result: CR{"error": "unauthorized"},
+		},
+		{
+			Path: ApiAdminBan,
+			Body: "login=bad_user",
+			Authorize: func(req *http.Request) {
+				SignRequest(req, "robot", []byte("wrong-secret"))
+			},
+			Status: http.StatusForbidden,
+			Result: CR{"error": "unauthorized"},
+		},
+	}
+
+	for idx, item := range cases {
+		var req *http.Request
+		if item.Body != "" {
+			req, _ = http.NewRequest(http.MethodPost, ts.URL+item.Path, strings.NewReader(item.Body))
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(http.MethodGet, ts.URL+item.Path, nil)
+		}
+		if item.Authorize != nil {
+			item.Authorize(req)
+		}
+
+		status, body := doRequest(t, req)
+		if status != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v: %s", idx, item.Status, status, body)
+			continue
+		}
+
+		var result, expected interface{}
+		json.Unmarshal([]byte(body), &result)
+		data, _ := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%d] results not match\nGot: %#v\nExpected: %#v", idx, result, expected)
+		}
+	}
+}
+
+func TestGeneratedClientAuthorize(t *testing.T) {
+	ts := httptest.NewServer(NewAdminApi())
+	defer ts.Close()
+
+	c := apiclient.NewAdminApiClient(ts.URL, "")
+	c.Authorize = func(req *http.Request) error {
+		return SignRequest(req, "robot", []byte("robot-secret"))
+	}
+
+	result, err := c.Ban(context.Background(), apiclient.BanParams{Login: "bad_user"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.BannedBy != "robot" {
+		t.Errorf("unexpected result: %#v", result)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"./catalog"
+)
+
+// 5-я часть
+// методы апи в отдельном файле, параметры и результат из другого пакета
+
+type CatalogApi struct {
+	items map[string][]catalog.Item
+}
+
+func NewCatalogApi() *CatalogApi {
+	return &CatalogApi{
+		items: map[string][]catalog.Item{
+			"books": {
+				{ID: 1, Title: "The Go Programming Language"},
+				{ID: 2, Title: "Concurrency in Go"},
+				{ID: 3, Title: "Go in Action"},
+			},
+			"music": {
+				{ID: 4, Title: "Kind of Blue"},
+			},
+		},
+	}
+}
+
+// apigen:api {"url": "/catalog/{category}/items", "method": "GET"}
+func (srv *CatalogApi) Items(ctx context.Context, in catalog.ListParams) ([]catalog.Item, error) {
+	items := srv.items[in.Category]
+	if in.Page.Offset >= len(items) {
+		return []catalog.Item{}, nil
+	}
+	items = items[in.Page.Offset:]
+	if in.Page.Limit < len(items) {
+		items = items[:in.Page.Limit]
+	}
+	return items, nil
+}
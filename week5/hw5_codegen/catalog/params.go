@@ -0,0 +1,16 @@
+package catalog
+
+type Page struct {
+	Limit  int `apivalidator:"default=10,min=1,max=100"`
+	Offset int `apivalidator:"min=0"`
+}
+
+type ListParams struct {
+	Category string `apivalidator:"required,enum=books|music"`
+	Page     Page   `apivalidator:"paramname=page"`
+}
+
+type Item struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"./apiclient"
+)
+
+func TestCatalogApi(t *testing.T) {
+	ts := httptest.NewServer(NewCatalogApi())
+	defer ts.Close()
+
+	cases := []struct {
+		Path   string
+		Status int
+		Body   string
+	}{
+		{
+			Path:   "/catalog/books/items?page.limit=2&page.offset=1",
+			Status: http.StatusOK,
+			Body:   `{"error":"","response":[{"id":2,"title":"Concurrency in Go"},{"id":3,"title":"Go in Action"}]}`,
+		},
+		{
+			Path:   "/catalog/music/items",
+			Status: http.StatusOK,
+			Body:   `{"error":"","response":[{"id":4,"title":"Kind of Blue"}]}`,
+		},
+		{
+			Path:   "/catalog/films/items?page.offset=first",
+			Status: http.StatusBadRequest,
+			Body:   `{"error":"category must be one of [books, music], page.offset must be int"}`,
+		},
+	}
+
+	for idx, item := range cases {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+item.Path, nil)
+		status, body := doRequest(t, req)
+		if status != item.Status || body != item.Body {
+			t.Errorf("[%d] expected %v %s, got %v %s", idx, item.Status, item.Body, status, body)
+		}
+	}
+}
+
+func TestGeneratedClientForeignParams(t *testing.T) {
+	ts := httptest.NewServer(NewCatalogApi())
+	defer ts.Close()
+
+	c := apiclient.NewCatalogApiClient(ts.URL, "")
+	items, err := c.Items(context.Background(), apiclient.ListParams{
+		Category: "books",
+		Page:     apiclient.Page{Limit: 1, Offset: 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []apiclient.Item{{ID: 3, Title: "Go in Action"}}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %#v, got %#v", expected, items)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"./apiclient"
+)
+
+func TestGeneratedMyApiClient(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+
+	c := apiclient.NewMyApiClient(ts.URL, "100500")
+	ctx := context.Background()
+
+	user, err := c.Profile(ctx, apiclient.ProfileParams{Login: "rvasily"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != 42 || user.FullName != "Vasily Romanov" {
+		t.Errorf("unexpected user: %#v", user)
+	}
+
+	newUser, err := c.Create(ctx, apiclient.CreateParams{
+		Login:  "client_created",
+		Name:   "Client Created",
+		Status: "moderator",
+		Age:    20,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	user, err = c.Profile(ctx, apiclient.ProfileParams{Login: "client_created"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != newUser.ID || user.Status != 10 {
+		t.Errorf("unexpected user: %#v", user)
+	}
+
+	_, err = c.Profile(ctx, apiclient.ProfileParams{Login: "not_exist_user"})
+	apiErr, ok := err.(apiclient.ApiError)
+	if !ok || apiErr.HTTPStatus != http.StatusNotFound || apiErr.Error() != "user not exist" {
+		t.Errorf("unexpected error: %#v", err)
+	}
+
+	_, err = c.Create(ctx, apiclient.CreateParams{Login: "short"})
+	apiErr, ok = err.(apiclient.ApiError)
+	if !ok || apiErr.HTTPStatus != http.StatusBadRequest || apiErr.Error() != "login len must be >= 10" {
+		t.Errorf("unexpected error: %#v", err)
+	}
+
+	unauthorized := apiclient.NewMyApiClient(ts.URL, "")
+	_, err = unauthorized.Create(ctx, apiclient.CreateParams{Login: "unauthorized_user"})
+	apiErr, ok = err.(apiclient.ApiError)
+	if !ok || apiErr.HTTPStatus != http.StatusForbidden {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
+
+func TestGeneratedOtherApiClient(t *testing.T) {
+	ts := httptest.NewServer(NewOtherApi())
+	defer ts.Close()
+
+	c := apiclient.NewOtherApiClient(ts.URL, "100500")
+
+	user, err := c.Create(context.Background(), apiclient.OtherCreateParams{
+		Username: "I3apBap",
+		Name:     "Vasily",
+		Class:    "warrior",
+		Level:    1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != 12 || user.Login != "I3apBap" || user.Level != 1 {
+		t.Errorf("unexpected user: %#v", user)
+	}
+}
+
+func TestGeneratedSearchApiClient(t *testing.T) {
+	ts := httptest.NewServer(NewSearchApi())
+	defer ts.Close()
+
+	c := apiclient.NewSearchApiClient(ts.URL, "")
+	params := apiclient.SearchParams{
+		Query:    "rvasily",
+		Phone:    "+79001234567",
+		Country:  "US",
+		Rating:   0.25,
+		Statuses: []string{"moderator"},
+		IDs:      []uint64{7, 8},
+		Period: apiclient.PeriodParams{
+			From: time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC),
+			To:   time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	result, err := c.Search(context.Background(), params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Query, params) {
+		t.Errorf("results not match\nGot: %#v\nExpected: %#v", result.Query, params)
+	}
+
+	_, err = c.Search(context.Background(), apiclient.SearchParams{Country: "USA"})
+	apiErr, ok := err.(apiclient.ApiError)
+	if !ok || apiErr.HTTPStatus != http.StatusBadRequest || apiErr.Error() != "country len must be 2, period.from must me not empty" {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// authConfig is either a bool or an object with a required role: "auth": {"role": "admin"}
+type authConfig struct {
+	Required bool
+	Role     string
+}
+
+func (ac *authConfig) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &ac.Required); err == nil {
+		return nil
+	}
+	obj := struct {
+		Role string `json:"role"`
+	}{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	ac.Required = true
+	ac.Role = obj.Role
+	return nil
+}
+
+func generateAuthCheck(config authConfig) string {
+	if !config.Required {
+		return ""
+	}
+	return `
+	// checking authentication
+	authCtx, err := authenticate(api, r, ` + strconv.Quote(config.Role) + `)
+	if err != nil {
+		hooks.fail(ctx, err, w)
+		return
+	}
+	ctx = authCtx
+`
+}
+
+var (
+	authPackages = []string{
+		"bytes",
+		"context",
+		"crypto/hmac",
+		"crypto/sha256",
+		"encoding/hex",
+		"io/ioutil",
+		"strconv",
+		"time",
+	}
+
+	authHelpersSrc = `
+// Principal is the authenticated caller, handlers put it into the context passed to api methods
+type Principal struct {
+	ID    string
+	Roles []string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalCtxKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(*Principal)
+	return p, ok
+}
+
+// Authenticator finds out who made the request, nil principal and nil error mean anonymous request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorProvider is implemented by api structs that bring their own authentication,
+// others get DefaultAuthenticator
+type AuthenticatorProvider interface {
+	Authenticator() Authenticator
+}
+
+// TokenLookupFunc resolves a token or a session id into a principal, nil if it is unknown
+type TokenLookupFunc func(ctx context.Context, token string) (*Principal, error)
+
+// TokenMap is the simplest TokenLookupFunc source
+type TokenMap map[string]*Principal
+
+func (tm TokenMap) Lookup(ctx context.Context, token string) (*Principal, error) {
+	return tm[token], nil
+}
+
+// HeaderTokenAuthenticator takes the token as is from a header
+type HeaderTokenAuthenticator struct {
+	Header string
+	Lookup TokenLookupFunc
+}
+
+func (a HeaderTokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get(a.Header)
+	if token == "" {
+		return nil, nil
+	}
+	return a.Lookup(r.Context(), token)
+}
+
+// BearerAuthenticator takes the token from "Authorization: Bearer <token>"
+type BearerAuthenticator struct {
+	Lookup TokenLookupFunc
+}
+
+func (a BearerAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, nil
+	}
+	return a.Lookup(r.Context(), header[len(prefix):])
+}
+
+// CookieSessionAuthenticator takes the session id from a cookie
+type CookieSessionAuthenticator struct {
+	Cookie string
+	Lookup TokenLookupFunc
+}
+
+func (a CookieSessionAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	cookie, err := r.Cookie(a.Cookie)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	return a.Lookup(r.Context(), cookie.Value)
+}
+
+const (
+	hmacKeyIDHeader     = "X-Key-Id"
+	hmacTimestampHeader = "X-Timestamp"
+	hmacSignatureHeader = "X-Signature"
+)
+
+// HMACKeyFunc returns the secret of a key and the principal the key belongs to
+type HMACKeyFunc func(ctx context.Context, keyID string) (secret []byte, p *Principal, err error)
+
+// HMACAuthenticator checks requests signed with SignRequest
+type HMACAuthenticator struct {
+	Keys HMACKeyFunc
+	// how old a signature may be, a minute if not set
+	MaxSkew time.Duration
+}
+
+func (a HMACAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(hmacKeyIDHeader)
+	if keyID == "" {
+		return nil, nil
+	}
+
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = time.Minute
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(hmacTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, nil
+	}
+
+	secret, p, err := a.Keys(r.Context(), keyID)
+	if err != nil || p == nil {
+		return nil, err
+	}
+	expected, err := requestSignature(r, secret)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(r.Header.Get(hmacSignatureHeader))
+	if err != nil || !hmac.Equal(signature, expected) {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// SignRequest signs method, url, timestamp and body of the request for HMACAuthenticator
+func SignRequest(r *http.Request, keyID string, secret []byte) error {
+	r.Header.Set(hmacKeyIDHeader, keyID)
+	r.Header.Set(hmacTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	signature, err := requestSignature(r, secret)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(hmacSignatureHeader, hex.EncodeToString(signature))
+	return nil
+}
+
+func requestSignature(r *http.Request, secret []byte) ([]byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n" + r.Header.Get(hmacTimestampHeader) + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+// AuthenticatorChain asks authenticators in order, the first found principal wins
+type AuthenticatorChain []Authenticator
+
+func (chain AuthenticatorChain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range chain {
+		p, err := a.Authenticate(r)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+// DefaultAuthenticator accepts the X-Auth: 100500 header
+var DefaultAuthenticator Authenticator = HeaderTokenAuthenticator{
+	Header: "X-Auth",
+	Lookup: TokenMap{"100500": &Principal{ID: "100500"}}.Lookup,
+}
+
+func authenticate(api interface{}, r *http.Request, role string) (context.Context, error) {
+	authenticator := DefaultAuthenticator
+	if provider, ok := api.(AuthenticatorProvider); ok {
+		authenticator = provider.Authenticator()
+	}
+
+	p, err := authenticator.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("unauthorized")}
+	}
+	if role != "" && !p.HasRole(role) {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("role %s required", role)}
+	}
+	return ContextWithPrincipal(r.Context(), p), nil
+}
+`
+)
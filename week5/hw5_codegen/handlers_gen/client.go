@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type clientFileTplArg struct {
+	PackageName string
+	Imports     []string
+	Types       []string
+	ApiTypes    []string
+	Methods     []string
+}
+
+type clientMethodTplArg struct {
+	ApiTypeName    string
+	ApiMethodName  string
+	ParamsTypeName string
+	ResultType     string
+	HttpMethod     string
+	URLCode        string
+	Auth           bool
+	EncodeCode     string
+	// "uploads" if the method sends files, "nil" otherwise
+	Uploads string
+	// type of items of streaming methods
+	ItemType string
+}
+
+var (
+	clientFileTpl = template.Must(template.New("clientFile").Parse(`// Code generated by handlers_gen. DO NOT EDIT.
+
+package {{.PackageName}}
+
+import (
+{{range .Imports}}	{{.}}
+{{end}})
+
+// ApiError is returned for every response with a non-empty error
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type envelope struct {
+	Error    string          ` + "`json:\"error\"`" + `
+	Response json.RawMessage ` + "`json:\"response\"`" + `
+}
+
+type baseClient struct {
+	URL        string
+	AuthToken  string
+	HTTPClient *http.Client
+	// Authorize replaces the X-Auth header with another scheme, bearer token or request signature
+	Authorize func(req *http.Request) error
+}
+
+// upload is a file sent with a multipart form, content is read from header if it is nil
+type upload struct {
+	name     string
+	fileName string
+	content  io.Reader
+	header   *multipart.FileHeader
+}
+
+func (c *baseClient) send(ctx context.Context, method string, path string, auth bool, params url.Values, uploads []upload) (*http.Response, error) {
+	var req *http.Request
+	var err error
+	switch {
+	case method == http.MethodGet:
+		req, err = http.NewRequest(method, c.URL+path+"?"+params.Encode(), nil)
+	case len(uploads) > 0:
+		var body *bytes.Buffer
+		var contentType string
+		body, contentType, err = multipartBody(params, uploads)
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequest(method, c.URL+path, body)
+		if err == nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+	default:
+		req, err = http.NewRequest(method, c.URL+path, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if auth && c.Authorize != nil {
+		err = c.Authorize(req)
+		if err != nil {
+			return nil, err
+		}
+	} else if auth {
+		req.Header.Set("X-Auth", c.AuthToken)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req.WithContext(ctx))
+}
+
+func multipartBody(params url.Values, uploads []upload) (*bytes.Buffer, string, error) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name, vals := range params {
+		for _, val := range vals {
+			if err := mw.WriteField(name, val); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	for _, u := range uploads {
+		content := u.content
+		if content == nil {
+			file, err := u.header.Open()
+			if err != nil {
+				return nil, "", err
+			}
+			defer file.Close()
+			content = file
+		}
+		part, err := mw.CreateFormFile(u.name, u.fileName)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err = io.Copy(part, content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return body, mw.FormDataContentType(), nil
+}
+
+func (c *baseClient) do(ctx context.Context, method string, path string, auth bool, params url.Values, uploads []upload, result interface{}) error {
+	resp, err := c.send(ctx, method, path, auth, params, uploads)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	env := envelope{}
+	err = json.NewDecoder(resp.Body).Decode(&env)
+	if err != nil {
+		return ApiError{resp.StatusCode, fmt.Errorf("cant unpack response: %s", err)}
+	}
+	if resp.StatusCode != http.StatusOK || env.Error != "" {
+		return ApiError{resp.StatusCode, errors.New(env.Error)}
+	}
+	return json.Unmarshal(env.Response, result)
+}
+
+func (c *baseClient) stream(ctx context.Context, method string, path string, auth bool, params url.Values, uploads []upload) (*Stream, error) {
+	resp, err := c.send(ctx, method, path, auth, params, uploads)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		env := envelope{}
+		err = json.NewDecoder(resp.Body).Decode(&env)
+		if err != nil {
+			return nil, ApiError{resp.StatusCode, fmt.Errorf("cant unpack response: %s", err)}
+		}
+		return nil, ApiError{resp.StatusCode, errors.New(env.Error)}
+	}
+	return &Stream{
+		body:   resp.Body,
+		reader: bufio.NewReader(resp.Body),
+		sse:    strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"),
+	}, nil
+}
+
+// Stream reads items of a streaming method one by one, it must be closed
+type Stream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	sse    bool
+}
+
+// Next unpacks the next item into item, io.EOF means the stream is over
+func (s *Stream) Next(item interface{}) error {
+	data, err := s.readMessage()
+	if err != nil {
+		return err
+	}
+	env := envelope{}
+	err = json.Unmarshal(data, &env)
+	if err != nil {
+		return fmt.Errorf("cant unpack item: %s", err)
+	}
+	if env.Error != "" {
+		return ApiError{http.StatusOK, errors.New(env.Error)}
+	}
+	return json.Unmarshal(env.Response, item)
+}
+
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
+
+// readMessage returns a line of NDJSON or data of the next Server-Sent Event
+func (s *Stream) readMessage() ([]byte, error) {
+	var data []byte
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			if err == io.EOF && len(data) > 0 {
+				return data, nil
+			}
+			return nil, err
+		}
+
+		if !s.sse {
+			if len(line) > 0 {
+				return line, nil
+			}
+			continue
+		}
+		switch {
+		case len(line) == 0 && len(data) > 0:
+			return data, nil
+		case bytes.HasPrefix(line, []byte("data:")):
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(line[len("data:"):], []byte(" "))...)
+		}
+	}
+}
+{{range .Types}}
+{{.}}
+{{end}}
+{{- range .ApiTypes}}
+type {{.}}Client struct {
+	baseClient
+}
+
+func New{{.}}Client(baseURL string, authToken string) *{{.}}Client {
+	return &{{.}}Client{baseClient{URL: baseURL, AuthToken: authToken}}
+}
+{{end}}
+{{- range .Methods}}
+{{.}}
+{{end}}`))
+
+	clientMethodTpl = template.Must(template.New("clientMethod").Parse(`
+{{- if .ItemType}}
+// {{.ApiMethodName}} streams items of type {{.ItemType}}, read them with Next
+func (c *{{.ApiTypeName}}Client) {{.ApiMethodName}}(ctx context.Context, in {{.ParamsTypeName}}) (*Stream, error) {
+{{- else}}
+func (c *{{.ApiTypeName}}Client) {{.ApiMethodName}}(ctx context.Context, in {{.ParamsTypeName}}) ({{.ResultType}}, error) {
+{{- end}}
+	params := url.Values{}
+{{- if eq .Uploads "uploads"}}
+	var uploads []upload
+{{- end}}{{.EncodeCode}}
+{{if .ItemType}}
+	return c.stream(ctx, "{{.HttpMethod}}", {{.URLCode}}, {{.Auth}}, params, {{.Uploads}})
+{{- else}}
+	var result {{.ResultType}}
+	err := c.do(ctx, "{{.HttpMethod}}", {{.URLCode}}, {{.Auth}}, params, {{.Uploads}}, &result)
+	return result, err
+{{- end}}
+}
+`))
+
+	clientPackages = []string{
+		"bufio",
+		"bytes",
+		"context",
+		"encoding/json",
+		"errors",
+		"fmt",
+		"io",
+		"mime/multipart",
+		"net/http",
+		"net/url",
+		"strings",
+	}
+)
+
+// generateClient writes a client package with one method per api method into dir
+func generateClient(dir string, pkg *apiPackage, methods []apiMethodSpec) error {
+	packageName := filepath.Base(dir)
+
+	usedTypes := make(map[*types.Named]bool)
+	usedPackages := make(map[string]bool)
+	for _, pkg := range clientPackages {
+		usedPackages[strconv.Quote(pkg)] = true
+	}
+	qualifier := clientQualifier(pkg, usedPackages)
+
+	var apiTypes, methodsSrc []string
+	seenApiTypes := make(map[string]bool)
+	for _, method := range methods {
+		fmt.Printf("Generating client method for %s.%s\n", method.ApiTypeName, method.ApiMethodName)
+
+		if !seenApiTypes[method.ApiTypeName] {
+			seenApiTypes[method.ApiTypeName] = true
+			apiTypes = append(apiTypes, method.ApiTypeName)
+		}
+
+		collectUsedTypes(pkg, method.ResultType, usedTypes)
+		collectUsedTypes(pkg, method.ParamsType, usedTypes)
+
+		httpMethod := method.Config.Method
+		if httpMethod == "" {
+			httpMethod = "GET"
+		}
+
+		pathParams := make(map[string]bool)
+		for _, name := range pathParamNames(method.Config.URL) {
+			pathParams[name] = true
+		}
+		encodeCode := generateClientEncoder("", "in", method.ParamsType, pathParams, usedPackages)
+		uploads := "nil"
+		if fields, _ := uploadFields(method.ParamsType, ""); len(fields) > 0 {
+			uploads = "uploads"
+		}
+		itemType := ""
+		if _, elem := streamResult(method.ResultType); elem != nil {
+			itemType = types.TypeString(elem, qualifier)
+		}
+
+		var methodBuf bytes.Buffer
+		err := clientMethodTpl.Execute(&methodBuf, clientMethodTplArg{
+			ApiTypeName:    method.ApiTypeName,
+			ApiMethodName:  method.ApiMethodName,
+			ParamsTypeName: types.TypeString(method.ParamsType, qualifier),
+			ResultType:     types.TypeString(method.ResultType, qualifier),
+			HttpMethod:     httpMethod,
+			URLCode:        generateClientURL(method.Config.URL, method.ParamsType, usedPackages),
+			Auth:           method.Config.Auth.Required,
+			EncodeCode:     encodeCode,
+			Uploads:        uploads,
+			ItemType:       itemType,
+		})
+		if err != nil {
+			return err
+		}
+		methodsSrc = append(methodsSrc, methodBuf.String())
+	}
+
+	namedTypes := make(map[string]*types.Named, len(usedTypes))
+	typeNames := make([]string, 0, len(usedTypes))
+	for named := range usedTypes {
+		typeName := named.Obj().Name()
+		if _, exists := namedTypes[typeName]; exists {
+			return fmt.Errorf("two types named %s are used by the api, the client can have one", typeName)
+		}
+		namedTypes[typeName] = named
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	typesSrc := make([]string, 0, len(typeNames))
+	for _, typeName := range typeNames {
+		typesSrc = append(typesSrc, "type "+typeName+" "+typeSource(namedTypes[typeName].Underlying(), qualifier))
+	}
+
+	imports := make([]string, 0, len(usedPackages))
+	for pkg := range usedPackages {
+		imports = append(imports, pkg)
+	}
+	sort.Strings(imports)
+
+	var src bytes.Buffer
+	err := clientFileTpl.Execute(&src, clientFileTplArg{
+		PackageName: packageName,
+		Imports:     imports,
+		Types:       typesSrc,
+		ApiTypes:    apiTypes,
+		Methods:     methodsSrc,
+	})
+	if err != nil {
+		return err
+	}
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("cant format generated client: %s", err)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "client.go"), formatted, 0644)
+}
+
+// generateClientURL returns an expression building the url with path params put into their segments
+func generateClientURL(urlTemplate string, paramsType *types.Named, usedPackages map[string]bool) string {
+	fields := make(map[string]paramField)
+	for _, f := range parseParamFields(paramsType) {
+		fields[f.ParamName] = f
+	}
+
+	var parts []string
+	literal := ""
+	for idx, segment := range strings.Split(urlTemplate, "/") {
+		if idx > 0 {
+			literal += "/"
+		}
+		name, isParam := pathParamName(segment)
+		if !isParam {
+			literal += segment
+			continue
+		}
+		if literal != "" {
+			parts = append(parts, strconv.Quote(literal))
+			literal = ""
+		}
+		f := fields[name]
+		parts = append(parts, `url.PathEscape(`+formatValueCode(f, "in."+f.FieldName, usedPackages)+`)`)
+	}
+	if literal != "" {
+		parts = append(parts, strconv.Quote(literal))
+	}
+	return strings.Join(parts, "+")
+}
+
+// generateClientEncoder puts every field the handler deserializes into params under its paramname,
+// nested structs are flattened into "parent.child" params, path params go to the url instead
+func generateClientEncoder(prefix string, value string, paramsType *types.Named,
+	pathParams map[string]bool, usedPackages map[string]bool) string {
+	var buf bytes.Buffer
+	for _, f := range parseParamFields(paramsType) {
+		if pathParams[prefix+f.ParamName] {
+			continue
+		}
+		name := strconv.Quote(prefix + f.ParamName)
+		fieldValue := value + "." + f.FieldName
+
+		switch {
+		case f.Nested != nil:
+			buf.WriteString(generateClientEncoder(prefix+f.ParamName+".", fieldValue, f.Nested, pathParams, usedPackages))
+		case f.Kind == "*multipart.FileHeader":
+			buf.WriteString(`
+	if ` + fieldValue + ` != nil {
+		uploads = append(uploads, upload{name: ` + name + `, fileName: ` + fieldValue + `.Filename, header: ` + fieldValue + `})
+	}`)
+		case uploadKinds[f.Kind]:
+			buf.WriteString(`
+	if ` + fieldValue + ` != nil {
+		uploads = append(uploads, upload{name: ` + name + `, fileName: ` + name + `, content: ` + fieldValue + `})
+	}`)
+		case f.IsSlice:
+			buf.WriteString(`
+	for _, v := range ` + fieldValue + ` {
+		params.Add(` + name + `, ` + formatValueCode(f, "v", usedPackages) + `)
+	}`)
+		case f.Kind == "time.Time":
+			buf.WriteString(`
+	if !` + fieldValue + `.IsZero() {
+		params.Set(` + name + `, ` + formatValueCode(f, fieldValue, usedPackages) + `)
+	}`)
+		default:
+			buf.WriteString(`
+	params.Set(` + name + `, ` + formatValueCode(f, fieldValue, usedPackages) + `)`)
+		}
+	}
+	return buf.String()
+}
+
+func formatValueCode(f paramField, value string, usedPackages map[string]bool) string {
+	switch {
+	case f.Kind == "string":
+		return value
+	case f.Kind == "time.Time":
+		return value + `.Format(` + strconv.Quote(f.Layout()) + `)`
+	}
+
+	usedPackages[strconv.Quote("strconv")] = true
+	switch {
+	case f.Kind == "int":
+		return `strconv.Itoa(` + value + `)`
+	case f.Kind == "bool":
+		return `strconv.FormatBool(` + value + `)`
+	case isIntKind(f.Kind):
+		return `strconv.FormatInt(int64(` + value + `), 10)`
+	case isUintKind(f.Kind):
+		return `strconv.FormatUint(uint64(` + value + `), 10)`
+	default:
+		return `strconv.FormatFloat(float64(` + value + `), 'g', -1, ` + strings.TrimPrefix(f.Kind, "float") + `)`
+	}
+}
+
+// collectUsedTypes finds all named types of the package and its subpackages reachable from t,
+// the client gets copies of them because it must not import the server
+func collectUsedTypes(pkg *apiPackage, t types.Type, usedTypes map[*types.Named]bool) {
+	switch t := t.(type) {
+	case *types.Pointer:
+		collectUsedTypes(pkg, t.Elem(), usedTypes)
+	case *types.Slice:
+		collectUsedTypes(pkg, t.Elem(), usedTypes)
+	case *types.Array:
+		collectUsedTypes(pkg, t.Elem(), usedTypes)
+	case *types.Map:
+		collectUsedTypes(pkg, t.Key(), usedTypes)
+		collectUsedTypes(pkg, t.Elem(), usedTypes)
+	case *types.Chan:
+		collectUsedTypes(pkg, t.Elem(), usedTypes)
+	case *types.Signature:
+		// iterators of streaming methods
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				collectUsedTypes(pkg, tuple.At(i).Type(), usedTypes)
+			}
+		}
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			collectUsedTypes(pkg, t.Field(i).Type(), usedTypes)
+		}
+	case *types.Named:
+		if !pkg.isLocal(t.Obj().Pkg()) || usedTypes[t] {
+			return
+		}
+		usedTypes[t] = true
+		collectUsedTypes(pkg, t.Underlying(), usedTypes)
+	}
+}
+
+// clientQualifier refers to copied types by name and imports the rest
+func clientQualifier(pkg *apiPackage, usedPackages map[string]bool) types.Qualifier {
+	return func(other *types.Package) string {
+		if pkg.isLocal(other) {
+			return ""
+		}
+		usedPackages[strconv.Quote(pkg.importPath(other))] = true
+		return other.Name()
+	}
+}
+
+// typeSource writes a struct with a field per line and tags in backquotes the way it is declared in the package
+func typeSource(t types.Type, qualifier types.Qualifier) string {
+	currStruct, ok := t.(*types.Struct)
+	if !ok {
+		return types.TypeString(t, qualifier)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("struct {\n")
+	for i := 0; i < currStruct.NumFields(); i++ {
+		field := currStruct.Field(i)
+		buf.WriteString("\t")
+		if !field.Anonymous() {
+			buf.WriteString(field.Name() + " ")
+		}
+		buf.WriteString(types.TypeString(field.Type(), qualifier))
+		if tag := currStruct.Tag(i); tag != "" {
+			if strconv.CanBackquote(tag) {
+				buf.WriteString(" `" + tag + "`")
+			} else {
+				buf.WriteString(" " + strconv.Quote(tag))
+			}
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
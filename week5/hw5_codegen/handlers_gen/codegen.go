@@ -2,42 +2,47 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"os"
-	"go/token"
 	"go/ast"
-	"go/parser"
+	"go/format"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
 	"text/template"
 	"log"
 	"fmt"
 	"strings"
 	"io"
-	"reflect"
 	"bytes"
+	"sort"
+	"strconv"
 )
 
 type handlerTplArg struct {
 	ApiTypeName string
 	ApiMethodName string
 	CheckAuthBlock string
-	CheckHttpMethodBlock string
-	ParamsTypeName string
+	ParamsFuncName string
+	BodyLimitBlock string
+	CloseUploadsBlock string
+	WriteResultBlock string
 }
 
 type deserializeModelTplArg struct {
 	ModelTypeName string
+	FuncName string
 	DeserializeFieldsCode string
 }
 
-type handlerConfig struct {
-	ApiMethodName string
-	UrlPath string
-}
-
 var (
 	genPackages = []string{
 		"fmt",
+		"io",
+		"mime",
 		"net/http",
-		"strconv",
+		"net/url",
+		"strings",
 		"encoding/json",
 	}
 
@@ -48,11 +53,142 @@ type finalResponse struct {
 	Response interface{} `+"`json:\"response,omitempty\"`"+`
 }
 
-func checkAuth(w http.ResponseWriter, r *http.Request) error {
-	if (r.Header.Get("X-Auth") != "100500") {
-		return ApiError{http.StatusForbidden, fmt.Errorf("unauthorized")}
+const maxMultipartMemory = 32 << 20
+
+// requestParams reads params from the request and puts path params over them
+func requestParams(r *http.Request) (url.Values, error) {
+	params, err := requestBodyParams(r)
+	if err != nil {
+		return nil, err
+	}
+	for name, vals := range PathParams(r) {
+		params[name] = vals
+	}
+	return params, nil
+}
+
+// requestBodyParams picks where params come from by http method and Content-Type
+func requestBodyParams(r *http.Request) (url.Values, error) {
+	if r.Method == "GET" {
+		return r.URL.Query(), nil
+	}
+
+	var mediaType string
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, ApiError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported media type")}
+		}
+	}
+
+	switch mediaType {
+	case "", "application/x-www-form-urlencoded":
+		err := r.ParseForm()
+		if err != nil {
+			return nil, formError(err)
+		}
+		return r.Form, nil
+	case "multipart/form-data":
+		err := r.ParseMultipartForm(maxMultipartMemory)
+		if err != nil {
+			return nil, formError(err)
+		}
+		return r.Form, nil
+	case "application/json":
+		return jsonParams(r)
+	}
+	return nil, ApiError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported media type %s", mediaType)}
+}
+
+// jsonParams flattens a json object body into params so the same validation applies to it:
+// arrays become repeated params and nested objects become "parent.child" params,
+// url query params are used for keys missing in the body
+func jsonParams(r *http.Request) (url.Values, error) {
+	body := map[string]json.RawMessage{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("cant unpack json body: %s", err)}
+	}
+
+	bodyParams := url.Values{}
+	for name, raw := range body {
+		flattenJsonParam(bodyParams, name, raw)
+	}
+
+	params := r.URL.Query()
+	for name, vals := range bodyParams {
+		if len(vals) == 0 {
+			params.Del(name)
+		} else {
+			params[name] = vals
+		}
+	}
+	return params, nil
+}
+
+func flattenJsonParam(params url.Values, name string, raw json.RawMessage) {
+	var str string
+	var arr []json.RawMessage
+	var obj map[string]json.RawMessage
+	switch {
+	case string(raw) == "null":
+		if _, exists := params[name]; !exists {
+			params[name] = []string{}
+		}
+	case json.Unmarshal(raw, &str) == nil:
+		params.Add(name, str)
+	case json.Unmarshal(raw, &arr) == nil:
+		for _, item := range arr {
+			flattenJsonParam(params, name, item)
+		}
+	case json.Unmarshal(raw, &obj) == nil:
+		for key, item := range obj {
+			flattenJsonParam(params, name+"."+key, item)
+		}
+	default:
+		params.Add(name, string(raw))
+	}
+}
+
+// validationErrors collects errors of all fields so they are reported at once
+type validationErrors []error
+
+func (errs *validationErrors) add(err error) {
+	if err != nil {
+		*errs = append(*errs, err)
 	}
-	return nil
+}
+
+func (errs validationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, ", ")
+}
+
+func fieldError(prefix string, msg string) error {
+	return fmt.Errorf("%s%s", prefix, msg)
+}
+
+// ApiValidatorFunc checks a single value of a field tagged with validator=name
+type ApiValidatorFunc func(value interface{}) error
+
+var apiValidators = map[string]ApiValidatorFunc{}
+
+// RegisterApiValidator makes a validator available to apivalidator tags,
+// it is not safe for concurrent use and should be called on init
+func RegisterApiValidator(name string, validator ApiValidatorFunc) {
+	apiValidators[name] = validator
+}
+
+func runApiValidator(name string, value interface{}) error {
+	validator, exists := apiValidators[name]
+	if !exists {
+		return fmt.Errorf("has unknown validator %s", name)
+	}
+	return validator(value)
 }
 
 func handleError(err error, w http.ResponseWriter) {
@@ -74,48 +210,61 @@ func handleError(err error, w http.ResponseWriter) {
 	w.WriteHeader(httpStatus)
 	w.Write([]byte(respText))
 }
+`
+
+	// emitted only when some field has the email rule
+	isEmailSrc = `
+func isEmail(v string) bool {
+	addr, err := mail.ParseAddress(v)
+	return err == nil && addr.Address == v
+}
 `
 
 	handlerTpl = template.Must(template.New("handler").Parse(`
 func (api *{{.ApiTypeName}}) handler{{.ApiMethodName}}(w http.ResponseWriter, r *http.Request) {
 	var err error
-	{{.CheckHttpMethodBlock}}
+	ctx := r.Context()
+	hooks := hooksFor(api, "{{.ApiMethodName}}")
 	{{.CheckAuthBlock}}
-	deserializedParams, err := deserialize{{.ParamsTypeName}}(r)
+	err = hooks.beforeValidate(ctx, r)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
-	result, err := api.{{.ApiMethodName}}(r.Context(), deserializedParams)
+	{{.BodyLimitBlock}}
+	deserializedParams, err := deserialize{{.ParamsFuncName}}(r)
+	{{- .CloseUploadsBlock}}
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
 	}
-	resp := finalResponse{
-		Error: "",
-		Response: result,
-	}
-	respText, _ := json.Marshal(resp)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(respText))
-}
-`))
-
-	checkAuthenticationStr = `
-	// checking authentication
-	err = checkAuth(w, r)
+	result, err := api.{{.ApiMethodName}}(ctx, deserializedParams)
+	err = hooks.afterCall(ctx, deserializedParams, result, err)
 	if err != nil {
-		handleError(err, w)
+		hooks.fail(ctx, err, w)
 		return
-	}
-`
+	}{{.WriteResultBlock}}}
+`))
 
 	deserializeModelTpl = template.Must(template.New("deserializeModel").Parse(`
-func deserialize{{.ModelTypeName}}(r *http.Request) ({{.ModelTypeName}}, error) {
+func deserialize{{.FuncName}}(r *http.Request) ({{.ModelTypeName}}, error) {
 	model := {{.ModelTypeName}}{}
-	{{.DeserializeFieldsCode}}
+	params, err := requestParams(r)
+	if err != nil {
+		return model, err
+	}
+	errs := validationErrors{}
+	deserialize{{.FuncName}}Fields(params, requestFiles(r), "", &model, &errs)
+	if len(errs) > 0 {
+		return model, ApiError{http.StatusBadRequest, errs}
+	}
 	return model, nil
 }
+`))
+
+	deserializeFieldsTpl = template.Must(template.New("deserializeFields").Parse(`
+func deserialize{{.FuncName}}Fields(params url.Values, files map[string][]*multipart.FileHeader, prefix string, model *{{.ModelTypeName}}, errs *validationErrors) {
+{{.DeserializeFieldsCode}}}
 `))
 )
 
@@ -123,319 +272,535 @@ func deserialize{{.ModelTypeName}}(r *http.Request) ({{.ModelTypeName}}, error)
 
 type apigenConfig struct {
 	URL string `json:"url"`
-	Auth bool `json:"auth"`
+	Auth authConfig `json:"auth"`
 	Method string `json:"method"`
+	// "ndjson" or "sse" for methods returning a channel or an iterator
+	Stream string `json:"stream"`
 }
 
-func getApiMethodInfo(fDecl *ast.FuncDecl) (apiTypeName string, methodName string, paramsTypeName string) {
-	apiTypeName = fDecl.Recv.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name
-	methodName = fDecl.Name.Name
-	paramsTypeName = fDecl.Type.Params.List[1].Type.(*ast.Ident).Name
-	return
-}
-
-func generateHandler(out io.Writer, fDecl *ast.FuncDecl, config apigenConfig) (
-	apiTypeName string, apiMethodName string, paramsTypeName string) {
-
-	apiTypeName, apiMethodName, paramsTypeName = getApiMethodInfo(fDecl)
-
-	fmt.Printf("Generating handler for %s.%s(%s)\n", apiTypeName, apiMethodName, paramsTypeName)
+// getApiMethodInfo reads an api method signature: func (api *Api) Method(ctx context.Context, in Params) (Result, error)
+func getApiMethodInfo(pkg *apiPackage, fDecl *ast.FuncDecl) apiMethodSpec {
+	fn, ok := pkg.Info.Defs[fDecl.Name].(*types.Func)
+	if !ok {
+		log.Fatalf("%s: cant resolve method type", fDecl.Name.Name)
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() == nil || sig.Params().Len() != 2 || sig.Results().Len() != 2 {
+		log.Fatalf("%s: api method must be func (api *Api) %s(ctx context.Context, in Params) (Result, error)",
+			fDecl.Name.Name, fDecl.Name.Name)
+	}
 
-	authCheck := ""
-	if config.Auth {
-		authCheck = checkAuthenticationStr
+	recv := sig.Recv().Type()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
 	}
-	httpMethodCheck := ""
-	if config.Method != "" {
-		httpMethodCheck = `
-	// checking http method
-	if r.Method != "` + config.Method + `" {
-		handleError(ApiError{http.StatusNotAcceptable,fmt.Errorf("bad method")}, w)
-		return
+	apiType, ok := recv.(*types.Named)
+	if !ok {
+		log.Fatalf("%s: cant resolve receiver type", fDecl.Name.Name)
 	}
-`
+	paramsType, _ := structOf(sig.Params().At(1).Type())
+	if paramsType == nil {
+		log.Fatalf("%s.%s: params must be a named struct", apiType.Obj().Name(), fDecl.Name.Name)
 	}
 
-	handlerTpl.Execute(out, handlerTplArg{
-		ApiMethodName: apiMethodName,
-		ApiTypeName: apiTypeName,
-		CheckAuthBlock: authCheck,
-		CheckHttpMethodBlock: httpMethodCheck,
-		ParamsTypeName: paramsTypeName,
-	})
-
-	return apiTypeName, apiMethodName, paramsTypeName
+	return apiMethodSpec{
+		ApiTypeName: apiType.Obj().Name(),
+		ApiMethodName: fDecl.Name.Name,
+		ParamsType: paramsType,
+		ResultType: sig.Results().At(0).Type(),
+	}
 }
 
-func generateDeserializers(out io.Writer, node *ast.File, paramsTypesNames map[string]bool) {
-	for _, f := range node.Decls {
-		g, ok := f.(*ast.GenDecl)
-		if !ok {
-			fmt.Printf("SKIP %T is not *ast.GenDecl\n", f)
-			continue
-		}
-		for _, spec := range g.Specs {
-			currType, ok := spec.(*ast.TypeSpec)
-			if !ok {
-				fmt.Printf("SKIP %T is not ast.TypeSpec\n", spec)
-				continue
-			}
-
-			currTypeName := currType.Name.Name
+func generateHandler(out io.Writer, pkg *apiPackage, method apiMethodSpec, imports map[string]bool) {
+	fmt.Printf("Generating handler for %s.%s(%s)\n", method.ApiTypeName, method.ApiMethodName, method.ParamsType.Obj().Name())
 
-			if !paramsTypesNames[currTypeName] {
-				fmt.Printf("SKIP %s is not a type of handler's parameter\n", currTypeName)
-				continue;
-			}
+	handlerTpl.Execute(out, handlerTplArg{
+		ApiMethodName: method.ApiMethodName,
+		ApiTypeName: method.ApiTypeName,
+		CheckAuthBlock: generateAuthCheck(method.Config.Auth),
+		ParamsFuncName: pkg.typeFuncName(method.ParamsType),
+		BodyLimitBlock: generateBodyLimit(method),
+		CloseUploadsBlock: generateCloseUploads(method),
+		WriteResultBlock: generateResultWriter(pkg, method, imports),
+	})
+}
 
-			currStruct, ok := currType.Type.(*ast.StructType)
-			if !ok {
-				fmt.Printf("SKIP %T is not ast.StructType\n", currStruct)
-				continue
-			}
+func generateDeserializers(out io.Writer, pkg *apiPackage, paramsTypes []*types.Named, imports map[string]bool) {
+	isTopLevel := make(map[*types.Named]bool)
+	for _, named := range paramsTypes {
+		isTopLevel[named] = true
+	}
 
-			generateDeserializerForStruct(out, currStruct, currTypeName)
-		}
+	allParamsTypes := collectParamsTypes(paramsTypes)
+	pkg.sortByPos(allParamsTypes)
+	for _, named := range allParamsTypes {
+		generateDeserializerForStruct(out, pkg, named, isTopLevel[named], imports)
 	}
 }
 
-func generateDeserializerForStruct(out io.Writer, currStruct *ast.StructType, structName string) {
-	fmt.Fprintf(out, "//Deserializer for struct %s\n", structName)
+func generateDeserializerForStruct(out io.Writer, pkg *apiPackage, named *types.Named, isTopLevel bool, imports map[string]bool) {
+	typeName := types.TypeString(named, pkg.qualifier(imports))
+	funcName := pkg.typeFuncName(named)
+
+	fmt.Fprintf(out, "//Deserializer for struct %s\n", typeName)
 
 	var fieldsBuf bytes.Buffer
-	fieldsBuf.WriteString(`
-	var valStr string
-`)
-	for _, field := range currStruct.Fields.List {
-		if field.Tag != nil {
-			fieldName := field.Names[0].Name
-			fieldType := field.Type.(*ast.Ident).Name
-			tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-			fieldMetaStr := tag.Get("apivalidator")
+	for _, field := range parseParamFields(named) {
+		fmt.Printf("Generating deserialization and validation for field %s with tags %v\n", field.FieldName, field.Tags)
 
-			fmt.Printf("Generating deserialization and validation for field %s with tag %s\n", fieldName, fieldMetaStr)
+		code, decls := generateFieldDeserializer(pkg, funcName, field, imports)
+		fmt.Fprint(out, decls)
+		fieldsBuf.WriteString(code)
+	}
 
-			fieldsBuf.WriteString(generateFieldDeserializer(fieldName, fieldType, fieldMetaStr))
-			fieldsBuf.WriteRune('\n')
-		}
+	if isTopLevel {
+		deserializeModelTpl.Execute(out, deserializeModelTplArg{
+			ModelTypeName: typeName,
+			FuncName: funcName,
+		})
 	}
-	deserializeModelTpl.Execute(out, deserializeModelTplArg{
-		ModelTypeName: structName,
+	deserializeFieldsTpl.Execute(out, deserializeModelTplArg{
+		ModelTypeName: typeName,
+		FuncName: funcName,
 		DeserializeFieldsCode: fieldsBuf.String(),
 	})
 }
 
-/*
-type OtherCreateParams struct {
-	Username string `apivalidator:"required,min=3"`
-	Name     string `apivalidator:"paramname=account_name"`
-	Class    string `apivalidator:"enum=warrior|sorcerer|rouge,default=warrior"`
-	Level    int    `apivalidator:"min=1,max=50"`
-}
-*/
-
-func generateFieldDeserializer(fieldName string, fieldType string, fieldMetaStr string) (string) {
-	var fieldBuf bytes.Buffer
-
-	var whereFrom string
+func parseValidatorTags(fieldMetaStr string) map[string]string {
 	tags := make(map[string]string)
 
 	if fieldMetaStr != "" {
 		tagsArr := strings.Split(fieldMetaStr, ",")
 		for _, tag := range tagsArr {
-			tagSplit := strings.Split(tag, "=")
+			tagSplit := strings.SplitN(tag, "=", 2)
 			if len(tagSplit) == 2 {
 				tags[tagSplit[0]] = tagSplit[1]
-			} else if len(tagSplit) == 1 {
-				tags[tagSplit[0]] = "true"
 			} else {
-				panic("Unsupported field tag")
+				tags[tagSplit[0]] = "true"
 			}
 		}
-	} else {
-		tags = make(map[string]string)
 	}
 
+	return tags
+}
+
+func paramName(fieldName string, tags map[string]string) string {
 	paramnameTag, isSet := tags["paramname"]
 	if isSet {
-		whereFrom = paramnameTag
-	} else {
-		whereFrom = strings.ToLower(fieldName)
+		return paramnameTag
 	}
+	return strings.ToLower(fieldName)
+}
 
-	_, isRequired := tags["required"]
+/*
+type SearchParams struct {
+	Query    string       `apivalidator:"regexp=^[a-z_.]*$"`
+	Email    string       `apivalidator:"email"`
+	Phone    string       `apivalidator:"validator=phone"`
+	Ids      []uint64     `apivalidator:"paramname=id,len=2,max=1000"`
+	Period   PeriodParams `apivalidator:"paramname=period"`
+}
+*/
 
-	defaultVal, defaultValIsSet := tags["default"]
-	
-	fieldBuf.WriteString(`
-	if r.Method == "GET" {
-		valStr = r.URL.Query().Get("` + whereFrom + `")
-	} else {
-		valStr = r.FormValue("` + whereFrom +`")
+func quoteList(vals []string) string {
+	quoted := make([]string, 0, len(vals))
+	for _, val := range vals {
+		quoted = append(quoted, strconv.Quote(val))
 	}
-`)
+	return strings.Join(quoted, ", ")
+}
 
-	if defaultValIsSet {
-		fieldBuf.WriteString(`
-	
-	if valStr == "" {
-		valStr = "` + defaultVal + `"
-	}
-`)
-	}
+func fieldErrorCode(msg string) string {
+	return `fieldError(prefix, ` + strconv.Quote(msg) + `)`
+}
 
-	if isRequired {
-		fieldBuf.WriteString(`
-	if valStr == "" {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("` + whereFrom + ` must me not empty")}
-	}
+func fieldRegexpVar(structName string, f paramField) string {
+	return "apivalidator" + structName + f.FieldName + "Regexp"
+}
+
+// generateFieldDeserializer returns code that fills a single field from params and checks it,
+// and declarations that code needs on the package level
+func generateFieldDeserializer(pkg *apiPackage, structName string, f paramField, imports map[string]bool) (code string, decls string) {
+	var buf bytes.Buffer
+	name := f.ParamName
+
+	buf.WriteString(`
+	// ` + f.FieldName + `
+`)
+	if f.Nested != nil {
+		buf.WriteString(`	deserialize` + pkg.typeFuncName(f.Nested) + `Fields(params, files, prefix+"` + name + `.", &model.` + f.FieldName + `, errs)
 `)
+		return buf.String(), ""
+	}
+	if uploadKinds[f.Kind] {
+		buf.WriteString(generateUploadDeserializer(f))
+		return buf.String(), ""
 	}
 
-	enumVals, enumValsAreSet := tags["enum"]
-	if enumValsAreSet {
-		split := strings.Split(enumVals, "|")
-		commaSeparated := strings.Join(split, ", ")
-		splitWithQuotes := make([]string, 0, len(split))
+	if re, isSet := f.Tags["regexp"]; isSet {
+		imports["regexp"] = true
+		decls = `
+var ` + fieldRegexpVar(structName, f) + ` = regexp.MustCompile(` + strconv.Quote(re) + `)
+`
+	}
 
-		for _, s := range split {
-			splitWithQuotes = append(splitWithQuotes, "\"" + s + "\"")
-		}
-		commaSeparatedWithQuotes := strings.Join(splitWithQuotes, ", ")
+	defaultVal, defaultValIsSet := f.Tags["default"]
+	_, isRequired := f.Tags["required"]
 
-		fieldBuf.WriteString(`
-	switch (valStr) {
-	case ` + commaSeparatedWithQuotes + `:
-		//ok!
-	default:
-		err := fmt.Errorf("` + whereFrom + ` must be one of [` + commaSeparated + `]")
-		return model, ApiError{http.StatusBadRequest, err}
-	}
+	buf.WriteString(`	errs.add(func() error {
 `)
-	}
 
-	if (fieldType == "string") {
-		fieldBuf.WriteString(`
-	model.` + fieldName + ` = valStr
+	if f.IsSlice {
+		buf.WriteString(`		vals := params[prefix+` + strconv.Quote(name) + `]
 `)
-	} else if (fieldType == "int") {
-		fieldBuf.WriteString(`
-	var convErr error
-	model.` + fieldName + `, convErr = strconv.Atoi(valStr)
-	if convErr != nil {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("` + whereFrom + ` must be int")}
-	}
+		if defaultValIsSet {
+			buf.WriteString(`		if len(vals) == 0 {
+			vals = []string{` + quoteList(strings.Split(defaultVal, "|")) + `}
+		}
+`)
+		}
+		if isRequired {
+			buf.WriteString(`		if len(vals) == 0 {
+			return ` + fieldErrorCode(name+" must me not empty") + `
+		}
+`)
+		}
+		if lenVal, isSet := f.Tags["len"]; isSet {
+			buf.WriteString(`		if len(vals) != ` + lenVal + ` {
+			return ` + fieldErrorCode(name+" must have "+lenVal+" elements") + `
+		}
 `)
+		}
+		buf.WriteString(`		model.` + f.FieldName + ` = make([]` + f.Kind + `, 0, len(vals))
+		for _, valStr := range vals {
+`)
+		buf.WriteString(generateValueDeserializer(structName, f, "\t\t\t", imports))
+		buf.WriteString(`			model.` + f.FieldName + ` = append(model.` + f.FieldName + `, v)
+		}
+		return nil
+	}())
+`)
+		return buf.String(), decls
 	}
 
-	minVal, minValIsSet := tags["min"]
-	if minValIsSet && fieldType == "string" {
-		fieldBuf.WriteString(`
-	if len(model.` + fieldName + `) < ` + minVal + ` {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("` + whereFrom + ` len must be >= ` + minVal + `")}
-	}
+	buf.WriteString(`		valStr := params.Get(prefix+` + strconv.Quote(name) + `)
+`)
+	if defaultValIsSet {
+		buf.WriteString(`		if valStr == "" {
+			valStr = ` + strconv.Quote(defaultVal) + `
+		}
 `)
 	}
-	if minValIsSet && fieldType == "int" {
-		fieldBuf.WriteString(`
-	if model.` + fieldName + ` < ` + minVal + ` {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("` + whereFrom + ` must be >= ` + minVal + `")}
+	if isRequired {
+		buf.WriteString(`		if valStr == "" {
+			return ` + fieldErrorCode(name+" must me not empty") + `
+		}
+`)
 	}
+	if f.Kind != "string" && !defaultValIsSet {
+		// an empty string is a valid string, but not a valid number
+		buf.WriteString(`		if valStr == "" {
+			return nil
+		}
 `)
 	}
+	buf.WriteString(generateValueDeserializer(structName, f, "\t\t", imports))
+	buf.WriteString(`		model.` + f.FieldName + ` = v
+		return nil
+	}())
+`)
+	return buf.String(), decls
+}
 
-	maxVal, maxValIsSet := tags["max"]
-	if maxValIsSet && fieldType == "string" {
-		fieldBuf.WriteString(`
-	if len(model.` + fieldName + `) > ` + maxVal + ` {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("` + whereFrom + ` len must be <= ` + maxVal + `")}
+// generateValueDeserializer returns code that turns valStr into v of the field kind and checks v
+func generateValueDeserializer(structName string, f paramField, indent string, imports map[string]bool) string {
+	var lines []string
+	name := f.ParamName
+	failWith := func(cond string, msg string) {
+		lines = append(lines,
+			`if `+cond+` {`,
+			`	return `+fieldErrorCode(msg),
+			`}`,
+		)
 	}
-`)
+
+	if enumVals, isSet := f.Tags["enum"]; isSet {
+		split := strings.Split(enumVals, "|")
+		lines = append(lines,
+			`switch valStr {`,
+			`case `+quoteList(split)+`:`,
+			`	//ok!`,
+			`default:`,
+			`	return `+fieldErrorCode(name+" must be one of ["+strings.Join(split, ", ")+"]"),
+			`}`,
+		)
+	}
+
+	// "int8" -> 8, "float64" -> 64, "int" -> 0 meaning the platform size
+	bits := strings.TrimLeft(f.Kind, "uintfloat")
+	if bits == "" {
+		bits = "0"
+	}
+	switch {
+	case f.Kind == "string":
+		lines = append(lines, `v := valStr`)
+	case f.Kind == "int":
+		imports["strconv"] = true
+		lines = append(lines, `v, convErr := strconv.Atoi(valStr)`)
+		failWith(`convErr != nil`, name+" must be int")
+	case f.Kind == "bool":
+		imports["strconv"] = true
+		lines = append(lines, `v, convErr := strconv.ParseBool(valStr)`)
+		failWith(`convErr != nil`, name+" must be bool")
+	case f.Kind == "time.Time":
+		imports["time"] = true
+		lines = append(lines, `v, convErr := time.Parse(`+strconv.Quote(f.Layout())+`, valStr)`)
+		failWith(`convErr != nil`, name+" must be time in format "+f.Layout())
+	case isIntKind(f.Kind):
+		imports["strconv"] = true
+		lines = append(lines, `parsed, convErr := strconv.ParseInt(valStr, 10, `+bits+`)`)
+		failWith(`convErr != nil`, name+" must be "+f.Kind)
+		lines = append(lines, `v := `+f.Kind+`(parsed)`)
+	case isUintKind(f.Kind):
+		imports["strconv"] = true
+		lines = append(lines, `parsed, convErr := strconv.ParseUint(valStr, 10, `+bits+`)`)
+		failWith(`convErr != nil`, name+" must be "+f.Kind)
+		lines = append(lines, `v := `+f.Kind+`(parsed)`)
+	case isFloatKind(f.Kind):
+		imports["strconv"] = true
+		lines = append(lines, `parsed, convErr := strconv.ParseFloat(valStr, `+bits+`)`)
+		failWith(`convErr != nil`, name+" must be "+f.Kind)
+		lines = append(lines, `v := `+f.Kind+`(parsed)`)
+	}
+
+	if minVal, isSet := f.Tags["min"]; isSet {
+		if f.Kind == "string" {
+			failWith(`len(v) < `+minVal, name+" len must be >= "+minVal)
+		} else {
+			failWith(`v < `+minVal, name+" must be >= "+minVal)
+		}
 	}
-	if maxValIsSet && fieldType == "int" {
-		fieldBuf.WriteString(`
-	if model.` + fieldName + ` > ` + maxVal + ` {
-		return model, ApiError{http.StatusBadRequest, fmt.Errorf("` + whereFrom + ` must be <= ` + maxVal + `")}
+	if maxVal, isSet := f.Tags["max"]; isSet {
+		if f.Kind == "string" {
+			failWith(`len(v) > `+maxVal, name+" len must be <= "+maxVal)
+		} else {
+			failWith(`v > `+maxVal, name+" must be <= "+maxVal)
+		}
 	}
-`)
+	if lenVal, isSet := f.Tags["len"]; isSet && !f.IsSlice {
+		failWith(`len(v) != `+lenVal, name+" len must be "+lenVal)
 	}
 
-	return fieldBuf.String()
-}
-
-func generateServeHttp(out io.Writer, apiTypeName string, configs []handlerConfig) {
-	fmt.Fprint(out, `
-func (h *` + apiTypeName + `) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {`)
-	for _, config := range configs {
-		fmt.Fprint(out, `
-	case "` + config.UrlPath + `":
-		h.handler` + config.ApiMethodName + `(w, r)`)
+	// format rules check only non-empty strings, emptiness is what required is for
+	notEmpty := ""
+	if f.Kind == "string" {
+		notEmpty = `v != "" && `
+	}
+	if re, isSet := f.Tags["regexp"]; isSet {
+		failWith(notEmpty+`!`+fieldRegexpVar(structName, f)+`.MatchString(v)`, name+" must match "+re)
+	}
+	if _, isSet := f.Tags["email"]; isSet {
+		imports["net/mail"] = true
+		failWith(notEmpty+`!isEmail(v)`, name+" must be email")
+	}
+	if validators, isSet := f.Tags["validator"]; isSet {
+		var validatorLines []string
+		for _, validator := range strings.Split(validators, "|") {
+			validatorLines = append(validatorLines,
+				`if err := runApiValidator(`+strconv.Quote(validator)+`, v); err != nil {`,
+				`	return fieldError(prefix, `+strconv.Quote(name+" ")+`+err.Error())`,
+				`}`,
+			)
+		}
+		if f.Kind == "string" {
+			lines = append(lines, `if v != "" {`)
+			for _, line := range validatorLines {
+				lines = append(lines, "\t"+line)
+			}
+			lines = append(lines, `}`)
+		} else {
+			lines = append(lines, validatorLines...)
+		}
 	}
 
-	fmt.Fprint(out, `
-	default:
-		handleError(ApiError{http.StatusNotFound, fmt.Errorf("unknown method")}, w)`)
-
-	fmt.Fprint(out, `
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(indent + line + "\n")
 	}
-}
-`)
+	return buf.String()
 }
 
 func main() {
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+	dir := flag.String("dir", ".", "directory of the package with apigen annotated methods")
+	outPath := flag.String("out", "api_handlers.go", "file to write the generated handlers to")
+	openApiDir := flag.String("openapi", "", "directory to write OpenAPI 3 documents to, one per api type")
+	clientDir := flag.String("client", "", "directory to write the generated client package to")
+	fuzzPath := flag.String("fuzz", "", "test file to write fuzz targets and property tests of deserializers to")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n"+
+			"       %s [flags] api.go api_handlers.go\n"+
+			"with //go:generate go run ./handlers_gen -out api_handlers.go\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// the old way: a file of the package and the output file
+	if flag.NArg() == 2 {
+		*dir = filepath.Dir(flag.Arg(0))
+		*outPath = flag.Arg(1)
+	} else if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	pkg, err := loadPackage(*dir, *outPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	out, _ := os.Create(os.Args[2])
-
-	fmt.Fprintln(out, `package `+node.Name.Name)
-	fmt.Fprintln(out) // empty line
+	// imports depend on the generated code, so it goes to the buffer first
+	out := &bytes.Buffer{}
+	imports := make(map[string]bool)
 	for _, pkg := range genPackages {
-		fmt.Fprintln(out, `import "` + pkg + `"`)
+		imports[pkg] = true
+	}
+	for _, pkg := range authPackages {
+		imports[pkg] = true
+	}
+	for _, pkg := range routerPackages {
+		imports[pkg] = true
+	}
+	for _, pkg := range middlewarePackages {
+		imports[pkg] = true
+	}
+	for _, pkg := range streamPackages {
+		imports[pkg] = true
+	}
+	for _, pkg := range uploadPackages {
+		imports[pkg] = true
 	}
-	fmt.Fprintln(out) // empty line
-	fmt.Fprintln(out, helperFuncsSrc)
 
-	paramsTypesNames := make(map[string]bool)
-	handlerConfigs := make(map[string][]handlerConfig)
+	fmt.Fprintln(out, helperFuncsSrc)
+	fmt.Fprintln(out, authHelpersSrc)
+	fmt.Fprintln(out, routerSrc)
+	fmt.Fprintln(out, middlewareSrc)
+	fmt.Fprintln(out, streamSrc)
+	fmt.Fprintln(out, uploadSrc)
+
+	var paramsTypes []*types.Named
+	seenParamsTypes := make(map[*types.Named]bool)
+	apiRoutes := make(map[string][]routeSpec)
+	var apiTypeNames []string
+	var apiMethods []apiMethodSpec
 
 	// generate entry-point handlers
-	for _, f := range node.Decls {
-		fDecl, isFunc := f.(*ast.FuncDecl); 
-		if !isFunc {
-			continue;
-		}
+	for _, file := range pkg.Files {
+		for _, f := range file.Decls {
+			fDecl, isFunc := f.(*ast.FuncDecl)
+			if !isFunc {
+				continue
+			}
+
+			if fDecl.Doc == nil {
+				continue
+			}
 
-		if fDecl.Doc == nil {
-			continue;
+			needCodegen := false
+			var config apigenConfig
+			for _, comment := range fDecl.Doc.List {
+				needCodegen = needCodegen || strings.HasPrefix(comment.Text, "// apigen:api")
+				configStr := strings.Replace(comment.Text, "// apigen:api ", "", 1)
+				config = apigenConfig{}
+				json.Unmarshal([]byte(configStr), &config)
+			}
+
+			if !needCodegen {
+				continue
+			}
+
+			method := getApiMethodInfo(pkg, fDecl)
+			method.Config = config
+			checkStream(method)
+			checkUploads(method)
+			generateHandler(out, pkg, method, imports)
+			checkPathParams(method.ApiTypeName, method.ApiMethodName, config.URL, method.ParamsType)
+			if !seenParamsTypes[method.ParamsType] {
+				seenParamsTypes[method.ParamsType] = true
+				paramsTypes = append(paramsTypes, method.ParamsType)
+			}
+			if _, seen := apiRoutes[method.ApiTypeName]; !seen {
+				apiTypeNames = append(apiTypeNames, method.ApiTypeName)
+			}
+			apiRoutes[method.ApiTypeName] = append(apiRoutes[method.ApiTypeName], routeSpec{
+				ApiMethodName: method.ApiMethodName,
+				URL: config.URL,
+				HttpMethod: config.Method,
+			})
+			apiMethods = append(apiMethods, method)
 		}
+	}
+
+	generateDeserializers(out, pkg, paramsTypes, imports)
+	if imports["net/mail"] {
+		fmt.Fprint(out, isEmailSrc)
+	}
+
+	for _, apiTypeName := range apiTypeNames {
+		generateServeHttp(out, apiTypeName, apiRoutes[apiTypeName])
+	}
+
+	src := &bytes.Buffer{}
+	fmt.Fprintln(src, `// Code generated by handlers_gen. DO NOT EDIT.`)
+	fmt.Fprintln(src) // empty line
+	fmt.Fprintln(src, `package `+pkg.Types.Name())
+	fmt.Fprintln(src) // empty line
+	fmt.Fprintln(src, `import (`)
+	for _, pkg := range sortedKeys(imports) {
+		fmt.Fprintln(src, `	"` + pkg + `"`)
+	}
+	fmt.Fprintln(src, `)`)
+	out.WriteTo(src)
+
+	formatted, fmtErr := format.Source(src.Bytes())
+	if fmtErr != nil {
+		// the unformatted file is still written to see what is wrong
+		formatted = src.Bytes()
+	}
+	err = ioutil.WriteFile(*outPath, formatted, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fmtErr != nil {
+		log.Fatalf("cant format generated handlers: %s", fmtErr)
+	}
 
-		needCodegen := false
-		var config apigenConfig
-		for _, comment := range fDecl.Doc.List {
-			needCodegen = needCodegen || strings.HasPrefix(comment.Text, "// apigen:api")
-			configStr := strings.Replace(comment.Text, "// apigen:api ", "", 1)
-			config = apigenConfig{}
-			json.Unmarshal([]byte(configStr), &config)
+	if *openApiDir != "" {
+		err = generateOpenApi(*openApiDir, apiMethods)
+		if err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		if needCodegen {
-			apiTypeName, apiMethodName, pTypeName := generateHandler(out, fDecl, config)
-			paramsTypesNames[pTypeName] = true		
-			handlerConfigs[apiTypeName] = append(handlerConfigs[apiTypeName], handlerConfig{
-				ApiMethodName: apiMethodName,
-				UrlPath: config.URL,
-			})
+	if *clientDir != "" {
+		err = generateClient(*clientDir, pkg, apiMethods)
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
 
-	generateDeserializers(out, node, paramsTypesNames)
+	if *fuzzPath != "" {
+		err = generateFuzzTests(*fuzzPath, pkg, paramsTypes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
 
-	for apiTypeName, apiHandlerConfigs := range handlerConfigs {
-		generateServeHttp(out, apiTypeName, apiHandlerConfigs)
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
 	}
-}
\ No newline at end of file
+	sort.Strings(keys)
+	return keys
+}
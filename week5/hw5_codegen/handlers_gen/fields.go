@@ -0,0 +1,215 @@
+package main
+
+import (
+	"go/types"
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+const defaultTimeLayout = "2006-01-02T15:04:05Z07:00"
+
+// paramField is a field of a params struct as the apivalidator tag describes it
+type paramField struct {
+	FieldName string
+	ParamName string
+	Tags      map[string]string
+	// go type of a value: "string", "int", "float64", "bool", "time.Time"...
+	Kind    string
+	IsSlice bool
+	// params struct of nested fields, Kind is empty then
+	Nested *types.Named
+}
+
+func (f paramField) Layout() string {
+	layout, isSet := f.Tags["layout"]
+	if !isSet {
+		return defaultTimeLayout
+	}
+	return layout
+}
+
+var scalarKinds = map[string]bool{
+	"string":    true,
+	"bool":      true,
+	"int":       true,
+	"int8":      true,
+	"int16":     true,
+	"int32":     true,
+	"int64":     true,
+	"uint":      true,
+	"uint8":     true,
+	"uint16":    true,
+	"uint32":    true,
+	"uint64":    true,
+	"float32":   true,
+	"float64":   true,
+	"time.Time": true,
+}
+
+func isIntKind(kind string) bool {
+	switch kind {
+	case "int", "int8", "int16", "int32", "int64":
+		return true
+	}
+	return false
+}
+
+func isUintKind(kind string) bool {
+	switch kind {
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		return true
+	}
+	return false
+}
+
+func isFloatKind(kind string) bool {
+	return kind == "float32" || kind == "float64"
+}
+
+func isNumericKind(kind string) bool {
+	return isIntKind(kind) || isUintKind(kind) || isFloatKind(kind)
+}
+
+// parseParamFields reads fields having an apivalidator tag, fields without a tag are not deserialized
+func parseParamFields(named *types.Named) []paramField {
+	structName := named.Obj().Name()
+	currStruct, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		log.Fatalf("%s: params type must be a struct", structName)
+	}
+
+	var fields []paramField
+	for i := 0; i < currStruct.NumFields(); i++ {
+		tag := reflect.StructTag(currStruct.Tag(i))
+		fieldMetaStr, isSet := tag.Lookup("apivalidator")
+		if !isSet {
+			continue
+		}
+
+		fieldName := currStruct.Field(i).Name()
+		tags := parseValidatorTags(fieldMetaStr)
+		pf := paramField{
+			FieldName: fieldName,
+			ParamName: paramName(fieldName, tags),
+			Tags:      tags,
+		}
+
+		fieldType := currStruct.Field(i).Type()
+		if slice, ok := fieldType.(*types.Slice); ok {
+			pf.IsSlice = true
+			fieldType = slice.Elem()
+		}
+
+		if basic, ok := fieldType.(*types.Basic); ok {
+			pf.Kind = basic.Name()
+		} else if kind := uploadKind(fieldType); kind != "" {
+			if pf.IsSlice {
+				log.Fatalf("%s.%s: only one upload per field is supported", structName, fieldName)
+			}
+			pf.Kind = kind
+		} else if isTimeType(fieldType) {
+			pf.Kind = "time.Time"
+		} else if nested, _ := structOf(fieldType); nested != nil && !pf.IsSlice {
+			pf.Nested = nested
+		}
+
+		if pf.Nested == nil && !scalarKinds[pf.Kind] && !uploadKinds[pf.Kind] {
+			log.Fatalf("%s.%s: unsupported field type", structName, fieldName)
+		}
+		checkFieldTags(structName, pf)
+
+		fields = append(fields, pf)
+	}
+	return fields
+}
+
+// checkFieldTags fails generation on rules that make no sense for the field type
+func checkFieldTags(structName string, f paramField) {
+	fail := func(rule string) {
+		log.Fatalf("%s.%s: rule %s is not supported for this field type", structName, f.FieldName, rule)
+	}
+
+	if f.Nested != nil {
+		for rule := range f.Tags {
+			if rule != "paramname" {
+				fail(rule)
+			}
+		}
+		return
+	}
+	if uploadKinds[f.Kind] {
+		for rule := range f.Tags {
+			if rule != "paramname" && rule != "required" && rule != "maxsize" {
+				fail(rule)
+			}
+		}
+		if maxSize, isSet := f.Tags["maxsize"]; isSet {
+			if size, err := strconv.ParseInt(maxSize, 10, 64); err != nil || size <= 0 {
+				log.Fatalf("%s.%s: maxsize must be a positive int", structName, f.FieldName)
+			}
+		}
+		return
+	}
+	if _, isSet := f.Tags["maxsize"]; isSet {
+		fail("maxsize")
+	}
+
+	for _, rule := range []string{"min", "max"} {
+		val, isSet := f.Tags[rule]
+		if !isSet {
+			continue
+		}
+		if f.Kind != "string" && !isNumericKind(f.Kind) {
+			fail(rule)
+		}
+		if _, err := strconv.ParseFloat(val, 64); err != nil {
+			log.Fatalf("%s.%s: %s must be a number", structName, f.FieldName, rule)
+		}
+	}
+	if val, isSet := f.Tags["len"]; isSet {
+		if f.Kind != "string" && !f.IsSlice {
+			fail("len")
+		}
+		if _, err := strconv.Atoi(val); err != nil {
+			log.Fatalf("%s.%s: len must be int", structName, f.FieldName)
+		}
+	}
+	if re, isSet := f.Tags["regexp"]; isSet {
+		if f.Kind != "string" {
+			fail("regexp")
+		}
+		if _, err := regexp.Compile(re); err != nil {
+			log.Fatalf("%s.%s: bad regexp: %s", structName, f.FieldName, err)
+		}
+	}
+	if _, isSet := f.Tags["email"]; isSet && f.Kind != "string" {
+		fail("email")
+	}
+	if _, isSet := f.Tags["layout"]; isSet && f.Kind != "time.Time" {
+		fail("layout")
+	}
+}
+
+// collectParamsTypes returns the given params types together with nested params structs reachable from them
+func collectParamsTypes(paramsTypes []*types.Named) []*types.Named {
+	all := make([]*types.Named, 0, len(paramsTypes))
+	seen := make(map[*types.Named]bool)
+	queue := append([]*types.Named{}, paramsTypes...)
+	for len(queue) > 0 {
+		named := queue[0]
+		queue = queue[1:]
+		if seen[named] {
+			continue
+		}
+		seen[named] = true
+		all = append(all, named)
+		for _, f := range parseParamFields(named) {
+			if f.Nested != nil {
+				queue = append(queue, f.Nested)
+			}
+		}
+	}
+	return all
+}
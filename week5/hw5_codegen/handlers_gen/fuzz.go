@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type fuzzTestTplArg struct {
+	FuncName   string
+	ParamNames string
+	SeedQuery  string
+}
+
+var fuzzTestTpl = template.Must(template.New("fuzzTestTpl").Parse(`
+func TestDeserialize{{.FuncName}}RandomQueries(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	names := {{.ParamNames}}
+	for i := 0; i < 1000; i++ {
+		checkDeserializeQuery(t, randomQuery(rnd, names), func(r *http.Request) error {
+			_, err := deserialize{{.FuncName}}(r)
+			return err
+		})
+	}
+}
+
+func FuzzDeserialize{{.FuncName}}(f *testing.F) {
+	f.Add("")
+	f.Add({{.SeedQuery}})
+	f.Fuzz(func(t *testing.T, query string) {
+		checkDeserializeQuery(t, query, func(r *http.Request) error {
+			_, err := deserialize{{.FuncName}}(r)
+			return err
+		})
+	})
+}
+`))
+
+var fuzzHelpersSrc = `
+// fuzzParamValues are values deserializers have to tell from valid ones
+var fuzzParamValues = []string{
+	"", "0", "1", "-1", "1.5", "1e400", "NaN", "true", "abc", " 1",
+	"9223372036854775807", "9223372036854775808", "-9223372036854775809",
+	"2018-04-02T15:04:05Z", "2018-13-45", "\x00", "\xff", "привет", "<script>",
+}
+
+// randomQuery is a query string mostly of known params, with repeated and unknown ones
+func randomQuery(rnd *rand.Rand, names []string) string {
+	params := url.Values{}
+	for n := rnd.Intn(len(names) + 2); n > 0; n-- {
+		name := "unknown"
+		if len(names) > 0 && rnd.Intn(5) > 0 {
+			name = names[rnd.Intn(len(names))]
+		}
+		value := fuzzParamValues[rnd.Intn(len(fuzzParamValues))]
+		if rnd.Intn(4) == 0 {
+			raw := make([]byte, rnd.Intn(8))
+			rnd.Read(raw)
+			value = string(raw)
+		}
+		params.Add(name, value)
+	}
+	return params.Encode()
+}
+
+// checkDeserializeQuery fails the test if the deserializer panics or its error would be answered with 5xx
+func checkDeserializeQuery(t *testing.T, query string, deserialize func(r *http.Request) error) {
+	t.Helper()
+	r := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: "/", RawQuery: query},
+		Header: http.Header{},
+	}
+	err := deserialize(r)
+	if err == nil {
+		return
+	}
+	apiErr, isApiError := err.(ApiError)
+	if !isApiError || apiErr.HTTPStatus >= http.StatusInternalServerError {
+		t.Fatalf("query %q: error %q would be answered with 500", query, err)
+	}
+}
+`
+
+// paramNames returns names of query params of the params struct and its nested structs, uploads dont come in queries
+func paramNames(named *types.Named, prefix string) []string {
+	var names []string
+	for _, f := range parseParamFields(named) {
+		switch {
+		case f.Nested != nil:
+			names = append(names, paramNames(f.Nested, prefix+f.ParamName+".")...)
+		case !uploadKinds[f.Kind]:
+			names = append(names, prefix+f.ParamName)
+		}
+	}
+	return names
+}
+
+// generateFuzzTests writes a test file feeding deserializers of params random query strings,
+// they must answer anything with a validation error and never with 500
+func generateFuzzTests(path string, pkg *apiPackage, paramsTypes []*types.Named) error {
+	out := &bytes.Buffer{}
+	fmt.Fprintln(out, `// Code generated by handlers_gen. DO NOT EDIT.`)
+	fmt.Fprintln(out) // empty line
+	fmt.Fprintln(out, `package `+pkg.Types.Name())
+	fmt.Fprintln(out) // empty line
+	fmt.Fprintln(out, `import (`)
+	for _, imp := range []string{"math/rand", "net/http", "net/url", "testing"} {
+		fmt.Fprintln(out, `	"`+imp+`"`)
+	}
+	fmt.Fprintln(out, `)`)
+	fmt.Fprint(out, fuzzHelpersSrc)
+
+	for _, paramsType := range paramsTypes {
+		funcName := pkg.typeFuncName(paramsType)
+		fmt.Printf("Generating fuzz test for %s\n", funcName)
+
+		names := paramNames(paramsType, "")
+		quoted := make([]string, 0, len(names))
+		seed := make([]string, 0, len(names))
+		for _, name := range names {
+			quoted = append(quoted, strconv.Quote(name))
+			seed = append(seed, name+"=1")
+		}
+		err := fuzzTestTpl.Execute(out, fuzzTestTplArg{
+			FuncName:   funcName,
+			ParamNames: "[]string{" + strings.Join(quoted, ", ") + "}",
+			SeedQuery:  strconv.Quote(strings.Join(seed, "&")),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return fmt.Errorf("cant format generated fuzz tests: %s", err)
+	}
+	return ioutil.WriteFile(path, src, 0644)
+}
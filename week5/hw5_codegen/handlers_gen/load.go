@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apiPackage is the parsed and type checked package handlers are generated for
+type apiPackage struct {
+	Fset  *token.FileSet
+	Files []*ast.File
+	Types *types.Package
+	Info  *types.Info
+	// import paths as they are written in the package sources, generated code imports packages the same way
+	importPaths map[*types.Package]string
+}
+
+// loadPackage parses all go files of dir except tests and the file being generated and type checks them,
+// imported packages are type checked from sources too
+func loadPackage(dir string, skipFile string) (*apiPackage, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	skipPath, err := filepath.Abs(skipFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	files, err := parsePackageDir(fset, absDir, skipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &types.Info{
+		Types:     make(map[ast.Expr]types.TypeAndValue),
+		Defs:      make(map[*ast.Ident]types.Object),
+		Uses:      make(map[*ast.Ident]types.Object),
+		Implicits: make(map[ast.Node]types.Object),
+	}
+	conf := types.Config{
+		Importer: newLocalImporter(fset),
+		// the package uses what is not generated yet (ServeHTTP, Principal...),
+		// so errors are expected, types the generator needs are checked where they are used
+		Error: func(err error) {},
+	}
+	typesPkg, _ := conf.Check(absDir, fset, files, info)
+
+	p := &apiPackage{
+		Fset:        fset,
+		Files:       files,
+		Types:       typesPkg,
+		Info:        info,
+		importPaths: make(map[*types.Package]string),
+	}
+	for _, file := range files {
+		for _, spec := range file.Imports {
+			obj, exists := info.Implicits[spec]
+			if spec.Name != nil {
+				obj, exists = info.Defs[spec.Name]
+			}
+			pkgName, ok := obj.(*types.PkgName)
+			if !exists || !ok {
+				continue
+			}
+			p.importPaths[pkgName.Imported()], _ = strconv.Unquote(spec.Path.Value)
+		}
+	}
+	return p, nil
+}
+
+func parsePackageDir(fset *token.FileSet, dir string, skipPath string) ([]*ast.File, error) {
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && filepath.Join(dir, fi.Name()) != skipPath
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+
+	var files []*ast.File
+	for _, pkg := range pkgs {
+		fileNames := make([]string, 0, len(pkg.Files))
+		for fileName := range pkg.Files {
+			fileNames = append(fileNames, fileName)
+		}
+		sort.Strings(fileNames)
+		for _, fileName := range fileNames {
+			files = append(files, pkg.Files[fileName])
+		}
+	}
+	return files, nil
+}
+
+// localImporter type checks relative imports like "./models" itself,
+// the source importer would give all of them the same "." path
+type localImporter struct {
+	fset     *token.FileSet
+	base     types.ImporterFrom
+	packages map[string]*types.Package
+}
+
+func newLocalImporter(fset *token.FileSet) *localImporter {
+	return &localImporter{
+		fset:     fset,
+		base:     importer.ForCompiler(fset, "source", nil).(types.ImporterFrom),
+		packages: make(map[string]*types.Package),
+	}
+}
+
+func (li *localImporter) Import(path string) (*types.Package, error) {
+	return li.ImportFrom(path, "", 0)
+}
+
+func (li *localImporter) ImportFrom(path string, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	if !build.IsLocalImport(path) {
+		return li.base.ImportFrom(path, srcDir, mode)
+	}
+
+	dir := filepath.Join(srcDir, path)
+	if pkg, exists := li.packages[dir]; exists {
+		return pkg, nil
+	}
+	files, err := parsePackageDir(li.fset, dir, "")
+	if err != nil {
+		return nil, err
+	}
+	conf := types.Config{Importer: li}
+	pkg, err := conf.Check(dir, li.fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	li.packages[dir] = pkg
+	return pkg, nil
+}
+
+// qualifier writes types the way generated code of the package refers to them and collects imports it needs
+func (p *apiPackage) qualifier(imports map[string]bool) types.Qualifier {
+	return func(other *types.Package) string {
+		if other == p.Types {
+			return ""
+		}
+		imports[p.importPath(other)] = true
+		return other.Name()
+	}
+}
+
+func (p *apiPackage) importPath(other *types.Package) string {
+	if path, exists := p.importPaths[other]; exists {
+		return path
+	}
+	return other.Path()
+}
+
+// isLocal tells if other is the package itself or one of its subpackages
+func (p *apiPackage) isLocal(other *types.Package) bool {
+	return other != nil && (other == p.Types || strings.HasPrefix(other.Path(), p.Types.Path()+"/"))
+}
+
+// structOf returns the struct a named type is declared with, nil for other types
+func structOf(t types.Type) (*types.Named, *types.Struct) {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil, nil
+	}
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return nil, nil
+	}
+	return named, st
+}
+
+func isTimeType(t types.Type) bool {
+	return isNamedType(t, "time", "Time")
+}
+
+// typeFuncName is a name for functions generated for a type, types of other packages get the package name in front
+func (p *apiPackage) typeFuncName(named *types.Named) string {
+	obj := named.Obj()
+	if obj.Pkg() == p.Types {
+		return obj.Name()
+	}
+	return strings.Title(obj.Pkg().Name()) + obj.Name()
+}
+
+// sortByPos orders types as they are declared, packages are ordered by their files
+func (p *apiPackage) sortByPos(named []*types.Named) {
+	sort.Slice(named, func(i, j int) bool {
+		posI := p.Fset.Position(named[i].Obj().Pos())
+		posJ := p.Fset.Position(named[j].Obj().Pos())
+		if posI.Filename != posJ.Filename {
+			return posI.Filename < posJ.Filename
+		}
+		return posI.Offset < posJ.Offset
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// generateServeHttpWithMiddlewares makes ServeHTTP run the api middlewares around the router
+func generateServeHttpWithMiddlewares(out io.Writer, apiTypeName string) {
+	fmt.Fprint(out, `
+func (h *`+apiTypeName+`) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithMiddlewares(h, w, r, h.route)
+}
+`)
+}
+
+var (
+	middlewarePackages = []string{
+		"context",
+		"net/http",
+	}
+
+	middlewareSrc = `
+// Middleware wraps the generated ServeHTTP of an api
+type Middleware func(next http.Handler) http.Handler
+
+// MiddlewareProvider is implemented by api structs that want middlewares, the first one is the outermost.
+// It is called on every request, so the middlewares should be created once and only returned here
+type MiddlewareProvider interface {
+	Middlewares() []Middleware
+}
+
+func serveWithMiddlewares(api interface{}, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	provider, ok := api.(MiddlewareProvider)
+	if !ok {
+		next(w, r)
+		return
+	}
+	var handler http.Handler = next
+	middlewares := provider.Middlewares()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// RecoverMiddleware turns a panic into the 500 response
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				handleError(fmt.Errorf("internal error"), w)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ApiHooks are called by the generated handler of an api method, any of them may be nil
+type ApiHooks struct {
+	// BeforeValidate runs after authentication, an error stops the request
+	BeforeValidate func(ctx context.Context, r *http.Request) error
+	// AfterCall sees params, result and error of the method and returns the error to respond with
+	AfterCall func(ctx context.Context, params interface{}, result interface{}, err error) error
+	// OnError may replace any error of the handler before it is written to the response, nil keeps the error
+	OnError func(ctx context.Context, err error) error
+}
+
+// HooksProvider is implemented by api structs that want hooks, method is the name of the api method
+type HooksProvider interface {
+	Hooks(method string) ApiHooks
+}
+
+func hooksFor(api interface{}, method string) ApiHooks {
+	if provider, ok := api.(HooksProvider); ok {
+		return provider.Hooks(method)
+	}
+	return ApiHooks{}
+}
+
+func (hooks ApiHooks) beforeValidate(ctx context.Context, r *http.Request) error {
+	if hooks.BeforeValidate == nil {
+		return nil
+	}
+	return hooks.BeforeValidate(ctx, r)
+}
+
+func (hooks ApiHooks) afterCall(ctx context.Context, params interface{}, result interface{}, err error) error {
+	if hooks.AfterCall == nil {
+		return err
+	}
+	return hooks.AfterCall(ctx, params, result, err)
+}
+
+func (hooks ApiHooks) fail(ctx context.Context, err error, w http.ResponseWriter) {
+	if hooks.OnError != nil {
+		if replaced := hooks.OnError(ctx, err); replaced != nil {
+			err = replaced
+		}
+	}
+	handleError(err, w)
+}
+`
+)
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type apiMethodSpec struct {
+	ApiTypeName   string
+	ApiMethodName string
+	ParamsType    *types.Named
+	ResultType    types.Type
+	Config        apigenConfig
+}
+
+type openApiDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openApiInfo                             `json:"info"`
+	Paths      map[string]map[string]*openApiOperation `json:"paths"`
+	Components openApiComponents                       `json:"components"`
+}
+
+type openApiInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openApiComponents struct {
+	Schemas         map[string]*openApiSchema         `json:"schemas"`
+	SecuritySchemes map[string]*openApiSecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type openApiSecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+type openApiOperation struct {
+	OperationID string                      `json:"operationId"`
+	Description string                      `json:"description,omitempty"`
+	Parameters  []*openApiParameter         `json:"parameters,omitempty"`
+	RequestBody *openApiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openApiResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openApiParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openApiSchema `json:"schema"`
+}
+
+type openApiRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openApiMediaType `json:"content"`
+}
+
+type openApiResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openApiMediaType `json:"content,omitempty"`
+}
+
+type openApiMediaType struct {
+	Schema *openApiSchema `json:"schema"`
+}
+
+type openApiSchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Items                *openApiSchema            `json:"items,omitempty"`
+	Properties           map[string]*openApiSchema `json:"properties,omitempty"`
+	AdditionalProperties *openApiSchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Default              interface{}               `json:"default,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+}
+
+const (
+	openApiSecuritySchemeName = "apiKey"
+	openApiErrorSchemaName    = "ErrorResponse"
+)
+
+// generateOpenApi writes one OpenAPI 3 document per api type into dir
+func generateOpenApi(dir string, methods []apiMethodSpec) error {
+	docs := make(map[string]*openApiDoc)
+	var apiTypeNames []string
+
+	for _, method := range methods {
+		doc, exists := docs[method.ApiTypeName]
+		if !exists {
+			doc = newOpenApiDoc(method.ApiTypeName)
+			docs[method.ApiTypeName] = doc
+			apiTypeNames = append(apiTypeNames, method.ApiTypeName)
+		}
+
+		fmt.Printf("Generating OpenAPI operation for %s.%s\n", method.ApiTypeName, method.ApiMethodName)
+
+		httpMethods := []string{"get", "post"}
+		if method.Config.Method != "" {
+			httpMethods = []string{strings.ToLower(method.Config.Method)}
+		}
+
+		pathItem, exists := doc.Paths[method.Config.URL]
+		if !exists {
+			pathItem = make(map[string]*openApiOperation)
+			doc.Paths[method.Config.URL] = pathItem
+		}
+		for _, httpMethod := range httpMethods {
+			pathItem[httpMethod] = newOpenApiOperation(doc, method, httpMethod)
+		}
+	}
+
+	for _, apiTypeName := range apiTypeNames {
+		docBytes, err := json.MarshalIndent(docs[apiTypeName], "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, apiTypeName+".openapi.json"), docBytes, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newOpenApiDoc(apiTypeName string) *openApiDoc {
+	return &openApiDoc{
+		OpenAPI: "3.0.3",
+		Info: openApiInfo{
+			Title:   apiTypeName,
+			Version: "1.0.0",
+		},
+		Paths: make(map[string]map[string]*openApiOperation),
+		Components: openApiComponents{
+			Schemas: map[string]*openApiSchema{
+				openApiErrorSchemaName: &openApiSchema{
+					Type: "object",
+					Properties: map[string]*openApiSchema{
+						"error": &openApiSchema{Type: "string"},
+					},
+					Required: []string{"error"},
+				},
+			},
+		},
+	}
+}
+
+func newOpenApiOperation(doc *openApiDoc, method apiMethodSpec, httpMethod string) *openApiOperation {
+	op := &openApiOperation{
+		OperationID: method.ApiMethodName,
+		Responses:   make(map[string]*openApiResponse),
+	}
+	if method.Config.Method == "" {
+		op.OperationID = method.ApiMethodName + strings.Title(httpMethod)
+	}
+
+	params, paramNames := paramsSchema(method.ParamsType)
+	// path params are validated too, so 400 is possible even without query or body params
+	hasParams := len(params.Properties) > 0
+	for _, name := range pathParamNames(method.Config.URL) {
+		op.Parameters = append(op.Parameters, &openApiParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   params.Properties[name],
+		})
+		params, paramNames = withoutParam(params, paramNames, name)
+	}
+	if httpMethod == "get" {
+		for _, name := range paramNames {
+			op.Parameters = append(op.Parameters, &openApiParameter{
+				Name:     name,
+				In:       "query",
+				Required: contains(params.Required, name),
+				Schema:   params.Properties[name],
+			})
+		}
+	} else if len(params.Properties) > 0 {
+		op.RequestBody = &openApiRequestBody{
+			Required: len(params.Required) > 0,
+			Content: map[string]*openApiMediaType{
+				"application/x-www-form-urlencoded": &openApiMediaType{Schema: params},
+				"multipart/form-data":               &openApiMediaType{Schema: params},
+				"application/json":                  &openApiMediaType{Schema: params},
+			},
+		}
+		// files come only with multipart forms
+		if uploads, _ := uploadFields(method.ParamsType, ""); len(uploads) > 0 {
+			delete(op.RequestBody.Content, "application/x-www-form-urlencoded")
+			delete(op.RequestBody.Content, "application/json")
+			op.Responses["413"] = errorResponse("request body too large")
+		}
+	}
+	if httpMethod != "get" {
+		op.Responses["415"] = errorResponse("unsupported media type")
+	}
+
+	if method.Config.Auth.Required {
+		if doc.Components.SecuritySchemes == nil {
+			doc.Components.SecuritySchemes = map[string]*openApiSecurityScheme{
+				openApiSecuritySchemeName: &openApiSecurityScheme{
+					Type: "apiKey",
+					In:   "header",
+					Name: "X-Auth",
+				},
+			}
+		}
+		op.Security = []map[string][]string{{openApiSecuritySchemeName: []string{}}}
+		op.Responses["403"] = errorResponse("unauthorized")
+		if method.Config.Auth.Role != "" {
+			op.Description = "requires role " + method.Config.Auth.Role
+		}
+	}
+
+	resultType, mediaType, description := method.ResultType, "application/json", "successful response"
+	if _, elem := streamResult(method.ResultType); elem != nil {
+		resultType = elem
+		switch method.Config.Stream {
+		case streamNDJSON:
+			mediaType, description = "application/x-ndjson", "stream of items, one per line, the last one may be an error"
+		case streamSSE:
+			mediaType, description = "text/event-stream", "stream of items, one per event data, an error comes with the error event"
+		}
+	}
+	op.Responses["200"] = &openApiResponse{
+		Description: description,
+		Content: map[string]*openApiMediaType{
+			mediaType: &openApiMediaType{
+				Schema: &openApiSchema{
+					Type: "object",
+					Properties: map[string]*openApiSchema{
+						"error":    &openApiSchema{Type: "string"},
+						"response": typeSchema(resultType, doc.Components.Schemas),
+					},
+					Required: []string{"error", "response"},
+				},
+			},
+		},
+	}
+	if hasParams {
+		op.Responses["400"] = errorResponse("invalid parameters")
+	}
+	op.Responses["default"] = errorResponse("error returned by the method")
+
+	return op
+}
+
+func errorResponse(description string) *openApiResponse {
+	return &openApiResponse{
+		Description: description,
+		Content: map[string]*openApiMediaType{
+			"application/json": &openApiMediaType{
+				Schema: &openApiSchema{Ref: "#/components/schemas/" + openApiErrorSchemaName},
+			},
+		},
+	}
+}
+
+// paramsSchema describes the params struct the way the generated deserializer reads it,
+// nested structs are flattened into "parent.child" params,
+// names are returned in the order of struct fields
+func paramsSchema(named *types.Named) (*openApiSchema, []string) {
+	schema := &openApiSchema{
+		Type:       "object",
+		Properties: make(map[string]*openApiSchema),
+	}
+	names := addParamsProperties(schema, nil, "", named)
+	return schema, names
+}
+
+func addParamsProperties(schema *openApiSchema, names []string, prefix string, named *types.Named) []string {
+	for _, f := range parseParamFields(named) {
+		name := prefix + f.ParamName
+		if f.Nested != nil {
+			names = addParamsProperties(schema, names, name+".", f.Nested)
+			continue
+		}
+
+		schema.Properties[name] = paramSchema(f)
+		names = append(names, name)
+		if _, isRequired := f.Tags["required"]; isRequired {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return names
+}
+
+// withoutParam returns a copy of the params schema without a param that goes elsewhere, in path for example
+func withoutParam(params *openApiSchema, names []string, name string) (*openApiSchema, []string) {
+	schema := &openApiSchema{
+		Type:       params.Type,
+		Properties: make(map[string]*openApiSchema),
+	}
+	for propName, prop := range params.Properties {
+		if propName != name {
+			schema.Properties[propName] = prop
+		}
+	}
+	for _, required := range params.Required {
+		if required != name {
+			schema.Required = append(schema.Required, required)
+		}
+	}
+
+	var otherNames []string
+	for _, otherName := range names {
+		if otherName != name {
+			otherNames = append(otherNames, otherName)
+		}
+	}
+	return schema, otherNames
+}
+
+func paramSchema(f paramField) *openApiSchema {
+	if uploadKinds[f.Kind] {
+		return &openApiSchema{
+			Type:        "string",
+			Format:      "binary",
+			Description: "at most " + strconv.FormatInt(f.MaxSize(), 10) + " bytes",
+		}
+	}
+
+	schema := kindSchema(f.Kind, f.Layout())
+
+	if enumVals, isSet := f.Tags["enum"]; isSet {
+		for _, val := range strings.Split(enumVals, "|") {
+			schema.Enum = append(schema.Enum, paramValue(f.Kind, val))
+		}
+	}
+	if defaultVal, isSet := f.Tags["default"]; isSet && !f.IsSlice {
+		schema.Default = paramValue(f.Kind, defaultVal)
+	}
+
+	minVal, minValIsSet := f.Tags["min"]
+	maxVal, maxValIsSet := f.Tags["max"]
+	if schema.Type == "string" {
+		if minValIsSet {
+			minLen, _ := strconv.Atoi(minVal)
+			schema.MinLength = &minLen
+		}
+		if maxValIsSet {
+			maxLen, _ := strconv.Atoi(maxVal)
+			schema.MaxLength = &maxLen
+		}
+	} else {
+		if minValIsSet {
+			min, _ := strconv.ParseFloat(minVal, 64)
+			schema.Minimum = &min
+		}
+		if maxValIsSet {
+			max, _ := strconv.ParseFloat(maxVal, 64)
+			schema.Maximum = &max
+		}
+	}
+	if lenVal, isSet := f.Tags["len"]; isSet && !f.IsSlice {
+		length, _ := strconv.Atoi(lenVal)
+		schema.MinLength = &length
+		schema.MaxLength = &length
+	}
+	if re, isSet := f.Tags["regexp"]; isSet {
+		schema.Pattern = re
+	}
+	if _, isSet := f.Tags["email"]; isSet {
+		schema.Format = "email"
+	}
+
+	if !f.IsSlice {
+		return schema
+	}
+
+	arraySchema := &openApiSchema{Type: "array", Items: schema}
+	if defaultVal, isSet := f.Tags["default"]; isSet {
+		var defaults []interface{}
+		for _, val := range strings.Split(defaultVal, "|") {
+			defaults = append(defaults, paramValue(f.Kind, val))
+		}
+		arraySchema.Default = defaults
+	}
+	if lenVal, isSet := f.Tags["len"]; isSet {
+		length, _ := strconv.Atoi(lenVal)
+		arraySchema.MinItems = &length
+		arraySchema.MaxItems = &length
+	}
+	return arraySchema
+}
+
+func paramValue(kind string, val string) interface{} {
+	switch kindSchema(kind, defaultTimeLayout).Type {
+	case "integer":
+		if intVal, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return intVal
+		}
+	case "number":
+		if floatVal, err := strconv.ParseFloat(val, 64); err == nil {
+			return floatVal
+		}
+	case "boolean":
+		if boolVal, err := strconv.ParseBool(val); err == nil {
+			return boolVal
+		}
+	}
+	return val
+}
+
+func kindSchema(kind string, layout string) *openApiSchema {
+	if kind == "time.Time" {
+		if layout == defaultTimeLayout {
+			return &openApiSchema{Type: "string", Format: "date-time"}
+		}
+		return &openApiSchema{Type: "string"}
+	}
+	return primitiveSchema(kind)
+}
+
+func primitiveSchema(typeName string) *openApiSchema {
+	switch typeName {
+	case "int", "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32":
+		return &openApiSchema{Type: "integer", Format: "int32"}
+	case "int64", "uint64":
+		return &openApiSchema{Type: "integer", Format: "int64"}
+	case "float32":
+		return &openApiSchema{Type: "number", Format: "float"}
+	case "float64":
+		return &openApiSchema{Type: "number", Format: "double"}
+	case "bool":
+		return &openApiSchema{Type: "boolean"}
+	case "string":
+		return &openApiSchema{Type: "string"}
+	}
+	return nil
+}
+
+// typeSchema describes how a go type is marshalled into json, named structs become components
+func typeSchema(t types.Type, components map[string]*openApiSchema) *openApiSchema {
+	if isTimeType(t) {
+		return &openApiSchema{Type: "string", Format: "date-time"}
+	}
+	switch t := t.(type) {
+	case *types.Pointer:
+		return typeSchema(t.Elem(), components)
+	case *types.Slice:
+		if basic, ok := t.Elem().(*types.Basic); ok && basic.Kind() == types.Byte {
+			return &openApiSchema{Type: "string", Format: "byte"}
+		}
+		return &openApiSchema{Type: "array", Items: typeSchema(t.Elem(), components)}
+	case *types.Array:
+		return &openApiSchema{Type: "array", Items: typeSchema(t.Elem(), components)}
+	case *types.Map:
+		return &openApiSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), components)}
+	case *types.Basic:
+		if schema := primitiveSchema(t.Name()); schema != nil {
+			return schema
+		}
+	case *types.Struct:
+		return structSchema(t, components)
+	case *types.Named:
+		currStruct, ok := t.Underlying().(*types.Struct)
+		if !ok {
+			return typeSchema(t.Underlying(), components)
+		}
+		name := t.Obj().Name()
+		if _, done := components[name]; !done {
+			// placeholder first so that recursive types terminate
+			components[name] = &openApiSchema{}
+			components[name] = structSchema(currStruct, components)
+		}
+		return &openApiSchema{Ref: "#/components/schemas/" + name}
+	}
+	return &openApiSchema{}
+}
+
+func structSchema(currStruct *types.Struct, components map[string]*openApiSchema) *openApiSchema {
+	schema := &openApiSchema{
+		Type:       "object",
+		Properties: make(map[string]*openApiSchema),
+	}
+	for i := 0; i < currStruct.NumFields(); i++ {
+		field := currStruct.Field(i)
+		if field.Anonymous() || !field.Exported() {
+			continue
+		}
+		name := field.Name()
+		tag := reflect.StructTag(currStruct.Tag(i))
+		jsonTag := strings.Split(tag.Get("json"), ",")
+		if jsonTag[0] == "-" {
+			continue
+		}
+		if jsonTag[0] != "" {
+			name = jsonTag[0]
+		}
+		schema.Properties[name] = typeSchema(field.Type(), components)
+		if !contains(jsonTag[1:], "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type routeSpec struct {
+	ApiMethodName string
+	URL           string
+	// empty method serves any method not served by another route of the same url
+	HttpMethod string
+}
+
+// pathParamNames returns names of {name} segments of an url template in order
+func pathParamNames(urlTemplate string) []string {
+	var names []string
+	for _, segment := range strings.Split(urlTemplate, "/") {
+		if name, isParam := pathParamName(segment); isParam {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func pathParamName(segment string) (string, bool) {
+	if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+		return "", false
+	}
+	return segment[1 : len(segment)-1], true
+}
+
+// checkRoutes fails generation on routes the router cant serve unambiguously
+func checkRoutes(apiTypeName string, routes []routeSpec) {
+	// url templates with params replaced by {}, so /user/{id} and /user/{login} are the same route
+	seen := make(map[string]string)
+	paramNames := make(map[string]string)
+
+	for _, route := range routes {
+		if !strings.HasPrefix(route.URL, "/") {
+			log.Fatalf("%s.%s: url %q must start with /", apiTypeName, route.ApiMethodName, route.URL)
+		}
+
+		var pattern string
+		for _, segment := range strings.Split(route.URL[1:], "/") {
+			name, isParam := pathParamName(segment)
+			if !isParam {
+				if strings.ContainsAny(segment, "{}") {
+					log.Fatalf("%s.%s: bad path segment %q", apiTypeName, route.ApiMethodName, segment)
+				}
+				pattern += "/" + segment
+				continue
+			}
+			if name == "" {
+				log.Fatalf("%s.%s: path param must have a name", apiTypeName, route.ApiMethodName)
+			}
+			pattern += "/{}"
+			if prevName, exists := paramNames[pattern]; exists && prevName != name {
+				log.Fatalf("%s.%s: path param {%s} conflicts with {%s} in another url",
+					apiTypeName, route.ApiMethodName, name, prevName)
+			}
+			paramNames[pattern] = name
+		}
+
+		key := route.HttpMethod + " " + pattern
+		if prevMethod, exists := seen[key]; exists {
+			log.Fatalf("%s.%s: %s %s is already served by %s",
+				apiTypeName, route.ApiMethodName, route.HttpMethod, route.URL, prevMethod)
+		}
+		seen[key] = route.ApiMethodName
+	}
+}
+
+// checkPathParams fails generation if a path param has no scalar field in the params struct to go to
+func checkPathParams(apiTypeName string, apiMethodName string, urlTemplate string, paramsType *types.Named) {
+	names := pathParamNames(urlTemplate)
+	if len(names) == 0 {
+		return
+	}
+
+	fields := make(map[string]paramField)
+	for _, f := range parseParamFields(paramsType) {
+		fields[f.ParamName] = f
+	}
+	for _, name := range names {
+		f, exists := fields[name]
+		if !exists || f.Nested != nil || f.IsSlice {
+			log.Fatalf("%s.%s: path param {%s} has no scalar field in %s",
+				apiTypeName, apiMethodName, name, paramsType.Obj().Name())
+		}
+	}
+}
+
+func generateServeHttp(out io.Writer, apiTypeName string, routes []routeSpec) {
+	checkRoutes(apiTypeName, routes)
+
+	routerVar := "router" + apiTypeName
+	fmt.Fprint(out, `
+var `+routerVar+` = newRouter(`)
+	for idx, route := range routes {
+		fmt.Fprint(out, `
+	route{`+strconv.Quote(route.HttpMethod)+`, `+strconv.Quote(route.URL)+`, `+strconv.Itoa(idx)+`},`)
+	}
+	fmt.Fprint(out, `
+)
+
+func (h *`+apiTypeName+`) route(w http.ResponseWriter, r *http.Request) {
+	id, r, ok := `+routerVar+`.match(w, r)
+	if !ok {
+		return
+	}
+	switch id {`)
+	for idx, route := range routes {
+		fmt.Fprint(out, `
+	case `+strconv.Itoa(idx)+`:
+		h.handler`+route.ApiMethodName+`(w, r)`)
+	}
+	fmt.Fprint(out, `
+	}
+}
+`)
+	generateServeHttpWithMiddlewares(out, apiTypeName)
+}
+
+var (
+	routerPackages = []string{
+		"context",
+		"net/http",
+		"net/url",
+		"sort",
+		"strings",
+	}
+
+	routerSrc = `
+type route struct {
+	method string
+	path   string
+	id     int
+}
+
+// routeNode is a node of the path trie, one level per path segment
+type routeNode struct {
+	static    map[string]*routeNode
+	param     *routeNode
+	paramName string
+	// route ids by http method, "" serves the rest of methods
+	routes map[string]int
+}
+
+func newRouter(routes ...route) *routeNode {
+	root := &routeNode{}
+	for _, rt := range routes {
+		node := root
+		for _, segment := range strings.Split(rt.path[1:], "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				if node.param == nil {
+					node.param = &routeNode{paramName: segment[1 : len(segment)-1]}
+				}
+				node = node.param
+				continue
+			}
+			if node.static == nil {
+				node.static = make(map[string]*routeNode)
+			}
+			child, exists := node.static[segment]
+			if !exists {
+				child = &routeNode{}
+				node.static[segment] = child
+			}
+			node = child
+		}
+		if node.routes == nil {
+			node.routes = make(map[string]int)
+		}
+		node.routes[rt.method] = rt.id
+	}
+	return root
+}
+
+// lookup prefers static segments over params and backtracks if the static branch is a dead end
+func (n *routeNode) lookup(segments []string, pathParams url.Values) *routeNode {
+	if len(segments) == 0 {
+		if len(n.routes) == 0 {
+			return nil
+		}
+		return n
+	}
+	if child, exists := n.static[segments[0]]; exists {
+		if found := child.lookup(segments[1:], pathParams); found != nil {
+			return found
+		}
+	}
+	if n.param != nil && segments[0] != "" {
+		if found := n.param.lookup(segments[1:], pathParams); found != nil {
+			pathParams.Set(n.param.paramName, segments[0])
+			return found
+		}
+	}
+	return nil
+}
+
+// match finds the route of the request and puts path params into its context,
+// the error response is already written if the route is not found
+func (n *routeNode) match(w http.ResponseWriter, r *http.Request) (int, *http.Request, bool) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for idx, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			handleError(ApiError{http.StatusNotFound, fmt.Errorf("unknown method")}, w)
+			return 0, r, false
+		}
+		segments[idx] = unescaped
+	}
+
+	pathParams := url.Values{}
+	node := n.lookup(segments, pathParams)
+	if node == nil {
+		handleError(ApiError{http.StatusNotFound, fmt.Errorf("unknown method")}, w)
+		return 0, r, false
+	}
+
+	id, exists := node.routes[r.Method]
+	if !exists {
+		id, exists = node.routes[""]
+	}
+	if !exists {
+		allowed := make([]string, 0, len(node.routes))
+		for method := range node.routes {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		handleError(ApiError{http.StatusMethodNotAllowed, fmt.Errorf("bad method")}, w)
+		return 0, r, false
+	}
+
+	if len(pathParams) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsCtxKey{}, pathParams))
+	}
+	return id, r, true
+}
+
+type pathParamsCtxKey struct{}
+
+// PathParams returns values of {name} url segments matched by the router
+func PathParams(r *http.Request) url.Values {
+	pathParams, _ := r.Context().Value(pathParamsCtxKey{}).(url.Values)
+	return pathParams
+}
+`
+)
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"go/types"
+	"log"
+)
+
+const (
+	streamNDJSON = "ndjson"
+	streamSSE    = "sse"
+)
+
+const (
+	// <-chan T
+	streamKindChan = "chan"
+	// func(yield func(T) bool)
+	streamKindSeq = "seq"
+	// func(yield func(T, error) bool), an error ends the stream
+	streamKindSeq2 = "seq2"
+)
+
+// streamResult tells if a result type can be streamed and what its items are
+func streamResult(t types.Type) (kind string, elem types.Type) {
+	if ch, ok := t.Underlying().(*types.Chan); ok && ch.Dir() != types.SendOnly {
+		return streamKindChan, ch.Elem()
+	}
+
+	seq, ok := t.Underlying().(*types.Signature)
+	if !ok || seq.Params().Len() != 1 || seq.Results().Len() != 0 {
+		return "", nil
+	}
+	yield, ok := seq.Params().At(0).Type().Underlying().(*types.Signature)
+	if !ok || yield.Results().Len() != 1 || !types.Identical(yield.Results().At(0).Type(), types.Typ[types.Bool]) {
+		return "", nil
+	}
+	switch yield.Params().Len() {
+	case 1:
+		return streamKindSeq, yield.Params().At(0).Type()
+	case 2:
+		errorType := types.Universe.Lookup("error").Type()
+		if types.Identical(yield.Params().At(1).Type(), errorType) {
+			return streamKindSeq2, yield.Params().At(0).Type()
+		}
+	}
+	return "", nil
+}
+
+// checkStream fails generation if the stream annotation and the result type dont match
+func checkStream(method apiMethodSpec) {
+	kind, _ := streamResult(method.ResultType)
+	switch method.Config.Stream {
+	case "":
+		if kind != "" {
+			log.Fatalf("%s.%s: streaming result needs \"stream\": \"ndjson\" or \"sse\"", method.ApiTypeName, method.ApiMethodName)
+		}
+	case streamNDJSON, streamSSE:
+		if kind == "" {
+			log.Fatalf("%s.%s: stream result must be a channel or func(yield func(T) bool)", method.ApiTypeName, method.ApiMethodName)
+		}
+	default:
+		log.Fatalf("%s.%s: unknown stream format %s", method.ApiTypeName, method.ApiMethodName, method.Config.Stream)
+	}
+}
+
+// generateResultWriter returns code that writes the result of the api method to the response
+func generateResultWriter(pkg *apiPackage, method apiMethodSpec, imports map[string]bool) string {
+	if method.Config.Stream == "" {
+		return `
+	resp := finalResponse{
+		Error: "",
+		Response: result,
+	}
+	respText, _ := json.Marshal(resp)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(respText))
+`
+	}
+
+	kind, elem := streamResult(method.ResultType)
+	elemType := types.TypeString(elem, pkg.qualifier(imports))
+	code := `
+	stream := startStream(w, "` + method.Config.Stream + `")`
+	switch kind {
+	case streamKindChan:
+		code += `
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item, ok := <-result:
+			if !ok {
+				return
+			}
+			if stream.send(item) != nil {
+				return
+			}
+		}
+	}
+`
+	case streamKindSeq:
+		code += `
+	if result != nil {
+		result(func(item ` + elemType + `) bool {
+			return stream.send(item) == nil && ctx.Err() == nil
+		})
+	}
+`
+	case streamKindSeq2:
+		code += `
+	if result != nil {
+		result(func(item ` + elemType + `, err error) bool {
+			if err != nil {
+				stream.fail(err)
+				return false
+			}
+			return stream.send(item) == nil && ctx.Err() == nil
+		})
+	}
+`
+	}
+	return code
+}
+
+var (
+	streamPackages = []string{
+		"bytes",
+		"encoding/json",
+		"net/http",
+	}
+
+	streamSrc = `
+// streamWriter writes items of a streaming method as soon as they come,
+// every item is a finalResponse, a line of NDJSON or data of a Server-Sent Event
+type streamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+}
+
+func startStream(w http.ResponseWriter, format string) *streamWriter {
+	stream := &streamWriter{w: w, sse: format == "sse"}
+	stream.flusher, _ = w.(http.Flusher)
+	if stream.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	stream.flush()
+	return stream
+}
+
+func (s *streamWriter) send(item interface{}) error {
+	data, err := json.Marshal(finalResponse{Response: item})
+	if err != nil {
+		s.fail(err)
+		return err
+	}
+	return s.write("", data)
+}
+
+// fail ends the stream with an error, the status is already sent, so the error goes to the body
+func (s *streamWriter) fail(err error) {
+	data, _ := json.Marshal(finalResponse{Error: err.Error()})
+	s.write("error", data)
+}
+
+func (s *streamWriter) write(event string, data []byte) error {
+	var buf bytes.Buffer
+	if s.sse {
+		if event != "" {
+			buf.WriteString("event: " + event + "\n")
+		}
+		buf.WriteString("data: ")
+		buf.Write(data)
+		buf.WriteString("\n\n")
+	} else {
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+	_, err := s.w.Write(buf.Bytes())
+	s.flush()
+	return err
+}
+
+func (s *streamWriter) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+`
+)
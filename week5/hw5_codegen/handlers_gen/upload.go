@@ -0,0 +1,187 @@
+package main
+
+import (
+	"go/types"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxsize of an upload without the tag
+	defaultMaxUploadSize = 32 << 20
+	// room for the rest of a multipart form with uploads
+	maxFormSize = 1 << 20
+)
+
+// kinds of upload fields, readers are opened by the handler and closed after the method returns
+var uploadKinds = map[string]bool{
+	"io.Reader":             true,
+	"multipart.File":        true,
+	"*multipart.FileHeader": true,
+}
+
+func uploadKind(t types.Type) string {
+	if ptr, ok := t.(*types.Pointer); ok {
+		if isNamedType(ptr.Elem(), "mime/multipart", "FileHeader") {
+			return "*multipart.FileHeader"
+		}
+		return ""
+	}
+	switch {
+	case isNamedType(t, "io", "Reader"):
+		return "io.Reader"
+	case isNamedType(t, "mime/multipart", "File"):
+		return "multipart.File"
+	}
+	return ""
+}
+
+func isNamedType(t types.Type, pkgPath string, name string) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == pkgPath && named.Obj().Name() == name
+}
+
+func (f paramField) MaxSize() int64 {
+	maxSize, isSet := f.Tags["maxsize"]
+	if !isSet {
+		return defaultMaxUploadSize
+	}
+	size, _ := strconv.ParseInt(maxSize, 10, 64)
+	return size
+}
+
+// uploadFields returns upload fields of the params struct and its nested structs with go paths to them
+func uploadFields(named *types.Named, path string) (fields []paramField, paths []string) {
+	for _, f := range parseParamFields(named) {
+		if f.Nested != nil {
+			nestedFields, nestedPaths := uploadFields(f.Nested, path+f.FieldName+".")
+			fields = append(fields, nestedFields...)
+			paths = append(paths, nestedPaths...)
+		} else if uploadKinds[f.Kind] {
+			fields = append(fields, f)
+			paths = append(paths, path+f.FieldName)
+		}
+	}
+	return fields, paths
+}
+
+// checkUploads fails generation if uploads can not come with the http method
+func checkUploads(method apiMethodSpec) {
+	fields, _ := uploadFields(method.ParamsType, "")
+	if len(fields) == 0 {
+		return
+	}
+	switch method.Config.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		log.Fatalf("%s.%s: uploads need method POST, PUT or PATCH", method.ApiTypeName, method.ApiMethodName)
+	}
+}
+
+// generateBodyLimit limits the request body of methods with uploads by maxsize of their fields
+func generateBodyLimit(method apiMethodSpec) string {
+	fields, _ := uploadFields(method.ParamsType, "")
+	if len(fields) == 0 {
+		return ""
+	}
+	limit := int64(maxFormSize)
+	for _, f := range fields {
+		limit += f.MaxSize()
+	}
+	return `
+	// uploads are limited by their maxsize, the rest of the form by ` + strconv.Itoa(maxFormSize) + ` bytes
+	r.Body = http.MaxBytesReader(w, r.Body, ` + strconv.FormatInt(limit, 10) + `)
+`
+}
+
+// generateCloseUploads closes readers opened for upload fields when the handler is done
+func generateCloseUploads(method apiMethodSpec) string {
+	fields, paths := uploadFields(method.ParamsType, "deserializedParams.")
+	var readers []string
+	for idx, f := range fields {
+		if f.Kind != "*multipart.FileHeader" {
+			readers = append(readers, paths[idx])
+		}
+	}
+	if len(readers) == 0 {
+		return ""
+	}
+	return `
+	defer closeUploads(` + strings.Join(readers, ", ") + `)`
+}
+
+// generateUploadDeserializer returns code that takes the uploaded file of the field from files and checks it
+func generateUploadDeserializer(f paramField) string {
+	name := f.ParamName
+	maxSize := strconv.FormatInt(f.MaxSize(), 10)
+	_, isRequired := f.Tags["required"]
+
+	code := `	errs.add(func() error {
+		fileHeaders := files[prefix+` + strconv.Quote(name) + `]
+		if len(fileHeaders) == 0 {
+`
+	if isRequired {
+		code += `			return ` + fieldErrorCode(name+" must me not empty") + `
+`
+	} else {
+		code += `			return nil
+`
+	}
+	code += `		}
+		fileHeader := fileHeaders[0]
+		if fileHeader.Size > ` + maxSize + ` {
+			return ` + fieldErrorCode(name+" must be <= "+maxSize+" bytes") + `
+		}
+`
+	if f.Kind == "*multipart.FileHeader" {
+		code += `		model.` + f.FieldName + ` = fileHeader
+`
+	} else {
+		code += `		file, err := fileHeader.Open()
+		if err != nil {
+			return ` + fieldErrorCode(name+" cant be opened") + `
+		}
+		model.` + f.FieldName + ` = file
+`
+	}
+	code += `		return nil
+	}())
+`
+	return code
+}
+
+var (
+	uploadPackages = []string{
+		"errors",
+		"io",
+		"mime/multipart",
+	}
+
+	uploadSrc = `
+// formError tells a too large body from a broken one
+func formError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ApiError{http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large")}
+	}
+	return ApiError{http.StatusBadRequest, fmt.Errorf("cant parse form: %s", err)}
+}
+
+func requestFiles(r *http.Request) map[string][]*multipart.FileHeader {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	return r.MultipartForm.File
+}
+
+// closeUploads closes files opened for io.Reader and multipart.File params
+func closeUploads(uploads ...interface{}) {
+	for _, upload := range uploads {
+		if closer, ok := upload.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+`
+)
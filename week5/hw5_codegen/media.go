@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
+)
+
+// 6-я часть
+// потоковые ответы (NDJSON и Server-Sent Events) и загрузка файлов
+
+type MediaApi struct {
+}
+
+func NewMediaApi() *MediaApi {
+	return &MediaApi{}
+}
+
+type UploadParams struct {
+	Title     string                `apivalidator:"required"`
+	File      io.Reader             `apivalidator:"required,maxsize=1024"`
+	Thumbnail *multipart.FileHeader `apivalidator:"maxsize=256"`
+}
+
+type UploadResult struct {
+	Title     string `json:"title"`
+	Size      int64  `json:"size"`
+	Checksum  string `json:"checksum"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// apigen:api {"url": "/media/upload", "method": "POST"}
+func (srv *MediaApi) Upload(ctx context.Context, in UploadParams) (*UploadResult, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, in.File)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UploadResult{
+		Title:    in.Title,
+		Size:     size,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}
+	if in.Thumbnail != nil {
+		result.Thumbnail = in.Thumbnail.Filename
+	}
+	return result, nil
+}
+
+type ProgressParams struct {
+	Steps int `apivalidator:"default=3,min=1,max=10"`
+}
+
+type Progress struct {
+	Step  int `json:"step"`
+	Total int `json:"total"`
+}
+
+// apigen:api {"url": "/media/progress", "method": "GET", "stream": "ndjson"}
+func (srv *MediaApi) Progress(ctx context.Context, in ProgressParams) (<-chan Progress, error) {
+	ch := make(chan Progress)
+	go func() {
+		defer close(ch)
+		for step := 1; step <= in.Steps; step++ {
+			select {
+			case ch <- Progress{Step: step, Total: in.Steps}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+type TagsParams struct {
+	Prefix string `apivalidator:"regexp=^[a-z]*$"`
+}
+
+var mediaTags = []string{"go", "golang", "gopher", "rust"}
+
+// apigen:api {"url": "/media/tags", "method": "GET", "stream": "ndjson"}
+func (srv *MediaApi) Tags(ctx context.Context, in TagsParams) (func(yield func(string) bool), error) {
+	return func(yield func(string) bool) {
+		for _, tag := range mediaTags {
+			if strings.HasPrefix(tag, in.Prefix) && !yield(tag) {
+				return
+			}
+		}
+	}, nil
+}
+
+type EventsParams struct {
+	Count int `apivalidator:"default=3,min=0,max=100"`
+	// номер события, на котором поток оборвётся ошибкой, 0 - без ошибки
+	FailAt int `apivalidator:"paramname=fail_at,min=0"`
+}
+
+type Event struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+// apigen:api {"url": "/media/events", "method": "GET", "stream": "sse"}
+func (srv *MediaApi) Events(ctx context.Context, in EventsParams) (func(yield func(Event, error) bool), error) {
+	return func(yield func(Event, error) bool) {
+		for id := 1; id <= in.Count; id++ {
+			if id == in.FailAt {
+				yield(Event{}, fmt.Errorf("event %d is broken", id))
+				return
+			}
+			if !yield(Event{ID: id, Text: fmt.Sprintf("event %d", id)}, nil) {
+				return
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"./apiclient"
+)
+
+func multipartRequest(t *testing.T, url string, fields map[string]string, files map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name, val := range fields {
+		mw.WriteField(name, val)
+	}
+	for name, content := range files {
+		part, err := mw.CreateFormFile(name, name+".bin")
+		if err != nil {
+			t.Fatalf("cant create form file: %v", err)
+		}
+		part.Write([]byte(content))
+	}
+	mw.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestMediaUpload(t *testing.T) {
+	ts := httptest.NewServer(NewMediaApi())
+	defer ts.Close()
+
+	checksum := sha256.Sum256([]byte("hello"))
+	cases := []struct {
+		Fields map[string]string
+		Files  map[string]string
+		Status int
+		Body   string
+	}{
+		{
+			Fields: map[string]string{"title": "greeting"},
+			Files:  map[string]string{"file": "hello", "thumbnail": "tiny"},
+			Status: http.StatusOK,
+			Body: `{"error":"","response":{"title":"greeting","size":5,"checksum":"` +
+				hex.EncodeToString(checksum[:]) + `","thumbnail":"thumbnail.bin"}}`,
+		},
+		{
+			Fields: map[string]string{"title": "greeting"},
+			Status: http.StatusBadRequest,
+			Body:   `{"error":"file must me not empty"}`,
+		},
+		{ // every file has its own limit
+			Fields: map[string]string{"title": "greeting"},
+			Files:  map[string]string{"file": "hello", "thumbnail": strings.Repeat("x", 257)},
+			Status: http.StatusBadRequest,
+			Body:   `{"error":"thumbnail must be \u003c= 256 bytes"}`,
+		},
+		{ // and the whole body is limited by them
+			Fields: map[string]string{"title": "greeting"},
+			Files:  map[string]string{"file": strings.Repeat("x", 2<<20)},
+			Status: http.StatusRequestEntityTooLarge,
+			Body:   `{"error":"request body too large"}`,
+		},
+	}
+
+	for idx, item := range cases {
+		req := multipartRequest(t, ts.URL+"/media/upload", item.Fields, item.Files)
+		status, body := doRequest(t, req)
+		if status != item.Status || body != item.Body {
+			t.Errorf("[%d] expected %v %s, got %v %s", idx, item.Status, item.Body, status, body)
+		}
+	}
+
+	// files come only with multipart forms
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/media/upload", strings.NewReader("title=greeting&file=hello"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	status, body := doRequest(t, req)
+	if status != http.StatusBadRequest || body != `{"error":"file must me not empty"}` {
+		t.Errorf("unexpected response %v %s", status, body)
+	}
+}
+
+func TestMediaStreams(t *testing.T) {
+	ts := httptest.NewServer(NewMediaApi())
+	defer ts.Close()
+
+	cases := []struct {
+		Path        string
+		Status      int
+		ContentType string
+		Body        string
+	}{
+		{
+			Path:        "/media/progress",
+			Status:      http.StatusOK,
+			ContentType: "application/x-ndjson",
+			Body: `{"error":"","response":{"step":1,"total":3}}` + "\n" +
+				`{"error":"","response":{"step":2,"total":3}}` + "\n" +
+				`{"error":"","response":{"step":3,"total":3}}` + "\n",
+		},
+		{ // params are checked before the stream starts
+			Path:        "/media/progress?steps=20",
+			Status:      http.StatusBadRequest,
+			ContentType: "",
+			Body:        `{"error":"steps must be \u003c= 10"}`,
+		},
+		{
+			Path:        "/media/tags?prefix=gol",
+			Status:      http.StatusOK,
+			ContentType: "application/x-ndjson",
+			Body:        `{"error":"","response":"golang"}` + "\n",
+		},
+		{
+			Path:        "/media/events?count=2",
+			Status:      http.StatusOK,
+			ContentType: "text/event-stream",
+			Body: "data: " + `{"error":"","response":{"id":1,"text":"event 1"}}` + "\n\n" +
+				"data: " + `{"error":"","response":{"id":2,"text":"event 2"}}` + "\n\n",
+		},
+		{ // an error after the stream started goes to the body
+			Path:        "/media/events?count=5&fail_at=2",
+			Status:      http.StatusOK,
+			ContentType: "text/event-stream",
+			Body: "data: " + `{"error":"","response":{"id":1,"text":"event 1"}}` + "\n\n" +
+				"event: error\ndata: " + `{"error":"event 2 is broken"}` + "\n\n",
+		},
+	}
+
+	for idx, item := range cases {
+		resp, err := client.Get(ts.URL + item.Path)
+		if err != nil {
+			t.Fatalf("[%d] request error: %v", idx, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		contentType := resp.Header.Get("Content-Type")
+		if item.ContentType == "" {
+			contentType = ""
+		}
+		if resp.StatusCode != item.Status || contentType != item.ContentType || string(body) != item.Body {
+			t.Errorf("[%d] expected %v %s %q, got %v %s %q", idx,
+				item.Status, item.ContentType, item.Body, resp.StatusCode, contentType, body)
+		}
+	}
+}
+
+func TestGeneratedClientStreamsAndUploads(t *testing.T) {
+	ts := httptest.NewServer(NewMediaApi())
+	defer ts.Close()
+
+	c := apiclient.NewMediaApiClient(ts.URL, "")
+	ctx := context.Background()
+
+	uploaded, err := c.Upload(ctx, apiclient.UploadParams{Title: "greeting", File: strings.NewReader("hello")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uploaded.Size != 5 || uploaded.Title != "greeting" || uploaded.Thumbnail != "" {
+		t.Errorf("unexpected upload result: %#v", uploaded)
+	}
+
+	progress, err := c.Progress(ctx, apiclient.ProgressParams{Steps: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer progress.Close()
+	var steps []apiclient.Progress
+	for {
+		var step apiclient.Progress
+		err = progress.Next(&step)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		steps = append(steps, step)
+	}
+	expectedSteps := []apiclient.Progress{{Step: 1, Total: 2}, {Step: 2, Total: 2}}
+	if !reflect.DeepEqual(steps, expectedSteps) {
+		t.Errorf("expected %#v, got %#v", expectedSteps, steps)
+	}
+
+	events, err := c.Events(ctx, apiclient.EventsParams{Count: 3, FailAt: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer events.Close()
+	var event apiclient.Event
+	if err = events.Next(&event); err != nil || event.ID != 1 {
+		t.Errorf("unexpected first event %#v: %v", event, err)
+	}
+	err = events.Next(&event)
+	if apiErr, ok := err.(apiclient.ApiError); !ok || apiErr.Error() != "event 2 is broken" {
+		t.Errorf("expected the stream error, got %#v", err)
+	}
+
+	_, err = c.Progress(ctx, apiclient.ProgressParams{Steps: 0})
+	if apiErr, ok := err.(apiclient.ApiError); !ok || apiErr.HTTPStatus != http.StatusBadRequest {
+		t.Errorf("expected 400 ApiError, got %#v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMiddlewaresOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" in")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" out")
+			})
+		}
+	}
+
+	api := NewAdminApi()
+	api.Use(trace("first"), trace("second"))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	// unknown urls go through middlewares too
+	for _, path := range []string{ApiAdminWhoami, "/admin/unknown"} {
+		calls = nil
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		doRequest(t, req)
+
+		expected := []string{"first in", "second in", "second out", "first out"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Errorf("[%s] expected calls %v, got %v", path, expected, calls)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	const limit = 2
+	mu := &sync.Mutex{}
+	requests := map[string]int{}
+	rateLimit := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("Authorization")
+			mu.Lock()
+			requests[token]++
+			exceeded := requests[token] > limit
+			mu.Unlock()
+			if exceeded {
+				handleError(ApiError{http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded")}, w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	api := NewAdminApi()
+	api.Use(rateLimit)
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	statuses := []int{}
+	for i := 0; i < limit+1; i++ {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+ApiAdminWhoami, nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+		status, _ := doRequest(t, req)
+		statuses = append(statuses, status)
+	}
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected statuses %v, got %v", expected, statuses)
+	}
+
+	// another token has its own limit
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+ApiAdminWhoami, nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	if status, body := doRequest(t, req); status != http.StatusOK {
+		t.Errorf("expected http status 200, got %v: %s", status, body)
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	api := NewAdminApi()
+	api.Hook("Whoami", ApiHooks{
+		BeforeValidate: func(ctx context.Context, r *http.Request) error {
+			panic("hook is broken")
+		},
+	})
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+ApiAdminWhoami, nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+	status, body := doRequest(t, req)
+	if status != http.StatusInternalServerError || body != `{"error":"internal error"}` {
+		t.Errorf("unexpected response %v: %s", status, body)
+	}
+}
+
+func TestApiHooks(t *testing.T) {
+	var principalID string
+	var afterCallParams, afterCallResult interface{}
+	var onErrorErrs []string
+
+	api := NewAdminApi()
+	api.Hook("Ban", ApiHooks{
+		BeforeValidate: func(ctx context.Context, r *http.Request) error {
+			// hooks get the context with the authenticated principal
+			p, _ := PrincipalFromContext(ctx)
+			principalID = p.ID
+			if r.URL.Query().Get("dry_run") != "" {
+				return ApiError{http.StatusBadRequest, fmt.Errorf("dry run is not supported")}
+			}
+			return nil
+		},
+		AfterCall: func(ctx context.Context, params interface{}, result interface{}, err error) error {
+			afterCallParams, afterCallResult = params, result
+			return err
+		},
+	})
+	api.Hook("Unban", ApiHooks{
+		OnError: func(ctx context.Context, err error) error {
+			onErrorErrs = append(onErrorErrs, err.Error())
+			if err.Error() == "user not banned" {
+				return ApiError{http.StatusConflict, fmt.Errorf("nothing to unban")}
+			}
+			return nil
+		},
+	})
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	ban := func(query string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+ApiAdminBan+query, strings.NewReader("login=bad_user"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer admin-token")
+		return doRequest(t, req)
+	}
+
+	status, body := ban("?dry_run=1")
+	if status != http.StatusBadRequest || body != `{"error":"dry run is not supported"}` {
+		t.Errorf("unexpected response %v: %s", status, body)
+	}
+	if principalID != "rvasily" {
+		t.Errorf("expected principal rvasily in BeforeValidate, got %q", principalID)
+	}
+	if afterCallParams != nil {
+		t.Errorf("AfterCall must not run when BeforeValidate fails")
+	}
+
+	status, body = ban("")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected response %v: %s", status, body)
+	}
+	if !reflect.DeepEqual(afterCallParams, BanParams{Login: "bad_user"}) {
+		t.Errorf("unexpected AfterCall params: %#v", afterCallParams)
+	}
+	if result, ok := afterCallResult.(*BanResult); !ok || result.BannedBy != "rvasily" {
+		t.Errorf("unexpected AfterCall result: %#v", afterCallResult)
+	}
+
+	unban := func(token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/admin/users/good_user", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return doRequest(t, req)
+	}
+
+	status, body = unban("admin-token")
+	if status != http.StatusConflict || body != `{"error":"nothing to unban"}` {
+		t.Errorf("unexpected response %v: %s", status, body)
+	}
+	// OnError returning nil keeps the error, authentication errors go through it too
+	status, body = unban("user-token")
+	if status != http.StatusForbidden || body != `{"error":"role admin required"}` {
+		t.Errorf("unexpected response %v: %s", status, body)
+	}
+	expected := []string{"user not banned", "role admin required"}
+	if !reflect.DeepEqual(onErrorErrs, expected) {
+		t.Errorf("expected OnError calls %v, got %v", expected, onErrorErrs)
+	}
+}
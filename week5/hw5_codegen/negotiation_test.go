@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJsonBody(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+
+	cases := []struct {
+		ContentType string
+		Body        string
+		Status      int
+		Result      string // expected prefix of the error text
+	}{
+		{ // numbers come as json numbers
+			ContentType: "application/json",
+			Body:        `{"login": "json_moderator", "age": 32, "status": "moderator", "full_name": "Json Ivanov"}`,
+			Status:      http.StatusOK,
+			Result:      "",
+		},
+		{ // json params go through the same validation
+			ContentType: "application/json; charset=utf-8",
+			Body:        `{"login": "json_moderator2", "age": 256}`,
+			Status:      http.StatusBadRequest,
+			Result:      "age must be <= 128",
+		},
+		{
+			ContentType: "application/json",
+			Body:        `{"login": "json_moderator2", "age": "ten"}`,
+			Status:      http.StatusBadRequest,
+			Result:      "age must be int",
+		},
+		{
+			ContentType: "application/json",
+			Body:        `{"login": null}`,
+			Status:      http.StatusBadRequest,
+			Result:      "login must me not empty",
+		},
+		{
+			ContentType: "application/json",
+			Body:        `[1, 2, 3]`,
+			Status:      http.StatusBadRequest,
+			Result:      "cant unpack json body",
+		},
+		{
+			ContentType: "text/plain",
+			Body:        `login=json_moderator3`,
+			Status:      http.StatusUnsupportedMediaType,
+			Result:      "unsupported media type text/plain",
+		},
+	}
+
+	for idx, item := range cases {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+ApiUserCreate, strings.NewReader(item.Body))
+		req.Header.Add("Content-Type", item.ContentType)
+		req.Header.Add("X-Auth", "100500")
+
+		status, body := doRequest(t, req)
+		if status != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v", idx, item.Status, status)
+		}
+		resp := finalResponse{}
+		err := json.Unmarshal([]byte(body), &resp)
+		if err != nil {
+			t.Errorf("[%d] cant unpack json: %v", idx, err)
+			continue
+		}
+		if !strings.HasPrefix(resp.Error, item.Result) || (item.Result == "") != (resp.Error == "") {
+			t.Errorf("[%d] results not match\nGot: %s\nExpected: %s", idx, resp.Error, item.Result)
+		}
+	}
+}
+
+func doRequest(t *testing.T, req *http.Request) (int, string) {
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("cant read body: %v", err)
+	}
+	return resp.StatusCode, string(body)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"./apiclient"
+)
+
+func TestRouterPathParams(t *testing.T) {
+	ts := httptest.NewServer(NewAdminApi())
+	defer ts.Close()
+
+	type RouteCase struct {
+		Method string
+		Path   string
+		Body   string
+		Status int
+		Allow  string
+		Result CR
+	}
+	cases := []RouteCase{
+		{
+			Method: http.MethodGet,
+			Path:   "/admin/users/bad_user",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "bad_user", "banned": false}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/admin/ban",
+			Body:   "login=bad_user",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "bad_user", "banned_by": "rvasily"}},
+		},
+		{ // path param wins over the query param
+			Method: http.MethodGet,
+			Path:   "/admin/users/bad_user?login=good_user",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "bad_user", "banned": true, "banned_by": "rvasily"}},
+		},
+		{ // segments are unescaped
+			Method: http.MethodGet,
+			Path:   "/admin/users/bad%2Fuser",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "bad/user", "banned": false}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/admin/users/bad_user",
+			Status: http.StatusMethodNotAllowed,
+			Allow:  "DELETE, GET",
+			Result: CR{"error": "bad method"},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/admin/ban",
+			Status: http.StatusMethodNotAllowed,
+			Allow:  "POST",
+			Result: CR{"error": "bad method"},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/admin/users/",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown method"},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/admin/users/bad_user/profile",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown method"},
+		},
+		{
+			Method: http.MethodDelete,
+			Path:   "/admin/users/bad_user",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "bad_user", "banned": false}},
+		},
+		{
+			Method: http.MethodDelete,
+			Path:   "/admin/users/bad_user",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "user not banned"},
+		},
+	}
+
+	for idx, item := range cases {
+		var req *http.Request
+		if item.Body != "" {
+			req, _ = http.NewRequest(item.Method, ts.URL+item.Path, strings.NewReader(item.Body))
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(item.Method, ts.URL+item.Path, nil)
+		}
+		req.Header.Set("Authorization", "Bearer admin-token")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[%d] request error: %v", idx, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v: %s", idx, item.Status, resp.StatusCode, body)
+			continue
+		}
+		if allow := resp.Header.Get("Allow"); allow != item.Allow {
+			t.Errorf("[%d] expected Allow %q, got %q", idx, item.Allow, allow)
+		}
+
+		var result, expected interface{}
+		json.Unmarshal(body, &result)
+		data, _ := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%d] results not match\nGot: %#v\nExpected: %#v", idx, result, expected)
+		}
+	}
+}
+
+func TestGeneratedClientPathParams(t *testing.T) {
+	ts := httptest.NewServer(NewAdminApi())
+	defer ts.Close()
+
+	c := apiclient.NewAdminApiClient(ts.URL, "")
+	c.Authorize = func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer admin-token")
+		return nil
+	}
+	ctx := context.Background()
+
+	_, err := c.Ban(ctx, apiclient.BanParams{Login: "bad/user"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status, err := c.BanStatus(ctx, apiclient.UserParams{Login: "bad/user"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.Banned || status.Login != "bad/user" {
+		t.Errorf("unexpected ban status: %#v", status)
+	}
+
+	_, err = c.Unban(ctx, apiclient.UserParams{Login: "bad/user"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = c.Unban(ctx, apiclient.UserParams{Login: "bad/user"})
+	apiErr, ok := err.(apiclient.ApiError)
+	if !ok || apiErr.HTTPStatus != http.StatusNotFound {
+		t.Errorf("expected 404 ApiError, got %#v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ApiUserSearch = "/user/search"
+
+type searchResponse struct {
+	Error    string        `json:"error"`
+	Response *SearchResult `json:"response"`
+}
+
+func TestSearchApiValidation(t *testing.T) {
+	ts := httptest.NewServer(NewSearchApi())
+	defer ts.Close()
+
+	from := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)
+	expected := SearchParams{
+		Query:    "rvasily",
+		Email:    "rvasily@example.com",
+		Phone:    "+79001234567",
+		Country:  "RU",
+		Rating:   4.5,
+		Verified: true,
+		Statuses: []string{"user", "admin"},
+		IDs:      []uint64{1, 42},
+		Period:   PeriodParams{From: from, To: to},
+	}
+
+	cases := []struct {
+		Method      string
+		ContentType string
+		Query       string
+		Body        string
+		Status      int
+		Error       string
+	}{
+		{ // all kinds of fields from the query
+			Method: http.MethodGet,
+			Query:  "query=rvasily&email=rvasily@example.com&phone=%2B79001234567&rating=4.5&status=user&status=admin&id=1&id=42&period.from=2018-01-01T10:00:00Z&period.to=2018-02-01",
+			Status: http.StatusOK,
+		},
+		{ // the same from json, arrays and nested objects included
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Body:        `{"query": "rvasily", "email": "rvasily@example.com", "phone": "+79001234567", "rating": 4.5, "status": ["user", "admin"], "id": [1, 42], "period": {"from": "2018-01-01T10:00:00Z", "to": "2018-02-01"}}`,
+			Status:      http.StatusOK,
+		},
+		{ // every broken field is reported at once
+			Method: http.MethodGet,
+			Query:  "query=Rvasily&email=rvasily&phone=123&country=RUS&rating=6&verified=maybe&status=root&id=1001&period.to=01.02.2018",
+			Status: http.StatusBadRequest,
+			Error: strings.Join([]string{
+				"query must match ^[a-z_.]*$",
+				"email must be email",
+				"phone must be a phone number in international format",
+				"country len must be 2",
+				"rating must be <= 5",
+				"verified must be bool",
+				"status must be one of [user, moderator, admin]",
+				"id must be <= 1000",
+				"period.from must me not empty",
+				"period.to must be time in format 2006-01-02",
+			}, ", "),
+		},
+		{
+			Method: http.MethodGet,
+			Query:  "rating=high&id=-1&period.from=2018-01-01",
+			Status: http.StatusBadRequest,
+			Error:  "rating must be float64, id must be uint64, period.from must be time in format 2006-01-02T15:04:05Z07:00",
+		},
+	}
+
+	for idx, item := range cases {
+		var req *http.Request
+		if item.Method == http.MethodGet {
+			req, _ = http.NewRequest(item.Method, ts.URL+ApiUserSearch+"?"+item.Query, nil)
+		} else {
+			req, _ = http.NewRequest(item.Method, ts.URL+ApiUserSearch, strings.NewReader(item.Body))
+			req.Header.Add("Content-Type", item.ContentType)
+		}
+
+		status, body := doRequest(t, req)
+		if status != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v: %s", idx, item.Status, status, body)
+			continue
+		}
+
+		resp := searchResponse{}
+		err := json.Unmarshal([]byte(body), &resp)
+		if err != nil {
+			t.Errorf("[%d] cant unpack json: %v", idx, err)
+			continue
+		}
+		if resp.Error != item.Error {
+			t.Errorf("[%d] errors not match\nGot: %s\nExpected: %s", idx, resp.Error, item.Error)
+			continue
+		}
+		if item.Status == http.StatusOK && !reflect.DeepEqual(resp.Response.Query, expected) {
+			t.Errorf("[%d] results not match\nGot: %#v\nExpected: %#v", idx, resp.Response.Query, expected)
+		}
+	}
+}
+
+func TestUnknownApiValidator(t *testing.T) {
+	phoneValidator := apiValidators["phone"]
+	delete(apiValidators, "phone")
+	defer RegisterApiValidator("phone", phoneValidator)
+
+	req := httptest.NewRequest(http.MethodGet, ApiUserSearch+"?"+url.Values{"phone": {"+79001234567"}}.Encode(), nil)
+	_, err := deserializeSearchParams(req)
+	if err == nil || !strings.HasPrefix(err.Error(), "phone has unknown validator phone") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// changesPath is GET /_changes?since=$id, the feed of the audit log
+	changesPath = "_changes"
+	paramSince  = "since"
+
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+
+	// sseHeartbeat keeps idle event streams from being closed by proxies
+	sseHeartbeat = 15 * time.Second
+)
+
+// auditEntry is a change of a record, Before is null for inserts and After for deletes,
+// images have the columns the view of the write returns, hidden and write-only ones are left out
+type auditEntry struct {
+	ID        int64       `json:"id"`
+	Time      time.Time   `json:"time"`
+	Table     string      `json:"table"`
+	Operation string      `json:"operation"`
+	Key       interface{} `json:"key"`
+	// Principal is the id of the principal, empty for anonymous requests and explorers without a policy
+	Principal string   `json:"principal,omitempty"`
+	Before    response `json:"before"`
+	After     response `json:"after"`
+}
+
+// AuditLog is an append-only file of changes made through the explorer, one json entry per line,
+// ids of entries grow by one and are what ?since= of the change feed takes
+type AuditLog struct {
+	path string
+
+	mu     sync.Mutex
+	file   *os.File
+	lastID int64
+	// size is the length of entries of committed transactions, readers dont go past it
+	size int64
+	// index has offsets of entries in the file by their ids, so readers start at the first entry they need
+	index []auditOffset
+	// appended is closed and replaced on every append, event streams wait on it
+	appended chan struct{}
+}
+
+type auditOffset struct {
+	ID     int64
+	Offset int64
+}
+
+// OpenAuditLog opens the log or creates it, ids continue from the last entry of the file.
+// A line cut off by a crash in the middle of a write is trimmed, its transaction was not committed
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLog{
+		path:     path,
+		file:     file,
+		appended: make(chan struct{}),
+	}
+	if err := l.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// load indexes entries of the file
+func (l *AuditLog) load() error {
+	reader := bufio.NewReader(l.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return l.file.Truncate(l.size)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("cant read audit log %s: %s", l.path, err)
+		}
+		l.index = append(l.index, auditOffset{entry.ID, l.size})
+		l.lastID = entry.ID
+		l.size += int64(len(line))
+	}
+}
+
+func (l *AuditLog) Close() error {
+	return l.file.Close()
+}
+
+// WithAuditLog logs every write of the explorer and serves the log as GET /_changes to admin roles of the policy
+func WithAuditLog(l *AuditLog) Option {
+	return func(explorer *DbExplorer) {
+		explorer.audit = l
+	}
+}
+
+// commit writes entries of the transaction together, gives them ids and syncs them before the transaction is committed,
+// so a committed change is never missing in the log, they are cut off again if the commit fails.
+// Audited commits are serialized by it, readers see entries only once their transaction is committed
+func (l *AuditLog) commit(tx *sql.Tx, entries []auditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	id := l.lastID
+	index := make([]auditOffset, 0, len(entries))
+	for _, entry := range entries {
+		id++
+		entry.ID = id
+		index = append(index, auditOffset{id, l.size + int64(buf.Len())})
+		if err := encoder.Encode(entry); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err := l.file.Write(buf.Bytes())
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		// entries of a transaction that is not committed would break the next ones
+		l.file.Truncate(l.size)
+		return err
+	}
+	l.lastID = id
+	l.size += int64(buf.Len())
+	l.index = append(l.index, index...)
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+// read returns up to limit entries after the since one
+func (l *AuditLog) read(since int64, limit int) ([]auditEntry, error) {
+	entries := []auditEntry{}
+	err := l.scan(since, func(entry auditEntry) bool {
+		entries = append(entries, entry)
+		return len(entries) < limit
+	})
+	return entries, err
+}
+
+// scan calls fn with entries after the since one until it returns false, reading starts at the first of them
+func (l *AuditLog) scan(since int64, fn func(entry auditEntry) bool) error {
+	l.mu.Lock()
+	size := l.size
+	start := size
+	if idx := sort.Search(len(l.index), func(idx int) bool { return l.index[idx].ID > since }); idx < len(l.index) {
+		start = l.index[idx].Offset
+	}
+	l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(io.LimitReader(file, size-start))
+	decoder.UseNumber()
+	for {
+		var entry auditEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cant read audit log %s: %s", l.path, err)
+		}
+		if entry.ID > since && !fn(entry) {
+			return nil
+		}
+	}
+}
+
+func (l *AuditLog) waitAppend() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appended
+}
+
+// writeTx is the transaction of writes, audited operations add their changes to it,
+// they are logged together with its commit
+type writeTx struct {
+	*sql.Tx
+	principal *Principal
+	changes   []auditEntry
+}
+
+func (db DbExplorer) begin(ctx context.Context) (*writeTx, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &writeTx{Tx: tx, principal: principalFromContext(ctx)}, nil
+}
+
+// commit commits the transaction, with its changes written to the audit log first, it is not committed if they cant be
+func (db DbExplorer) commit(tx *writeTx) error {
+	if db.audit == nil || len(tx.changes) == 0 {
+		return tx.Commit()
+	}
+	if err := db.audit.commit(tx.Tx, tx.changes); err != nil {
+		return fmt.Errorf("cant commit audited changes: %s", err)
+	}
+	return nil
+}
+
+// runWrite runs a single write, audited ones in a transaction, so images are the ones of the write
+func (db DbExplorer) runWrite(ctx context.Context, op operation) (interface{}, error) {
+	if db.audit == nil {
+		return op(db.db)
+	}
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := op(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := db.commit(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// audited adds images of the record before and after the write to the transaction it runs in,
+// the key of a created record is the one the write returns, records the write does not find are not logged
+func (db DbExplorer) audited(t *table, op string, id interface{}, write operation) operation {
+	if db.audit == nil {
+		return write
+	}
+	return func(q querier) (interface{}, error) {
+		tx, isWriteTx := q.(*writeTx)
+		if !isWriteTx {
+			return write(q)
+		}
+		var before response
+		if id != nil {
+			var err error
+			before, err = db.selectImage(q, t, id)
+			if err != nil {
+				return nil, err
+			}
+			if before == nil {
+				return write(q)
+			}
+		}
+		result, err := write(q)
+		if err != nil {
+			return nil, err
+		}
+		key := id
+		if op == opCreate && t.PrimaryKey != nil {
+			key = result.(response)[t.PrimaryKey.Name]
+			// keys of bodies are json numbers or strings
+			if value, err := t.PrimaryKey.value(key); err == nil {
+				key = value
+			}
+		}
+		var after response
+		if op != opDelete && key != nil {
+			after, err = db.selectImage(q, t, key)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entry := auditEntry{
+			Time:      time.Now().UTC(),
+			Table:     t.Name,
+			Operation: op,
+			Key:       key,
+			Before:    before,
+			After:     after,
+		}
+		if tx.principal != nil {
+			entry.Principal = tx.principal.ID
+		}
+		tx.changes = append(tx.changes, entry)
+		return result, nil
+	}
+}
+
+// selectImage returns the record as the view returns it, nil if there is no record,
+// the change feed never has values that are not readable, like passwords
+func (db DbExplorer) selectImage(q querier, t *table, id interface{}) (response, error) {
+	columns := t.readColumns()
+	args := &queryArgs{dialect: db.dialect}
+	query := "SELECT " + db.selectColumns(columns) + " FROM " + db.dialect.quoteIdent(t.Name) + db.recordWhere(t, id, args)
+	records, err := db.queryRecords(q, columns, query, args.args...)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// changes returns entries of the audit log after ?since=, or streams them as server-sent events
+// when the request accepts text/event-stream, Last-Event-ID of reconnects is taken as since
+func (db DbExplorer) changes(r *http.Request) (interface{}, error) {
+	if db.audit == nil {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("audit log is off")}
+	}
+	query := r.URL.Query()
+	rawSince := query.Get(paramSince)
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		rawSince = lastEventID
+	}
+	var since int64
+	if rawSince != "" {
+		var err error
+		since, err = strconv.ParseInt(rawSince, 10, 64)
+		if err != nil {
+			return nil, badParam("since must be an id of a change")
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return db.streamChanges(r.Context(), since), nil
+	}
+	limit := queryInt(query, paramLimit, defaultChangesLimit)
+	if limit == 0 || limit > maxChangesLimit {
+		limit = maxChangesLimit
+	}
+	entries, err := db.audit.read(since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return response{"changes": entries}, nil
+}
+
+// streamChanges sends entries after since and then new ones as they are appended, until the client goes away
+func (db DbExplorer) streamChanges(ctx context.Context, since int64) stream {
+	return func(w http.ResponseWriter) error {
+		flusher, canFlush := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			// taken before the read, so entries appended during it are not missed
+			appended := db.audit.waitAppend()
+			entries, err := db.audit.read(since, maxChangesLimit)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				data, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", entry.ID, data); err != nil {
+					return err
+				}
+				since = entry.ID
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+			if len(entries) == maxChangesLimit {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-appended:
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// maxBatchSize limits items of bulk inserts and batches, they run in one transaction
+const maxBatchSize = 1000
+
+// batch operations of POST /$table
+const (
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// itemError is the error of an item of a batch, Field is empty if the item failed not because of a field
+type itemError struct {
+	Index int    `json:"index"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// batchError is answered with errors of all failed items, nothing of the batch is written
+type batchError struct {
+	Status int
+	Errors []itemError
+}
+
+func (e batchError) Error() string {
+	return "batch failed"
+}
+
+func newItemError(idx int, err error) itemError {
+	itemErr := itemError{Index: idx, Error: err.Error()}
+	if httpErr, ok := err.(httpError); ok {
+		err = httpErr.Err
+	}
+	if fieldErr, ok := err.(fieldError); ok {
+		itemErr.Field = fieldErr.Field
+	}
+	return itemErr
+}
+
+// bulkCreate inserts all records of PUT /$table with an array or none of them
+func (db DbExplorer) bulkCreate(ctx context.Context, t *table, items []interface{}) (interface{}, error) {
+	if err := checkBatchSize(items); err != nil {
+		return nil, err
+	}
+	ops := make([]operation, len(items))
+	var errs []itemError
+	for idx, item := range items {
+		record, isObject := item.(map[string]interface{})
+		if !isObject {
+			errs = append(errs, itemError{Index: idx, Error: "record must be an object"})
+			continue
+		}
+		op, err := db.prepareCreate(t, record)
+		if _, denied := err.(deniedError); denied {
+			return nil, err
+		}
+		if err != nil {
+			errs = append(errs, newItemError(idx, err))
+			continue
+		}
+		ops[idx] = op
+	}
+	if len(errs) > 0 {
+		return nil, batchError{http.StatusBadRequest, errs}
+	}
+	return db.runBatch(ctx, ops)
+}
+
+// batch runs updates and deletes of POST /$table in one transaction, items have the primary key under its name,
+// for a table keyed by id: [{"op": "update", "id": 1, "record": {...}}, {"op": "delete", "id": 2, "if_match": "\"etag\""}]
+func (db DbExplorer) batch(t *table, r *http.Request) (interface{}, error) {
+	if t.PrimaryKey == nil {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("table has no primary key")}
+	}
+	body, err := decodeBody(r)
+	if err != nil {
+		return nil, err
+	}
+	items, isArray := body.([]interface{})
+	if !isArray {
+		return nil, httpError{http.StatusBadRequest, fmt.Errorf("batch must be an array")}
+	}
+	if err := checkBatchSize(items); err != nil {
+		return nil, err
+	}
+
+	ops := make([]operation, len(items))
+	var errs []itemError
+	for idx, item := range items {
+		op, err := db.prepareBatchItem(r.Context(), t, item)
+		if _, denied := err.(deniedError); denied {
+			return nil, err
+		}
+		if err != nil {
+			errs = append(errs, newItemError(idx, err))
+			continue
+		}
+		ops[idx] = op
+	}
+	if len(errs) > 0 {
+		return nil, batchError{http.StatusBadRequest, errs}
+	}
+	return db.runBatch(r.Context(), ops)
+}
+
+func (db DbExplorer) prepareBatchItem(ctx context.Context, t *table, item interface{}) (operation, error) {
+	fields, isObject := item.(map[string]interface{})
+	if !isObject {
+		return nil, fmt.Errorf("item must be an object")
+	}
+	key := t.PrimaryKey.Name
+	rawID, exists := fields[key]
+	if !exists {
+		return nil, fieldError{key, fmt.Errorf("field %s is required", key)}
+	}
+	id, err := t.PrimaryKey.value(rawID)
+	if err != nil {
+		return nil, invalidField(t.PrimaryKey, err)
+	}
+
+	var op operation
+	switch fields["op"] {
+	case batchUpdate:
+		if err := db.authorize(ctx, t, opUpdate); err != nil {
+			return nil, err
+		}
+		record, isObject := fields["record"].(map[string]interface{})
+		if !isObject {
+			return nil, fieldError{"record", fmt.Errorf("field record must be an object")}
+		}
+		op, err = db.prepareUpdate(t, id, record)
+		if err != nil {
+			return nil, err
+		}
+	case batchDelete:
+		if err := db.authorize(ctx, t, opDelete); err != nil {
+			return nil, err
+		}
+		op = db.prepareDelete(t, id)
+	default:
+		return nil, fieldError{"op", fmt.Errorf("field op must be %s or %s", batchUpdate, batchDelete)}
+	}
+
+	// if_match of items is If-Match of single updates and deletes
+	rawIfMatch, exists := fields[fieldIfMatch]
+	if !exists {
+		if t.requireIfMatch {
+			return nil, fieldError{fieldIfMatch, fmt.Errorf("field %s is required", fieldIfMatch)}
+		}
+		return op, nil
+	}
+	ifMatch, isString := rawIfMatch.(string)
+	if !isString || ifMatch == "" {
+		return nil, fieldError{fieldIfMatch, fmt.Errorf("field %s have invalid type", fieldIfMatch)}
+	}
+	return db.precondition(t, id, ifMatch, op), nil
+}
+
+func checkBatchSize(items []interface{}) error {
+	if len(items) == 0 {
+		return httpError{http.StatusBadRequest, fmt.Errorf("batch is empty")}
+	}
+	if len(items) > maxBatchSize {
+		return httpError{http.StatusBadRequest, fmt.Errorf("batch is larger than %d", maxBatchSize)}
+	}
+	return nil
+}
+
+// runBatch runs validated operations in one transaction, the first failed one rolls all of them back
+// and is answered with its index, errors of the database that are not about constraints are not shown
+func (db DbExplorer) runBatch(ctx context.Context, ops []operation) (interface{}, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, 0, len(ops))
+	for idx, op := range ops {
+		result, err := op(tx)
+		if err != nil {
+			tx.Rollback()
+			err = db.constraintError(err)
+			if httpErr, ok := err.(httpError); ok {
+				return nil, batchError{httpErr.Status, []itemError{newItemError(idx, err)}}
+			}
+			log.Printf("db explorer: batch item %d: %s", idx, err)
+			return nil, batchError{http.StatusInternalServerError, []itemError{{Index: idx, Error: "internal error"}}}
+		}
+		results = append(results, result)
+	}
+	if err := db.commit(tx); err != nil {
+		return nil, err
+	}
+	return response{"results": results}, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	headerETag    = "ETag"
+	headerIfMatch = "If-Match"
+	// fieldIfMatch is If-Match of items of batches
+	fieldIfMatch = "if_match"
+	// fieldETag is the ETag of records of lists and of created records
+	fieldETag = "_etag"
+)
+
+// Concurrency is optimistic locking of a table: GET /$table/$id sends the ETag of the record,
+// records of lists and responses to creates have it as _etag,
+// updates and deletes with If-Match fail with 412 if the record changed since
+type Concurrency struct {
+	// RequireIfMatch answers 428 to updates and deletes without If-Match
+	RequireIfMatch bool `json:"require_if_match"`
+	// VersionColumn is an integer column the explorer sets to 1 on inserts and increments on updates,
+	// ETags of the table are its values instead of hashes of records
+	VersionColumn string `json:"version_column"`
+}
+
+// WithConcurrency sets optimistic locking of tables by their names
+func WithConcurrency(tables map[string]*Concurrency) Option {
+	return func(explorer *DbExplorer) {
+		explorer.concurrency = tables
+	}
+}
+
+// WithETagKey sets the key hashes of ETags are signed with, explorers behind one balancer need the same one,
+// without it every explorer makes a random key at start
+func WithETagKey(key []byte) Option {
+	return func(explorer *DbExplorer) {
+		explorer.etagKey = key
+	}
+}
+
+// applyConcurrency checks settings of tables against them and marks version columns read-only,
+// settings that dont fit are returned and their tables are left without locking
+func (db DbExplorer) applyConcurrency(tables map[string]*table) []string {
+	names := make([]string, 0, len(db.concurrency))
+	for name := range db.concurrency {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		cc := db.concurrency[name]
+		t, exists := tables[name]
+		if !exists {
+			problems = append(problems, fmt.Sprintf("concurrency of unknown table %s", name))
+			continue
+		}
+		if t.PrimaryKey == nil {
+			problems = append(problems, fmt.Sprintf("concurrency of table %s without a primary key", name))
+			continue
+		}
+		if cc.VersionColumn != "" {
+			c, exists := t.columns[cc.VersionColumn]
+			if !exists {
+				problems = append(problems, fmt.Sprintf("unknown version column %s of table %s", cc.VersionColumn, name))
+				continue
+			}
+			if c.Kind != kindInt || c.PrimaryKey {
+				problems = append(problems, fmt.Sprintf("version column %s of table %s must be an integer column, not the primary key", c.Name, name))
+				continue
+			}
+			t.version = c
+			c.Schema.ReadOnly = true
+		}
+		t.locked = true
+		t.requireIfMatch = cc.RequireIfMatch
+	}
+	return problems
+}
+
+// taggedResult is a result sent with the ETag header
+type taggedResult struct {
+	ETag   string
+	Result interface{}
+}
+
+// etagColumns are the columns the ETag of a record is computed from: all of them, whatever the view returns,
+// so every principal gets the same ETag of the record. Hashes are signed with the key of the explorer,
+// so values of hidden and write-only columns cant be guessed from them
+func (t *table) etagColumns() []*column {
+	return append(append([]*column{}, t.Columns...), t.hidden...)
+}
+
+// withETagColumns are the columns with the etagColumns missing among them added
+func (t *table) withETagColumns(columns []*column) []*column {
+	all := append([]*column{}, columns...)
+	for _, c := range t.etagColumns() {
+		if !hasColumn(all, c) {
+			all = append(all, c)
+		}
+	}
+	return all
+}
+
+// etag is the version or the signed hash of the etagColumns of the record
+func (db DbExplorer) etag(t *table, record response) string {
+	if t.version != nil {
+		return fmt.Sprintf(`"%v"`, record[t.version.Name])
+	}
+	image := make(response, len(record))
+	for _, c := range t.etagColumns() {
+		image[c.Name] = record[c.Name]
+	}
+	// keys of maps are sorted, so the same record always has the same json
+	data, _ := json.Marshal(image)
+	mac := hmac.New(sha256.New, db.etagKey)
+	mac.Write(data)
+	return `"` + hex.EncodeToString(mac.Sum(nil)) + `"`
+}
+
+// project removes the values of columns that are not among columns from the record
+func project(record response, columns []*column) {
+	for name := range record {
+		keep := false
+		for _, c := range columns {
+			keep = keep || c.Name == name
+		}
+		if !keep {
+			delete(record, name)
+		}
+	}
+}
+
+// selectTagged returns the record of the view and its ETag, 404 if there is no record,
+// lock keeps the row locked until the end of the transaction of q
+func (db DbExplorer) selectTagged(q querier, t *table, id interface{}, lock bool) (response, string, error) {
+	columns := t.etagColumns()
+	args := &queryArgs{dialect: db.dialect}
+	query := "SELECT " + db.selectColumns(columns) + " FROM " + db.dialect.quoteIdent(t.Name) + db.recordWhere(t, id, args)
+	if lock {
+		query += db.dialect.lockRows()
+	}
+	records, err := db.queryRecords(q, columns, query, args.args...)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(records) == 0 {
+		return nil, "", httpError{http.StatusNotFound, fmt.Errorf("record not found")}
+	}
+	record := records[0]
+	etag := db.etag(t, record)
+	project(record, t.readColumns())
+	return record, etag, nil
+}
+
+// tagged adds the ETag of the created record to the result of the create, the key is the one the create returns
+func (db DbExplorer) tagged(t *table, create operation) operation {
+	if !t.locked || t.PrimaryKey == nil {
+		return create
+	}
+	return func(q querier) (interface{}, error) {
+		result, err := create(q)
+		if err != nil {
+			return nil, err
+		}
+		created := result.(response)
+		key := created[t.PrimaryKey.Name]
+		if value, err := t.PrimaryKey.value(key); err == nil {
+			key = value
+		}
+		_, etag, err := db.selectTagged(q, t, key, false)
+		if err != nil {
+			return nil, err
+		}
+		created[fieldETag] = etag
+		return created, nil
+	}
+}
+
+// runConditional runs the update or delete of the record, with If-Match the record is checked first
+// in the same transaction and updates answer with the new ETag
+func (db DbExplorer) runConditional(t *table, id interface{}, r *http.Request, op operation, tagResult bool) (interface{}, error) {
+	ifMatch := r.Header.Get(headerIfMatch)
+	if ifMatch == "" {
+		if t.requireIfMatch {
+			return nil, errIfMatchRequired()
+		}
+		return db.runWrite(r.Context(), op)
+	}
+
+	tx, err := db.begin(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.precondition(t, id, ifMatch, op)(tx)
+	var etag string
+	if err == nil && tagResult {
+		_, etag, err = db.selectTagged(tx, t, id, false)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := db.commit(tx); err != nil {
+		return nil, err
+	}
+	if tagResult {
+		return taggedResult{etag, result}, nil
+	}
+	return result, nil
+}
+
+// precondition runs the operation only if the current ETag of the record is one of If-Match, * matches any record
+func (db DbExplorer) precondition(t *table, id interface{}, ifMatch string, op operation) operation {
+	return func(q querier) (interface{}, error) {
+		_, etag, err := db.selectTagged(q, t, id, true)
+		if err != nil {
+			return nil, err
+		}
+		if !matchETag(ifMatch, etag) {
+			return nil, httpError{http.StatusPreconditionFailed, fmt.Errorf("precondition failed")}
+		}
+		return op(q)
+	}
+}
+
+// matchETag is the strong comparison of If-Match, weak ETags never match
+func matchETag(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func errIfMatchRequired() error {
+	return httpError{http.StatusPreconditionRequired, fmt.Errorf("If-Match is required")}
+}
@@ -1,22 +1,693 @@
 package main
 
-import "net/http"
-import "database/sql"
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
 
 // тут вы пишете код
 // обращаю ваше внимание - в этом задании запрещены глобальные переменные
 
+const (
+	defaultLimit  = 5
+	defaultOffset = 0
+)
+
 type DbExplorer struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+	// catalog is the schema a request works with, ServeHTTP takes the current one of schemas
+	*catalog
+	schemas *schemaCache
+	// nil policy allows everything to everyone
+	policy *Policy
+	// concurrency maps names of tables to their optimistic locking
+	concurrency map[string]*Concurrency
+	// etagKey signs hashes of ETags
+	etagKey []byte
+	// nil audit log writes no changes
+	audit *AuditLog
+}
+
+// catalog is the introspected schema, it is not changed once loaded, reloads replace it
+type catalog struct {
+	tables     map[string]*table
+	tableNames []string
+	// stale are entries of the policy and of concurrency settings that dont fit the tables, they are skipped
+	stale []string
+}
+
+// httpError is an error answered with its status, other errors are 500
+type httpError struct {
+	Status int
+	Err    error
+}
+
+func (e httpError) Error() string {
+	return e.Err.Error()
+}
+
+type response map[string]interface{}
+
+// NewDbExplorer works with MySQL, SQLite and PostgreSQL, the dialect is picked by the driver of db
+func NewDbExplorer(db *sql.DB, options ...Option) (DbExplorer, error) {
+	d, err := detectDialect(db)
+	if err != nil {
+		return DbExplorer{}, err
+	}
+	return newDbExplorer(db, d, options...)
+}
+
+func newDbExplorer(db *sql.DB, d dialect, options ...Option) (DbExplorer, error) {
+	explorer := DbExplorer{
+		db:      db,
+		dialect: d,
+		schemas: &schemaCache{},
+	}
+	for _, option := range options {
+		option(&explorer)
+	}
+	if explorer.etagKey == nil {
+		explorer.etagKey = make([]byte, 32)
+		if _, err := rand.Read(explorer.etagKey); err != nil {
+			return DbExplorer{}, err
+		}
+	}
+	c, err := explorer.loadCatalog()
+	if err != nil {
+		return DbExplorer{}, err
+	}
+	// mistakes of the configuration fail at start, reloads only report them
+	if len(c.stale) > 0 {
+		return DbExplorer{}, fmt.Errorf("%s", strings.Join(c.stale, "; "))
+	}
+	explorer.schemas.current.Store(c)
+	explorer.catalog = c
+	return explorer, nil
+}
+
+// loadCatalog introspects tables and foreign keys, entries of the policy and of concurrency settings that dont fit them
+// are collected in stale
+func (db DbExplorer) loadCatalog() (*catalog, error) {
+	tables, err := db.dialect.loadTables(db.db)
+	if err != nil {
+		return nil, err
+	}
+	c := &catalog{
+		tables: make(map[string]*table, len(tables)),
+	}
+	for _, t := range tables {
+		t.initSchema()
+		c.tables[t.Name] = t
+		c.tableNames = append(c.tableNames, t.Name)
+	}
+
+	fkColumns, err := db.dialect.loadForeignKeys(db.db, tables)
+	if err != nil {
+		return nil, err
+	}
+	linkForeignKeys(c.tables, fkColumns)
+
+	if db.policy != nil {
+		c.stale = append(c.stale, db.policy.check(c)...)
+	}
+	c.stale = append(c.stale, db.applyConcurrency(c.tables)...)
+	return c, nil
 }
 
 func (db DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("everything is OK!"))
+	// the whole request sees one schema even if it is reloaded meanwhile
+	db.catalog = db.schemas.load()
+	principal, err := db.authenticate(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+
+	result, err := db.route(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if tagged, isTagged := result.(taggedResult); isTagged {
+		w.Header().Set(headerETag, tagged.ETag)
+		result = tagged.Result
+	}
+	if s, isStream := result.(stream); isStream {
+		// the status is already sent, errors can only be logged
+		if err := s(w); err != nil {
+			log.Printf("db explorer: %s", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, response{"response": result})
+}
+
+// stream is a result that writes the response itself, like exports too large to be built in memory
+type stream func(w http.ResponseWriter) error
+
+// route dispatches / , /$table, /$table/$id and /$table/$id/$referencing_table by the http method,
+// /_schema and /$table/_schema describe tables, /_admin/reload reloads them, /_changes is the audit log,
+// handlers get views of tables the policy allows the operation on
+func (db DbExplorer) route(r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	path := strings.Trim(r.URL.Path, "/")
+	switch path {
+	case "":
+		if r.Method != http.MethodGet {
+			return nil, errBadMethod()
+		}
+		return response{"tables": db.readableTables(ctx)}, nil
+	case schemaPath:
+		if r.Method != http.MethodGet {
+			return nil, errBadMethod()
+		}
+		return db.schema(ctx), nil
+	case reloadPath:
+		if r.Method != http.MethodPost {
+			return nil, errBadMethod()
+		}
+		if err := db.authorizeAdmin(ctx); err != nil {
+			return nil, err
+		}
+		changes, err := db.ReloadSchema()
+		if err != nil {
+			return nil, err
+		}
+		return response{"changes": changes}, nil
+	case changesPath:
+		if r.Method != http.MethodGet {
+			return nil, errBadMethod()
+		}
+		if err := db.authorizeAdmin(ctx); err != nil {
+			return nil, err
+		}
+		return db.changes(r)
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) > 3 {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("unknown method")}
+	}
+	t, exists := db.tables[parts[0]]
+	if !exists {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("unknown table")}
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			if err := db.authorize(ctx, t, opRead); err != nil {
+				return nil, err
+			}
+			return db.list(db.view(ctx, t), r)
+		case http.MethodPut:
+			if err := db.authorize(ctx, t, opCreate); err != nil {
+				return nil, err
+			}
+			return db.create(db.view(ctx, t), r)
+		case http.MethodPost:
+			// operations of items are authorized one by one
+			return db.batch(db.view(ctx, t), r)
+		}
+		return nil, errBadMethod()
+	}
+
+	if len(parts) == 2 && parts[1] == schemaPath {
+		if r.Method != http.MethodGet {
+			return nil, errBadMethod()
+		}
+		if err := db.authorize(ctx, t, opRead); err != nil {
+			return nil, err
+		}
+		s := db.view(ctx, t).schema()
+		s.Schema = jsonSchemaDraft
+		return s, nil
+	}
+	if len(parts) == 2 && parts[1] == exportPath {
+		if r.Method != http.MethodGet {
+			return nil, errBadMethod()
+		}
+		if err := db.authorize(ctx, t, opRead); err != nil {
+			return nil, err
+		}
+		return db.export(db.view(ctx, t), r)
+	}
+	if len(parts) == 2 && parts[1] == importPath {
+		if r.Method != http.MethodPost {
+			return nil, errBadMethod()
+		}
+		if err := db.authorize(ctx, t, opCreate); err != nil {
+			return nil, err
+		}
+		return db.importRecords(db.view(ctx, t), r)
+	}
+
+	if t.PrimaryKey == nil {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("table has no primary key")}
+	}
+	// ids that dont fit the key column cant be found, postgres would fail the query on them
+	id, err := t.PrimaryKey.value(parts[1])
+	if err != nil {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("record not found")}
+	}
+	op := opRead
+	switch {
+	case len(parts) == 3 && r.Method != http.MethodGet:
+		return nil, errBadMethod()
+	case len(parts) == 3, r.Method == http.MethodGet:
+	case r.Method == http.MethodPost:
+		op = opUpdate
+	case r.Method == http.MethodDelete:
+		op = opDelete
+	default:
+		return nil, errBadMethod()
+	}
+	if err := db.authorize(ctx, t, op); err != nil {
+		return nil, err
+	}
+	t = db.view(ctx, t)
+
+	switch {
+	case len(parts) == 3:
+		return db.listReferencing(t, id, parts[2], r)
+	case op == opUpdate:
+		return db.update(t, id, r)
+	case op == opDelete:
+		return db.delete(t, id, r)
+	}
+	return db.get(t, id, r)
+}
+
+// schema is the document with schemas of the tables the principal of the request can read in definitions
+func (db DbExplorer) schema(ctx context.Context) interface{} {
+	definitions := make(map[string]*jsonSchema, len(db.tableNames))
+	for _, name := range db.readableTables(ctx) {
+		definitions[name] = db.view(ctx, db.tables[name]).schema()
+	}
+	return response{
+		"$schema":     jsonSchemaDraft,
+		"definitions": definitions,
+	}
+}
+
+func errBadMethod() error {
+	return httpError{http.StatusMethodNotAllowed, fmt.Errorf("bad method")}
+}
+
+// list returns records of the table filtered, sorted and projected by the query params,
+// total=true adds the number of records matching the filters for pagination
+func (db DbExplorer) list(t *table, r *http.Request) (interface{}, error) {
+	return db.listFiltered(t, r)
+}
+
+// listFiltered is list with filters added to the ones of the query params
+func (db DbExplorer) listFiltered(t *table, r *http.Request, filters ...filter) (interface{}, error) {
+	params, err := parseListParams(t, r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	params.Filters = append(params.Filters, filters...)
+
+	args := &queryArgs{dialect: db.dialect}
+	where := db.where(t, params.Filters, args)
+	result := response{}
+	if params.Total {
+		var total int64
+		query := "SELECT COUNT(*) FROM " + db.dialect.quoteIdent(t.Name) + where
+		if err := db.db.QueryRow(query, args.args...).Scan(&total); err != nil {
+			return nil, err
+		}
+		result["total"] = total
+	}
+
+	columns := params.Fields
+	if t.locked {
+		columns = t.withETagColumns(columns)
+	}
+	query := "SELECT " + db.selectColumns(columns) + " FROM " + db.dialect.quoteIdent(t.Name) + where +
+		db.orderBy(params.OrderBy) + " LIMIT " + args.add(params.Limit) + " OFFSET " + args.add(params.Offset)
+	records, err := db.queryRecords(db.db, columns, query, args.args...)
+	if err != nil {
+		return nil, err
+	}
+	if t.locked {
+		for _, record := range records {
+			etag := db.etag(t, record)
+			project(record, params.Fields)
+			record[fieldETag] = etag
+		}
+	}
+	if err := db.expand(r.Context(), records, params.Expand); err != nil {
+		return nil, err
+	}
+	result["records"] = records
+	return result, nil
+}
+
+// get returns the record with its ETag
+func (db DbExplorer) get(t *table, id interface{}, r *http.Request) (interface{}, error) {
+	relations, err := parseExpand(t, r.URL.Query(), t.readColumns())
+	if err != nil {
+		return nil, err
+	}
+
+	record, etag, err := db.selectTagged(db.db, t, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.expand(r.Context(), []response{record}, relations); err != nil {
+		return nil, err
+	}
+	return taggedResult{etag, response{"record": record}}, nil
+}
+
+// operation is a write validated against the schema, it runs alone or in the transaction of a batch
+type operation func(q querier) (interface{}, error)
+
+// fieldError is a validation error of a field of the body, batches report the field of the failed item
+type fieldError struct {
+	Field string
+	Err   error
+}
+
+func (e fieldError) Error() string {
+	return e.Err.Error()
+}
+
+func invalidField(c *column, err error) error {
+	return httpError{http.StatusBadRequest, fieldError{c.Name, err}}
+}
+
+// create inserts a record, an array in the body is inserted in one transaction
+func (db DbExplorer) create(t *table, r *http.Request) (interface{}, error) {
+	body, err := decodeBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if items, isArray := body.([]interface{}); isArray {
+		return db.bulkCreate(r.Context(), t, items)
+	}
+
+	op, err := db.prepareCreate(t, body.(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	return db.runWrite(r.Context(), op)
+}
+
+// prepareCreate validates a new record, an auto increment primary key is ignored, columns with defaults missing in the body
+// are left to the database, other ones get zero values, the owner column of the view gets the id of the principal
+func (db DbExplorer) prepareCreate(t *table, body map[string]interface{}) (operation, error) {
+	insert, err := db.prepareInsert(t, body, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.tagged(t, insert), nil
+}
+
+// prepareInsert is prepareCreate that writes the auto increment primary key and the version of the body if keepKey is set,
+// imports keep keys and versions of records. An owner column that is the primary key is not generated, records of it are
+// created with the id of the principal as the key
+func (db DbExplorer) prepareInsert(t *table, body map[string]interface{}, keepKey bool) (operation, error) {
+	if t.owner != nil && t.ownerID == nil {
+		return nil, deniedError{Table: t.Name, Operation: opCreate, Column: t.owner.Name}
+	}
+	var names, placeholders []string
+	args := &queryArgs{dialect: db.dialect}
+	_, hasKey := body[t.primaryKeyName()]
+	ownedKey := t.owner != nil && t.owner.PrimaryKey
+	generatedKey := t.PrimaryKey != nil && t.PrimaryKey.AutoIncrement && !(keepKey && hasKey) && !ownedKey
+	var key interface{}
+	for _, c := range t.Columns {
+		if c.PrimaryKey && generatedKey {
+			continue
+		}
+		value := c.zeroValue()
+		raw, exists := body[c.Name]
+		if !exists && c.HasDefault && c != t.owner && c != t.version {
+			continue
+		}
+		if c == t.owner {
+			value = t.ownerID
+		} else if c == t.version && !(keepKey && exists) {
+			value = int64(1)
+		} else if exists {
+			var err error
+			value, err = c.value(raw)
+			if err != nil {
+				return nil, invalidField(c, err)
+			}
+		}
+		if c.PrimaryKey {
+			key = value
+		}
+		names = append(names, db.dialect.quoteIdent(c.Name))
+		placeholders = append(placeholders, args.add(value))
+	}
+	for _, c := range t.hidden {
+		if c == t.version {
+			names = append(names, db.dialect.quoteIdent(c.Name))
+			placeholders = append(placeholders, args.add(int64(1)))
+		} else if !c.Nullable && !c.HasDefault {
+			names = append(names, db.dialect.quoteIdent(c.Name))
+			placeholders = append(placeholders, args.add(c.zeroValue()))
+		}
+	}
+
+	query := "INSERT INTO " + db.dialect.quoteIdent(t.Name) +
+		" (" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	return db.audited(t, opCreate, nil, func(q querier) (interface{}, error) {
+		if generatedKey {
+			id, err := db.dialect.insert(q, query, args.args, t.PrimaryKey)
+			if err != nil {
+				return nil, db.constraintError(err)
+			}
+			return response{t.PrimaryKey.Name: id}, nil
+		}
+
+		result, err := q.Exec(query, args.args...)
+		if err != nil {
+			return nil, db.constraintError(err)
+		}
+		if t.PrimaryKey == nil {
+			inserted, err := result.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			return response{"inserted": inserted}, nil
+		}
+		return response{t.PrimaryKey.Name: key}, nil
+	}), nil
 }
 
+func (db DbExplorer) update(t *table, id interface{}, r *http.Request) (interface{}, error) {
+	body, err := requestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	op, err := db.prepareUpdate(t, id, body)
+	if err != nil {
+		return nil, err
+	}
+	return db.runConditional(t, id, r, op, true)
+}
+
+// prepareUpdate sets columns from the body, the primary key cant be updated, unknown fields are ignored,
+// so is the version column, it is incremented instead
+func (db DbExplorer) prepareUpdate(t *table, id interface{}, body map[string]interface{}) (operation, error) {
+	var sets []string
+	args := &queryArgs{dialect: db.dialect}
+	for _, c := range t.Columns {
+		raw, exists := body[c.Name]
+		if !exists {
+			continue
+		}
+		if c.PrimaryKey {
+			return nil, invalidField(c, fmt.Errorf("field %s have invalid type", c.Name))
+		}
+		if c == t.owner {
+			return nil, deniedError{Table: t.Name, Operation: opUpdate, Column: c.Name}
+		}
+		if c == t.version {
+			continue
+		}
+		value, err := c.value(raw)
+		if err != nil {
+			return nil, invalidField(c, err)
+		}
+		sets = append(sets, db.dialect.quoteIdent(c.Name)+" = "+args.add(value))
+	}
+	if t.version != nil && len(sets) > 0 {
+		version := db.dialect.quoteIdent(t.version.Name)
+		sets = append(sets, version+" = "+version+" + 1")
+	}
 
+	if len(sets) == 0 {
+		return func(q querier) (interface{}, error) {
+			return response{"updated": 0}, nil
+		}, nil
+	}
+	query := "UPDATE " + db.dialect.quoteIdent(t.Name) + " SET " + strings.Join(sets, ", ") + db.recordWhere(t, id, args)
+	return db.audited(t, opUpdate, id, func(q querier) (interface{}, error) {
+		result, err := q.Exec(query, args.args...)
+		if err != nil {
+			return nil, db.constraintError(err)
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		return response{"updated": updated}, nil
+	}), nil
+}
+
+func (db DbExplorer) delete(t *table, id interface{}, r *http.Request) (interface{}, error) {
+	return db.runConditional(t, id, r, db.prepareDelete(t, id), false)
+}
 
-func NewDbExplorer(db *sql.DB) (DbExplorer, error) {
-	return DbExplorer{db}, nil
-}
\ No newline at end of file
+func (db DbExplorer) prepareDelete(t *table, id interface{}) operation {
+	args := &queryArgs{dialect: db.dialect}
+	query := "DELETE FROM " + db.dialect.quoteIdent(t.Name) + db.recordWhere(t, id, args)
+	return db.audited(t, opDelete, id, func(q querier) (interface{}, error) {
+		if err := db.checkNotReferenced(q, t, id); err != nil {
+			return nil, err
+		}
+		result, err := q.Exec(query, args.args...)
+		if err != nil {
+			return nil, db.constraintError(err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		return response{"deleted": deleted}, nil
+	})
+}
+
+func (db DbExplorer) selectColumns(columns []*column) string {
+	names := make([]string, 0, len(columns))
+	for _, c := range columns {
+		names = append(names, db.dialect.quoteIdent(c.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// queryRecords runs a select of the columns and returns rows as maps of column names to values
+func (db DbExplorer) queryRecords(q querier, columns []*column, query string, args ...interface{}) ([]response, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []response{}
+	for rows.Next() {
+		record, err := scanRecord(rows, columns)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func scanRecord(rows *sql.Rows, columns []*column) (response, error) {
+	dests := make([]interface{}, len(columns))
+	for idx, c := range columns {
+		dests[idx] = c.scanDest()
+	}
+	if err := rows.Scan(dests...); err != nil {
+		return nil, err
+	}
+	record := make(response, len(columns))
+	for idx, c := range columns {
+		record[c.Name] = scannedValue(dests[idx])
+	}
+	return record, nil
+}
+
+func queryInt(query url.Values, name string, defaultValue int) int {
+	value, err := strconv.Atoi(query.Get(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// requestBody reads fields of a record from a json body or POST params
+func requestBody(r *http.Request) (map[string]interface{}, error) {
+	body, err := decodeBody(r)
+	if err != nil {
+		return nil, err
+	}
+	record, isObject := body.(map[string]interface{})
+	if !isObject {
+		return nil, httpError{http.StatusBadRequest, fmt.Errorf("cant parse body: record must be an object")}
+	}
+	return record, nil
+}
+
+// decodeBody reads a json object or array, or POST params as an object
+func decodeBody(r *http.Request) (interface{}, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body interface{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			return nil, httpError{http.StatusBadRequest, fmt.Errorf("cant parse body: %s", err)}
+		}
+		switch body.(type) {
+		case map[string]interface{}, []interface{}:
+			return body, nil
+		}
+		return nil, httpError{http.StatusBadRequest, fmt.Errorf("cant parse body: must be an object or an array")}
+	}
+
+	body := make(map[string]interface{})
+	if err := r.ParseForm(); err != nil {
+		return nil, httpError{http.StatusBadRequest, fmt.Errorf("cant parse body: %s", err)}
+	}
+	for name := range r.PostForm {
+		body[name] = r.PostForm.Get(name)
+	}
+	return body, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch typedErr := err.(type) {
+	case httpError:
+		status = typedErr.Status
+	case batchError:
+		writeJSON(w, typedErr.Status, response{"error": err.Error(), "errors": typedErr.Errors})
+		return
+	case deniedError:
+		writeJSON(w, http.StatusForbidden, typedErr.response())
+		return
+	default:
+		log.Printf("db explorer: %s", err)
+		err = fmt.Errorf("internal error")
+	}
+	writeJSON(w, status, response{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp interface{}) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data = []byte(`{"error":"internal error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
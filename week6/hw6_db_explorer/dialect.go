@@ -0,0 +1,418 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect is what differs between databases the explorer works with
+type dialect interface {
+	// loadTables introspects tables ordered by name, rows of every query are closed before the next one,
+	// so only one connection is used
+	loadTables(q querier) ([]*table, error)
+	loadForeignKeys(q querier, tables []*table) ([]foreignKeyColumn, error)
+	quoteIdent(name string) string
+	// placeholder is the placeholder of the n-th query arg, n starts from 1
+	placeholder(n int) string
+	// insert runs the insert query and returns the generated primary key
+	insert(q querier, query string, args []interface{}, pk *column) (int64, error)
+	// isForeignKeyViolation tells errors of inserts, updates and deletes breaking a foreign key
+	isForeignKeyViolation(err error) bool
+	// lockRows is the suffix of selects locking their rows until the end of the transaction
+	lockRows() string
+	// asText casts the quoted column to text for LIKE, postgres doesnt do it implicitly
+	asText(name string) string
+}
+
+// foreignKeyColumn is a column of a foreign key as the database reports it,
+// columns of the same constraint go one after another
+type foreignKeyColumn struct {
+	Constraint string
+	Table      string
+	Column     string
+	RefTable   string
+	// empty when the key references the primary key
+	RefColumn string
+	// OnDelete is the delete rule: NO ACTION, RESTRICT, CASCADE, SET NULL or SET DEFAULT
+	OnDelete string
+}
+
+// queryForeignKeys reads foreign key columns with a query returning them in the order of foreignKeyColumn fields
+func queryForeignKeys(q querier, query string) ([]foreignKeyColumn, error) {
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fks []foreignKeyColumn
+	for rows.Next() {
+		var fk foreignKeyColumn
+		if err := rows.Scan(&fk.Constraint, &fk.Table, &fk.Column, &fk.RefTable, &fk.RefColumn, &fk.OnDelete); err != nil {
+			return nil, err
+		}
+		fks = append(fks, fk)
+	}
+	return fks, rows.Err()
+}
+
+// querier is *sql.DB or *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// detectDialect picks the dialect by the driver the connection is opened with
+func detectDialect(db *sql.DB) (dialect, error) {
+	driverType := strings.ToLower(fmt.Sprintf("%T", db.Driver()))
+	switch {
+	case strings.Contains(driverType, "mysql"):
+		return mysqlDialect{}, nil
+	case strings.Contains(driverType, "sqlite"):
+		return sqliteDialect{}, nil
+	case strings.HasPrefix(driverType, "*pq."), strings.Contains(driverType, "postgres"), strings.Contains(driverType, "pgx"),
+		strings.HasPrefix(driverType, "*stdlib."):
+		return postgresDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %T", db.Driver())
+}
+
+// queryArgs collects args of a query and returns placeholders of the dialect for them
+type queryArgs struct {
+	dialect dialect
+	args    []interface{}
+}
+
+func (qa *queryArgs) add(value interface{}) string {
+	qa.args = append(qa.args, value)
+	return qa.dialect.placeholder(len(qa.args))
+}
+
+// queryNames runs a query returning a single string column
+func queryNames(q querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// loadTablesByNames loads columns of every table with loadTable after the list of tables is read
+func loadTablesByNames(names []string, loadTable func(name string) (*table, error)) ([]*table, error) {
+	tables := make([]*table, 0, len(names))
+	for _, name := range names {
+		t, err := loadTable(name)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %s", name, err)
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// insertWithLastInsertId is the insert of databases that report the generated key in the result
+func insertWithLastInsertId(q querier, query string, args []interface{}) (int64, error) {
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) loadTables(q querier) ([]*table, error) {
+	names, err := queryNames(q, "SHOW TABLES")
+	if err != nil {
+		return nil, err
+	}
+	return loadTablesByNames(names, func(name string) (*table, error) {
+		rows, err := q.Query("SHOW FULL COLUMNS FROM " + mysqlDialect{}.quoteIdent(name))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		t := newTable(name)
+		for rows.Next() {
+			// Field, Type, Collation, Null, Key, Default, Extra, Privileges, Comment
+			var field, colType, collation, null, key, def, extra, privileges, comment sql.NullString
+			err := rows.Scan(&field, &colType, &collation, &null, &key, &def, &extra, &privileges, &comment)
+			if err != nil {
+				return nil, err
+			}
+			t.addColumn(&column{
+				Name:          field.String,
+				Type:          colType.String,
+				Nullable:      null.String == "YES",
+				PrimaryKey:    key.String == "PRI",
+				AutoIncrement: strings.Contains(extra.String, "auto_increment"),
+				HasDefault:    def.Valid || strings.Contains(extra.String, "GENERATED"),
+			})
+		}
+		return t, rows.Err()
+	})
+}
+
+func (mysqlDialect) loadForeignKeys(q querier, tables []*table) ([]foreignKeyColumn, error) {
+	return queryForeignKeys(q, `SELECT kcu.CONSTRAINT_NAME, kcu.TABLE_NAME, kcu.COLUMN_NAME,
+			kcu.REFERENCED_TABLE_NAME, kcu.REFERENCED_COLUMN_NAME, rc.DELETE_RULE
+		FROM information_schema.KEY_COLUMN_USAGE kcu
+		JOIN information_schema.REFERENTIAL_CONSTRAINTS rc
+			ON rc.CONSTRAINT_SCHEMA = kcu.TABLE_SCHEMA AND rc.TABLE_NAME = kcu.TABLE_NAME AND rc.CONSTRAINT_NAME = kcu.CONSTRAINT_NAME
+		WHERE kcu.TABLE_SCHEMA = DATABASE() AND kcu.REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY kcu.TABLE_NAME, kcu.CONSTRAINT_NAME, kcu.ORDINAL_POSITION`)
+}
+
+func (mysqlDialect) quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (mysqlDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) insert(q querier, query string, args []interface{}, pk *column) (int64, error) {
+	return insertWithLastInsertId(q, query, args)
+}
+
+func (mysqlDialect) isForeignKeyViolation(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	// ER_ROW_IS_REFERENCED, ER_NO_REFERENCED_ROW and their _2 versions
+	switch mysqlErr.Number {
+	case 1216, 1217, 1451, 1452:
+		return true
+	}
+	return false
+}
+
+func (mysqlDialect) lockRows() string {
+	return " FOR UPDATE"
+}
+
+func (mysqlDialect) asText(name string) string {
+	return name
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) loadTables(q querier) ([]*table, error) {
+	names, err := queryNames(q, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	return loadTablesByNames(names, func(name string) (*table, error) {
+		rows, err := q.Query("PRAGMA table_info(" + sqliteDialect{}.quoteIdent(name) + ")")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		t := newTable(name)
+		pkColumns := 0
+		for rows.Next() {
+			var cid, notNull, pk int
+			var colName, colType string
+			var def sql.NullString
+			if err := rows.Scan(&cid, &colName, &colType, &notNull, &def, &pk); err != nil {
+				return nil, err
+			}
+			t.addColumn(&column{
+				Name:       colName,
+				Type:       colType,
+				Nullable:   notNull == 0 && pk == 0,
+				PrimaryKey: pk > 0,
+				HasDefault: def.Valid,
+			})
+			if pk > 0 {
+				pkColumns++
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		// the only INTEGER PRIMARY KEY column is the rowid, it is generated on insert
+		if t.PrimaryKey != nil && pkColumns == 1 && strings.EqualFold(t.PrimaryKey.Type, "integer") {
+			t.PrimaryKey.AutoIncrement = true
+		}
+		return t, nil
+	})
+}
+
+func (d sqliteDialect) loadForeignKeys(q querier, tables []*table) ([]foreignKeyColumn, error) {
+	var fks []foreignKeyColumn
+	for _, t := range tables {
+		err := func() error {
+			rows, err := q.Query("PRAGMA foreign_key_list(" + d.quoteIdent(t.Name) + ")")
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var id, seq int
+				var refTable, from string
+				var to, onUpdate, onDelete, match sql.NullString
+				if err := rows.Scan(&id, &seq, &refTable, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+					return err
+				}
+				fks = append(fks, foreignKeyColumn{
+					Constraint: strconv.Itoa(id),
+					Table:      t.Name,
+					Column:     from,
+					RefTable:   refTable,
+					RefColumn:  to.String,
+					OnDelete:   onDelete.String,
+				})
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %s", t.Name, err)
+		}
+	}
+	return fks, nil
+}
+
+func (sqliteDialect) quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (sqliteDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) insert(q querier, query string, args []interface{}, pk *column) (int64, error) {
+	return insertWithLastInsertId(q, query, args)
+}
+
+// isForeignKeyViolation works only on connections with foreign keys on, _foreign_keys=1 in the dsn
+func (sqliteDialect) isForeignKeyViolation(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+// sqlite locks the whole database on the first write of a transaction
+func (sqliteDialect) lockRows() string {
+	return ""
+}
+
+func (sqliteDialect) asText(name string) string {
+	return name
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) loadTables(q querier) ([]*table, error) {
+	names, err := queryNames(q, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+	return loadTablesByNames(names, func(name string) (*table, error) {
+		pkNames, err := queryNames(q, `SELECT kcu.column_name FROM information_schema.table_constraints tc
+			JOIN information_schema.key_column_usage kcu
+				ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema AND kcu.table_name = tc.table_name
+			WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = current_schema() AND tc.table_name = $1
+			ORDER BY kcu.ordinal_position`, name)
+		if err != nil {
+			return nil, err
+		}
+		isPK := make(map[string]bool, len(pkNames))
+		for _, pkName := range pkNames {
+			isPK[pkName] = true
+		}
+
+		rows, err := q.Query(`SELECT column_name, data_type, is_nullable, column_default, is_identity, is_generated, character_maximum_length
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, name)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		t := newTable(name)
+		for rows.Next() {
+			var colName, colType, nullable string
+			var def, identity, generated sql.NullString
+			var maxLength sql.NullInt64
+			if err := rows.Scan(&colName, &colType, &nullable, &def, &identity, &generated, &maxLength); err != nil {
+				return nil, err
+			}
+			t.addColumn(&column{
+				Name:          colName,
+				Type:          colType,
+				Nullable:      nullable == "YES",
+				PrimaryKey:    isPK[colName],
+				AutoIncrement: strings.HasPrefix(def.String, "nextval(") || identity.String == "YES",
+				HasDefault:    def.Valid || generated.String == "ALWAYS",
+				MaxLength:     int(maxLength.Int64),
+			})
+		}
+		return t, rows.Err()
+	})
+}
+
+// loadForeignKeys reads pg_constraint, names of constraints are unique per table there, not per schema,
+// so information_schema cant pair columns of keys reliably
+func (postgresDialect) loadForeignKeys(q querier, tables []*table) ([]foreignKeyColumn, error) {
+	return queryForeignKeys(q, `SELECT c.conname, cl.relname, a.attname, rcl.relname, ra.attname,
+			CASE c.confdeltype WHEN 'r' THEN 'RESTRICT' WHEN 'c' THEN 'CASCADE' WHEN 'n' THEN 'SET NULL'
+				WHEN 'd' THEN 'SET DEFAULT' ELSE 'NO ACTION' END
+		FROM pg_constraint c
+		JOIN pg_class cl ON cl.oid = c.conrelid
+		JOIN pg_class rcl ON rcl.oid = c.confrelid
+		CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, refattnum, pos)
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+		JOIN pg_attribute ra ON ra.attrelid = c.confrelid AND ra.attnum = k.refattnum
+		WHERE c.contype = 'f' AND c.connamespace = (SELECT oid FROM pg_namespace WHERE nspname = current_schema())
+		ORDER BY cl.relname, c.conname, k.pos`)
+}
+
+func (postgresDialect) quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (postgresDialect) placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// insert gets the generated key with RETURNING, the driver doesnt support LastInsertId
+func (d postgresDialect) insert(q querier, query string, args []interface{}, pk *column) (int64, error) {
+	var id int64
+	err := q.QueryRow(query+" RETURNING "+d.quoteIdent(pk.Name), args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) isForeignKeyViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23503"
+}
+
+func (postgresDialect) lockRows() string {
+	return " FOR UPDATE"
+}
+
+func (postgresDialect) asText(name string) string {
+	return name + "::text"
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// params of list requests, other params are filters by columns: login=foo, age__gt=30
+const (
+	paramLimit   = "limit"
+	paramOffset  = "offset"
+	paramOrderBy = "order_by"
+	paramFields  = "fields"
+	paramTotal   = "total"
+)
+
+// filterOperator is the sql of an operator that goes after the column name and __,
+// without an operator the column is compared for equality
+func filterOperator(operator string) (string, bool) {
+	switch operator {
+	case "eq":
+		return "=", true
+	case "ne":
+		return "<>", true
+	case "gt":
+		return ">", true
+	case "gte":
+		return ">=", true
+	case "lt":
+		return "<", true
+	case "lte":
+		return "<=", true
+	case "like":
+		return "LIKE", true
+	}
+	return "", false
+}
+
+type filter struct {
+	Column *column
+	// eq, ne, gt, gte, lt, lte, like, in or isnull
+	Operator string
+	Values   []interface{}
+}
+
+type orderBy struct {
+	Column *column
+	Desc   bool
+}
+
+type listParams struct {
+	Limit   int
+	Offset  int
+	Fields  []*column
+	Filters []filter
+	OrderBy []orderBy
+	Expand  []*foreignKey
+	Total   bool
+}
+
+func badParam(format string, args ...interface{}) error {
+	return httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+// parseListParams checks every column of the request against the table, so only names from the schema get into sql,
+// limit and offset that are not numbers get their defaults. Params naming no column of the view are 400,
+// except the ones starting with _, like cache busters, repeated filters are combined: values of eq make IN, of other operators AND
+func parseListParams(t *table, query url.Values) (*listParams, error) {
+	params := &listParams{
+		Limit:  queryInt(query, paramLimit, defaultLimit),
+		Offset: queryInt(query, paramOffset, defaultOffset),
+		Fields: t.readColumns(),
+	}
+	params.Total, _ = strconv.ParseBool(query.Get(paramTotal))
+
+	if fields := query.Get(paramFields); fields != "" {
+		params.Fields = nil
+		for _, name := range strings.Split(fields, ",") {
+			c := t.readColumn(strings.TrimSpace(name))
+			if c == nil {
+				return nil, badParam("unknown field %s", name)
+			}
+			params.Fields = append(params.Fields, c)
+		}
+	}
+
+	if order := query.Get(paramOrderBy); order != "" {
+		for _, name := range strings.Split(order, ",") {
+			name = strings.TrimSpace(name)
+			o := orderBy{Desc: strings.HasPrefix(name, "-")}
+			name = strings.TrimPrefix(name, "-")
+			c := t.readColumn(name)
+			if c == nil {
+				return nil, badParam("unknown field %s", name)
+			}
+			o.Column = c
+			params.OrderBy = append(params.OrderBy, o)
+		}
+	}
+	// records of the same sort values keep a stable order between pages
+	if t.PrimaryKey != nil {
+		params.OrderBy = append(params.OrderBy, orderBy{Column: t.PrimaryKey})
+	}
+
+	var err error
+	params.Expand, err = parseExpand(t, query, params.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// sorted, so the same request makes the same query
+	names := make([]string, 0, len(query))
+	for name := range query {
+		switch name {
+		case paramLimit, paramOffset, paramOrderBy, paramFields, paramTotal, paramExpand, paramFormat:
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filters, err := parseFilters(t, name, query[name])
+		if err != nil {
+			return nil, err
+		}
+		params.Filters = append(params.Filters, filters...)
+	}
+	return params, nil
+}
+
+// parseFilters returns a filter for every value of the param, none for params starting with _ that are not columns.
+// The whole name is tried as a column first, so names of columns can have __ in them
+func parseFilters(t *table, name string, values []string) ([]filter, error) {
+	colName, operator := name, "eq"
+	if _, exists := t.columns[name]; !exists {
+		if idx := strings.LastIndex(name, "__"); idx > 0 {
+			colName, operator = name[:idx], name[idx+2:]
+		}
+	}
+	// write-only columns are there, but cant be filtered by
+	c := t.readColumn(colName)
+	if c == nil {
+		if _, exists := t.columns[colName]; !exists && strings.HasPrefix(name, "_") {
+			return nil, nil
+		}
+		return nil, badParam("unknown field %s", colName)
+	}
+
+	filters := make([]filter, 0, len(values))
+	for _, raw := range values {
+		f, err := parseFilter(c, name, operator, raw)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if operator == "eq" && len(filters) > 1 {
+		in := filter{Column: c, Operator: "in"}
+		for _, f := range filters {
+			in.Values = append(in.Values, f.Values...)
+		}
+		filters = []filter{in}
+	}
+	return filters, nil
+}
+
+func parseFilter(c *column, name, operator, raw string) (filter, error) {
+	f := filter{Column: c, Operator: operator}
+
+	switch operator {
+	case "isnull":
+		isNull, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter{}, badParam("%s must be true or false", name)
+		}
+		f.Values = []interface{}{isNull}
+		return f, nil
+	case "in":
+		for _, item := range strings.Split(raw, ",") {
+			value, err := c.value(item)
+			if err != nil {
+				return filter{}, httpError{http.StatusBadRequest, err}
+			}
+			f.Values = append(f.Values, value)
+		}
+		return f, nil
+	case "like":
+		// patterns are strings whatever the column is
+		f.Values = []interface{}{raw}
+		return f, nil
+	}
+
+	if _, known := filterOperator(operator); !known {
+		return filter{}, badParam("unknown filter %s", name)
+	}
+	value, err := c.value(raw)
+	if err != nil {
+		return filter{}, httpError{http.StatusBadRequest, err}
+	}
+	f.Values = []interface{}{value}
+	return f, nil
+}
+
+// where returns the WHERE clause of the filters and the owner of the view with their values added to args,
+// empty without them
+func (db DbExplorer) where(t *table, filters []filter, args *queryArgs) string {
+	var conds []string
+	if cond := db.ownerCond(t, args); cond != "" {
+		conds = append(conds, cond)
+	}
+	for _, f := range filters {
+		name := db.dialect.quoteIdent(f.Column.Name)
+		switch f.Operator {
+		case "isnull":
+			if f.Values[0].(bool) {
+				conds = append(conds, name+" IS NULL")
+			} else {
+				conds = append(conds, name+" IS NOT NULL")
+			}
+		case "in":
+			placeholders := make([]string, 0, len(f.Values))
+			for _, value := range f.Values {
+				placeholders = append(placeholders, args.add(value))
+			}
+			conds = append(conds, name+" IN ("+strings.Join(placeholders, ", ")+")")
+		case "like":
+			conds = append(conds, db.dialect.asText(name)+" LIKE "+args.add(f.Values[0]))
+		default:
+			operator, _ := filterOperator(f.Operator)
+			conds = append(conds, name+" "+operator+" "+args.add(f.Values[0]))
+		}
+	}
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// ownerCond is the condition of rows the view is limited to, empty if it is not
+func (db DbExplorer) ownerCond(t *table, args *queryArgs) string {
+	if t.owner == nil {
+		return ""
+	}
+	if t.ownerID == nil {
+		return "1 = 0"
+	}
+	return db.dialect.quoteIdent(t.owner.Name) + " = " + args.add(t.ownerID)
+}
+
+// recordWhere is the WHERE clause of the record with the id among rows of the view
+func (db DbExplorer) recordWhere(t *table, id interface{}, args *queryArgs) string {
+	where := " WHERE " + db.dialect.quoteIdent(t.PrimaryKey.Name) + " = " + args.add(id)
+	if cond := db.ownerCond(t, args); cond != "" {
+		where += " AND " + cond
+	}
+	return where
+}
+
+func (db DbExplorer) orderBy(orders []orderBy) string {
+	if len(orders) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(orders))
+	for _, o := range orders {
+		part := db.dialect.quoteIdent(o.Column.Name)
+		if o.Desc {
+			part += " DESC"
+		}
+		parts = append(parts, part)
+	}
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+	// schemaPath is /_schema and /$table/_schema, ids and tables of the name are not reachable
+	schemaPath = "_schema"
+)
+
+// jsonSchema is the part of JSON Schema the explorer describes tables with,
+// bodies of PUT and POST are validated by the same schemas of columns that /$table/_schema returns
+type jsonSchema struct {
+	Schema     string                 `json:"$schema,omitempty"`
+	Title      string                 `json:"title,omitempty"`
+	Type       schemaType             `json:"type"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	ReadOnly   bool                   `json:"readOnly,omitempty"`
+	WriteOnly  bool                   `json:"writeOnly,omitempty"`
+	MaxLength  int                    `json:"maxLength,omitempty"`
+
+	// extensions with what the database knows about columns
+	DBType     string     `json:"x-dbType,omitempty"`
+	PrimaryKey bool       `json:"x-primaryKey,omitempty"`
+	References *schemaRef `json:"x-references,omitempty"`
+}
+
+type schemaRef struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	// Relation is the name of ?expand=
+	Relation string `json:"relation"`
+}
+
+// schemaType is a single type or a list of them, a nullable column is ["string", "null"]
+type schemaType []string
+
+func (st schemaType) MarshalJSON() ([]byte, error) {
+	if len(st) == 1 {
+		return json.Marshal(st[0])
+	}
+	return json.Marshal([]string(st))
+}
+
+func (st schemaType) has(name string) bool {
+	for _, t := range st {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+func columnSchema(c *column) *jsonSchema {
+	s := &jsonSchema{
+		Type:       schemaType{"string"},
+		ReadOnly:   c.PrimaryKey && c.AutoIncrement,
+		MaxLength:  c.MaxLength,
+		DBType:     c.Type,
+		PrimaryKey: c.PrimaryKey,
+	}
+	switch c.Kind {
+	case kindInt:
+		s.Type = schemaType{"integer"}
+	case kindFloat:
+		s.Type = schemaType{"number"}
+	case kindBool:
+		s.Type = schemaType{"boolean"}
+	}
+	if c.Nullable {
+		s.Type = append(s.Type, "null")
+	}
+	return s
+}
+
+// initSchema builds schemas of columns once the dialect is done with them, before foreign keys are linked
+func (t *table) initSchema() {
+	for _, c := range t.Columns {
+		c.Schema = columnSchema(c)
+	}
+}
+
+// schema is the document of a record of the table, properties are the schemas columns are validated with,
+// views of policies mark write-only columns and the owner column new records get from the principal,
+// references to tables the principal cant read are left out. Nothing is required, fields missing in bodies
+// get defaults of the database or zero values
+func (t *table) schema() *jsonSchema {
+	s := &jsonSchema{
+		Title:      t.Name,
+		Type:       schemaType{"object"},
+		Properties: make(map[string]*jsonSchema, len(t.Columns)),
+	}
+	for _, c := range t.Columns {
+		property := c.Schema
+		if t.writeOnly[c.Name] || c == t.owner || t.hiddenRefs[c.Name] {
+			marked := *c.Schema
+			marked.WriteOnly = t.writeOnly[c.Name]
+			marked.ReadOnly = marked.ReadOnly || c == t.owner
+			if t.hiddenRefs[c.Name] {
+				marked.References = nil
+			}
+			property = &marked
+		}
+		s.Properties[c.Name] = property
+	}
+	return s
+}
+
+// value checks a value from the request body against the schema and converts it for the query,
+// json numbers come as json.Number, form values as strings, so do booleans of them
+func (s *jsonSchema) value(name string, raw interface{}) (interface{}, error) {
+	invalid := fmt.Errorf("field %s have invalid type", name)
+	if raw == nil {
+		if !s.Type.has("null") {
+			return nil, invalid
+		}
+		return nil, nil
+	}
+
+	switch {
+	case s.Type.has("integer"):
+		var v int64
+		var err error
+		switch raw := raw.(type) {
+		case json.Number:
+			v, err = raw.Int64()
+		case string:
+			v, err = strconv.ParseInt(raw, 10, 64)
+		default:
+			return nil, invalid
+		}
+		if err != nil {
+			return nil, invalid
+		}
+		return v, nil
+	case s.Type.has("number"):
+		var v float64
+		var err error
+		switch raw := raw.(type) {
+		case json.Number:
+			v, err = raw.Float64()
+		case string:
+			v, err = strconv.ParseFloat(raw, 64)
+		default:
+			return nil, invalid
+		}
+		if err != nil {
+			return nil, invalid
+		}
+		return v, nil
+	case s.Type.has("boolean"):
+		switch raw := raw.(type) {
+		case bool:
+			return raw, nil
+		case string:
+			v, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, invalid
+			}
+			return v, nil
+		}
+		return nil, invalid
+	}
+	v, ok := raw.(string)
+	if !ok {
+		return nil, invalid
+	}
+	if s.MaxLength > 0 && utf8.RuneCountInString(v) > s.MaxLength {
+		return nil, fmt.Errorf("field %s is longer than %d", name, s.MaxLength)
+	}
+	return v, nil
+}
@@ -3,14 +3,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
+	// Driver is mysql, sqlite3 or postgres
+	Driver = "mysql"
 	// DSN это соединение с базой
 	// вы можете изменить этот на тот который вам нужен
 	// docker run -p 3306:3306 -v $(PWD):/docker-entrypoint-initdb.d -e MYSQL_ROOT_PASSWORD=1234 -e MYSQL_DATABASE=golang -d mysql
@@ -18,18 +27,70 @@ var (
 	// DSN = "coursera:5QPbAUufx7@tcp(localhost:3306)/coursera?charset=utf8"
 )
 
+// DB_DRIVER and DB_DSN switch the database without editing the code,
+// DB_DRIVER=sqlite3 alone runs on a file in the temp dir, no server needed
+func init() {
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		return
+	}
+	Driver = driver
+	DSN = os.Getenv("DB_DSN")
+	if DSN == "" && Driver == "sqlite3" {
+		DSN = "file:" + filepath.Join(os.TempDir(), "hw6_db_explorer.db") + "?_foreign_keys=1"
+	}
+}
+
 func main() {
-	db, err := sql.Open("mysql", DSN)
+	db, err := sql.Open(Driver, DSN)
 	err = db.Ping() // вот тут будет первое подключение к базе
 	if err != nil {
 		panic(err)
 	}
 
-	handler, err := NewDbExplorer(db)
+	// DB_POLICY is a json file of the access policy, policy_example.json is the one for sample_db.sql
+	var options []Option
+	if path := os.Getenv("DB_POLICY"); path != "" {
+		policy, err := LoadPolicy(path)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, WithPolicy(policy))
+	}
+
+	// DB_CONCURRENCY is optimistic locking of tables, like {"items": {"version_column": "version", "require_if_match": true}}
+	if settings := os.Getenv("DB_CONCURRENCY"); settings != "" {
+		concurrency := map[string]*Concurrency{}
+		if err := json.Unmarshal([]byte(settings), &concurrency); err != nil {
+			panic(err)
+		}
+		options = append(options, WithConcurrency(concurrency))
+	}
+
+	// DB_AUDIT_LOG is the file every write is logged to, GET /_changes serves it
+	if path := os.Getenv("DB_AUDIT_LOG"); path != "" {
+		auditLog, err := OpenAuditLog(path)
+		if err != nil {
+			panic(err)
+		}
+		defer auditLog.Close()
+		options = append(options, WithAuditLog(auditLog))
+	}
+
+	handler, err := NewDbExplorer(db, options...)
 	if err != nil {
 		panic(err)
 	}
 
+	// DB_SCHEMA_REFRESH is how often the schema is reloaded, like 1m, POST /_admin/reload does it at once
+	if refresh := os.Getenv("DB_SCHEMA_REFRESH"); refresh != "" {
+		interval, err := time.ParseDuration(refresh)
+		if err != nil {
+			panic(err)
+		}
+		go handler.RefreshSchema(context.Background(), interval)
+	}
+
 	fmt.Println("starting server at :8082")
 	http.ListenAndServe(":8082", handler)
 }
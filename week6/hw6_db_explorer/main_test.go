@@ -6,14 +6,18 @@ import (
 	"reflect"
 	"testing"
 
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"time"
-
-	_ "github.com/go-sql-driver/mysql"
 )
 
 // CaseResponse
@@ -26,31 +30,52 @@ type Case struct {
 	Status int
 	Result interface{}
 	Body   interface{}
+	Header http.Header
 }
 
 var (
 	client = &http.Client{Timeout: time.Second}
 )
 
-func PrepareTestApis(db *sql.DB) {
-	qs := []string{
-		`DROP TABLE IF EXISTS items;`,
-
-		`CREATE TABLE items (
+// createTestTables are tables of the test in the dialect of each driver
+func createTestTables(driver string) (items, users string) {
+	switch driver {
+	case "sqlite3":
+		return `CREATE TABLE items (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`, `CREATE TABLE users (
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`
+	case "postgres":
+		return `CREATE TABLE items (
+  id SERIAL PRIMARY KEY,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`, `CREATE TABLE users (
+  user_id SERIAL PRIMARY KEY,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`
+	}
+	return `CREATE TABLE items (
   id int(11) NOT NULL AUTO_INCREMENT,
   title varchar(255) NOT NULL,
   description text NOT NULL,
   updated varchar(255) DEFAULT NULL,
   PRIMARY KEY (id)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
-
-		`INSERT INTO items (id, title, description, updated) VALUES
-(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
-(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
-
-		`DROP TABLE IF EXISTS users;`,
-
-		`CREATE TABLE users (
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`, `CREATE TABLE users (
 			user_id int(11) NOT NULL AUTO_INCREMENT,
   login varchar(255) NOT NULL,
   password varchar(255) NOT NULL,
@@ -58,11 +83,34 @@ func PrepareTestApis(db *sql.DB) {
   info text NOT NULL,
   updated varchar(255) DEFAULT NULL,
   PRIMARY KEY (user_id)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`
+}
+
+func PrepareTestApis(db *sql.DB) {
+	createItems, createUsers := createTestTables(Driver)
+	qs := []string{
+		`DROP TABLE IF EXISTS items;`,
+
+		createItems,
+
+		`INSERT INTO items (id, title, description, updated) VALUES
+(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
+(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
+
+		`DROP TABLE IF EXISTS users;`,
+
+		createUsers,
 
 		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
 (1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
 	}
+	if Driver == "postgres" {
+		// serial sequences dont see the ids inserted explicitly
+		qs = append(qs,
+			`SELECT setval('items_id_seq', (SELECT MAX(id) FROM items));`,
+			`SELECT setval('users_user_id_seq', (SELECT MAX(user_id) FROM users));`,
+		)
+	}
 
 	for _, q := range qs {
 		_, err := db.Exec(q)
@@ -86,7 +134,7 @@ func CleanupTestApis(db *sql.DB) {
 }
 
 func TestApis(t *testing.T) {
-	db, err := sql.Open("mysql", DSN)
+	db, err := sql.Open(Driver, DSN)
 	err = db.Ping()
 	if err != nil {
 		panic(err)
@@ -500,33 +548,2169 @@ func TestApis(t *testing.T) {
 	runCases(t, ts, db, cases)
 }
 
-func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
-	for idx, item := range cases {
-		var (
-			err      error
-			result   interface{}
-			expected interface{}
-			req      *http.Request
-		)
+func TestApisListParams(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
 
-		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
 
-		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
-		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
-		if db.Stats().OpenConnections != 1 {
-			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{
+			Path:  "/items",
+			Query: "fields=id,title&order_by=-id",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 2, "title": "memcache"},
+						CR{"id": 1, "title": "database/sql"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "limit=1&total=true&fields=id",
+			Result: CR{
+				"response": CR{
+					"total":   2,
+					"records": []CR{CR{"id": 1}},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "updated__isnull=true&total=true&fields=id",
+			Result: CR{
+				"response": CR{
+					"total":   1,
+					"records": []CR{CR{"id": 2}},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "id__in=1,2&title__like=mem%25&fields=title",
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"title": "memcache"}},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "id__gte=2&updated__ne=nobody&fields=id",
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:  "/users",
+			Query: "login=rvasily&fields=user_id",
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"user_id": 1}},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "_=1700000000&fields=id", // params starting with _ are not filters
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"id": 1}, CR{"id": 2}},
+				},
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "unknown=1",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field unknown",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "titel__like=db%25",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field titel",
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "id=1&id=2&fields=id", // repeated eq is IN
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"id": 1}, CR{"id": 2}},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "id__gte=1&id__gte=2&fields=id", // other repeated filters are all applied
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"id": 2}},
+				},
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "order_by=-password",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field password",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "fields=id,password",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field password",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "id__gt=abc",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field id have invalid type",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "title__between=a",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown filter title__between",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+// createRelationTables are books referencing authors in the dialect of each driver
+func createRelationTables(driver string) (authors, books string) {
+	switch driver {
+	case "sqlite3":
+		return `CREATE TABLE authors (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name varchar(255) NOT NULL
+);`, `CREATE TABLE books (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  author_id INTEGER DEFAULT NULL REFERENCES authors (id)
+);`
+	case "postgres":
+		return `CREATE TABLE authors (
+  id SERIAL PRIMARY KEY,
+  name varchar(255) NOT NULL
+);`, `CREATE TABLE books (
+  id SERIAL PRIMARY KEY,
+  title varchar(255) NOT NULL,
+  author_id integer DEFAULT NULL REFERENCES authors (id)
+);`
+	}
+	return `CREATE TABLE authors (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  name varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`, `CREATE TABLE books (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  title varchar(255) NOT NULL,
+  author_id int(11) DEFAULT NULL,
+  PRIMARY KEY (id),
+  FOREIGN KEY (author_id) REFERENCES authors (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`
+}
+
+func PrepareTestRelations(db *sql.DB) {
+	createAuthors, createBooks := createRelationTables(Driver)
+	qs := []string{
+		`DROP TABLE IF EXISTS books;`,
+		`DROP TABLE IF EXISTS authors;`,
+		createAuthors,
+		createBooks,
+		`INSERT INTO authors (name) VALUES ('Tolkien'), ('Pratchett');`,
+		`INSERT INTO books (title, author_id) VALUES ('The Lord of the Rings', 1), ('The Hobbit', 1), ('Notes', NULL);`,
+	}
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
 		}
+	}
+}
 
-		if item.Method == "" || item.Method == http.MethodGet {
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
-		} else {
-			data, err := json.Marshal(item.Body)
-			if err != nil {
-				panic(err)
-			}
-			reqBody := bytes.NewReader(data)
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
-			req.Header.Add("Content-Type", "application/json")
+func CleanupTestRelations(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS books;`,
+		`DROP TABLE IF EXISTS authors;`,
+	}
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestApisRelations(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{
+			Path:  "/books/1",
+			Query: "expand=author",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        1,
+						"title":     "The Lord of the Rings",
+						"author_id": 1,
+						"author":    CR{"id": 1, "name": "Tolkien"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/books",
+			Query: "fields=id,author_id&expand=author",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "author_id": 1, "author": CR{"id": 1, "name": "Tolkien"}},
+						CR{"id": 2, "author_id": 1, "author": CR{"id": 1, "name": "Tolkien"}},
+						CR{"id": 3, "author_id": nil, "author": nil},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/books",
+			Query:  "fields=id&expand=author",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field author_id is needed to expand author",
+			},
+		},
+		Case{
+			Path:   "/books/1",
+			Query:  "expand=publisher",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown relation publisher",
+			},
+		},
+		Case{
+			Path:  "/authors/1/books",
+			Query: "fields=title",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"title": "The Lord of the Rings"},
+						CR{"title": "The Hobbit"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/authors/1/books",
+			Query: "fields=title&title__like=The+H%25&total=true",
+			Result: CR{
+				"response": CR{
+					"total":   1,
+					"records": []CR{CR{"title": "The Hobbit"}},
+				},
+			},
+		},
+		Case{
+			Path: "/authors/2/books",
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:   "/authors/9/books",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:   "/authors/1/unknown",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown relation",
+			},
+		},
+		Case{
+			Path:   "/authors/1",
+			Method: http.MethodDelete,
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "record is referenced",
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPut,
+			Body: CR{
+				"title":     "Unknown",
+				"author_id": 42,
+			},
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "foreign key constraint failed",
+			},
+		},
+		Case{
+			Path:   "/books/3",
+			Method: http.MethodPost,
+			Body: CR{
+				"author_id": 2,
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/authors/2",
+			Method: http.MethodDelete,
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "record is referenced",
+			},
+		},
+		Case{
+			Path:   "/books/3",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/authors/2",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestApisDeleteRules(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+	qs := []string{
+		`DROP TABLE IF EXISTS reviews;`,
+		`CREATE TABLE reviews (
+  id integer NOT NULL,
+  book_id integer,
+  PRIMARY KEY (id),
+  FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE
+);`,
+		`INSERT INTO reviews (id, book_id) VALUES (1, 3), (2, 3), (3, 1);`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			panic(err)
+		}
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS reviews;`)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{
+			Path:   "/books/3",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+		Case{
+			Path: "/reviews",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 3, "book_id": 1},
+					},
+				},
+			},
+		},
+		// books of the author restrict, reviews of the books dont
+		Case{
+			Path:   "/authors/1",
+			Method: http.MethodDelete,
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "record is referenced",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// records of other owners are not checked, so their references dont show
+	limited, err := NewDbExplorer(db, WithPolicy(&Policy{
+		Tokens: map[string]*Principal{
+			"pratchett-key": &Principal{ID: "2", Roles: []string{"author"}},
+		},
+		Tables: map[string]*TablePolicy{
+			"authors": &TablePolicy{
+				Allow:       map[string][]string{"*": []string{"read", "delete"}},
+				OwnerColumn: "id",
+			},
+		},
+	}))
+	if err != nil {
+		panic(err)
+	}
+	ts = httptest.NewServer(limited)
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/authors/1",
+			Method: http.MethodDelete,
+			Header: http.Header{"X-Api-Key": []string{"pratchett-key"}},
+			Result: CR{
+				"response": CR{
+					"deleted": 0,
+				},
+			},
+		},
+	})
+}
+
+func TestApisSchema(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	bookSchema := CR{
+		"title": "books",
+		"type":  "object",
+		"properties": CR{
+			"id":    CR{"type": "integer", "readOnly": true, "x-primaryKey": true},
+			"title": CR{"type": "string", "maxLength": 255},
+			"author_id": CR{
+				"type":         []string{"integer", "null"},
+				"x-references": CR{"table": "authors", "column": "id", "relation": "author"},
+			},
+		},
+	}
+	authorSchema := CR{
+		"title": "authors",
+		"type":  "object",
+		"properties": CR{
+			"id":   CR{"type": "integer", "readOnly": true, "x-primaryKey": true},
+			"name": CR{"type": "string", "maxLength": 255},
+		},
+	}
+
+	// types of columns are up to the database, so x-dbType is not compared
+	checkSchema := func(path string, expected CR) {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Response map[string]interface{}
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("[%s] cant unpack json: %v", path, err)
+		}
+		removeKey(result.Response, "x-dbType")
+
+		var want map[string]interface{}
+		data, _ := json.Marshal(expected)
+		json.Unmarshal(data, &want)
+		if !reflect.DeepEqual(result.Response, want) {
+			t.Fatalf("[%s] results not match\nGot : %#v\nWant: %#v", path, result.Response, want)
+		}
+	}
+
+	bookSchema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	checkSchema("/books/_schema", bookSchema)
+	delete(bookSchema, "$schema")
+	checkSchema("/_schema", CR{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"definitions": CR{
+			"authors": authorSchema,
+			"books":   bookSchema,
+		},
+	})
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/books/_schema",
+			Method: http.MethodPost,
+			Status: http.StatusMethodNotAllowed,
+			Result: CR{
+				"error": "bad method",
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPut,
+			Body: CR{
+				"title": string(bytes.Repeat([]byte("я"), 256)),
+			},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field title is longer than 255",
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPut,
+			Body: CR{
+				"title": string(bytes.Repeat([]byte("я"), 255)),
+			},
+			Result: CR{
+				"response": CR{
+					"id": 4,
+				},
+			},
+		},
+		// not null fields are not required, missing ones get zero values
+		Case{
+			Path:   "/authors",
+			Method: http.MethodPut,
+			Body:   CR{},
+			Result: CR{
+				"response": CR{
+					"id": 3,
+				},
+			},
+		},
+		Case{
+			Path: "/authors/3",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 3, "name": ""},
+				},
+			},
+		},
+	})
+
+	// references dont name tables the principal cant read
+	limited, err := NewDbExplorer(db, WithPolicy(&Policy{
+		Tables: map[string]*TablePolicy{
+			"books": &TablePolicy{Allow: map[string][]string{"*": []string{"read"}}},
+		},
+	}))
+	if err != nil {
+		panic(err)
+	}
+	ts = httptest.NewServer(limited)
+	delete(bookSchema["properties"].(CR)["author_id"].(CR), "x-references")
+	checkSchema("/_schema", CR{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"definitions": CR{
+			"books": bookSchema,
+		},
+	})
+}
+
+func TestApisBatch(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{
+			Path:   "/books",
+			Method: http.MethodPut,
+			Body: []interface{}{
+				CR{"title": "Mort", "author_id": 2},
+				CR{"title": "Untitled"},
+			},
+			Result: CR{
+				"response": CR{
+					"results": []CR{
+						CR{"id": 4},
+						CR{"id": 5},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPut,
+			Body: []interface{}{
+				CR{"title": "Good"},
+				CR{"title": 1},
+				"not a record",
+			},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "batch failed",
+				"errors": []CR{
+					CR{"index": 1, "field": "title", "error": "field title have invalid type"},
+					CR{"index": 2, "error": "record must be an object"},
+				},
+			},
+		},
+		Case{
+			Path:  "/books",
+			Query: "fields=id&total=true&limit=0",
+			Result: CR{
+				"response": CR{
+					"total":   5,
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPost,
+			Body: []interface{}{
+				CR{"op": "update", "id": 4, "record": CR{"title": "Mort!"}},
+				CR{"op": "delete", "id": 5},
+			},
+			Result: CR{
+				"response": CR{
+					"results": []CR{
+						CR{"updated": 1},
+						CR{"deleted": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/authors",
+			Method: http.MethodPost,
+			Body: []interface{}{
+				CR{"op": "update", "id": 2, "record": CR{"name": "Terry Pratchett"}},
+				CR{"op": "delete", "id": 1},
+			},
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "batch failed",
+				"errors": []CR{
+					CR{"index": 1, "error": "record is referenced"},
+				},
+			},
+		},
+		Case{
+			Path: "/authors/2", // the update before the failed delete is rolled back
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 2, "name": "Pratchett"},
+				},
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPost,
+			Body: []interface{}{
+				CR{"op": "merge", "id": 1},
+				CR{"op": "delete", "id": "x"},
+				CR{"op": "update", "id": 1},
+				CR{"op": "update", "id": 1, "record": CR{"id": 2}},
+			},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "batch failed",
+				"errors": []CR{
+					CR{"index": 0, "field": "op", "error": "field op must be update or delete"},
+					CR{"index": 1, "field": "id", "error": "field id have invalid type"},
+					CR{"index": 2, "field": "record", "error": "field record must be an object"},
+					CR{"index": 3, "field": "id", "error": "field id have invalid type"},
+				},
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPost,
+			Body: []interface{}{
+				CR{"op": "update", "id": 1, "record": CR{"title": "The Fellowship of the Ring"}},
+				CR{"op": "update", "id": 2, "record": CR{"author_id": 42}},
+			},
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "batch failed",
+				"errors": []CR{
+					CR{"index": 1, "error": "foreign key constraint failed"},
+				},
+			},
+		},
+		// items are keyed by the name of the primary key
+		Case{
+			Path:   "/users",
+			Method: http.MethodPost,
+			Body: []interface{}{
+				CR{"op": "update", "user_id": 1, "record": CR{"info": "batch"}},
+				CR{"op": "update", "id": 1, "record": CR{"info": "batch"}},
+			},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "batch failed",
+				"errors": []CR{
+					CR{"index": 1, "field": "user_id", "error": "field user_id is required"},
+				},
+			},
+		},
+		Case{
+			Path:   "/users",
+			Method: http.MethodPost,
+			Body: []interface{}{
+				CR{"op": "update", "user_id": 1, "record": CR{"info": "batch"}},
+			},
+			Result: CR{
+				"response": CR{
+					"results": []CR{
+						CR{"updated": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPost,
+			Body:   CR{"op": "delete", "id": 1},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "batch must be an array",
+			},
+		},
+		Case{
+			Path:   "/books",
+			Method: http.MethodPut,
+			Body:   []interface{}{},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "batch is empty",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestApisPolicy(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+
+	policy, err := LoadPolicy("policy_example.json")
+	if err != nil {
+		panic(err)
+	}
+	handler, err := NewDbExplorer(db, WithPolicy(policy))
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	admin := http.Header{"X-Api-Key": []string{"admin-key"}}
+	rvasily := http.Header{"Authorization": []string{"Bearer rvasily-key"}}
+
+	cases := []Case{
+		Case{
+			Path: "/",
+			Result: CR{
+				"response": CR{
+					"tables": []string{"items"},
+				},
+			},
+		},
+		Case{
+			Path:   "/users",
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error":     "access denied",
+				"table":     "users",
+				"operation": "read",
+			},
+		},
+		Case{
+			Path:   "/users",
+			Header: http.Header{"X-Api-Key": []string{"stolen-key"}},
+			Status: http.StatusUnauthorized,
+			Result: CR{
+				"error": "invalid token",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Method: http.MethodPut,
+			Body:   CR{"title": "anonymous"},
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error":     "access denied",
+				"table":     "items",
+				"operation": "create",
+			},
+		},
+		Case{
+			Path:   "/users",
+			Method: http.MethodPut,
+			Header: admin,
+			Body: CR{
+				"login":    "ivan",
+				"password": "secret",
+				"info":     "hidden columns are not written",
+			},
+			Result: CR{
+				"response": CR{
+					"user_id": 2,
+				},
+			},
+		},
+		Case{
+			Path:   "/users",
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"user_id": 1, "login": "rvasily", "email": "rvasily@example.com", "updated": nil},
+						CR{"user_id": 2, "login": "ivan", "email": "", "updated": nil},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/users",
+			Query:  "total=true",
+			Header: rvasily,
+			Result: CR{
+				"response": CR{
+					"total": 1,
+					"records": []CR{
+						CR{"user_id": 1, "login": "rvasily", "email": "rvasily@example.com", "updated": nil},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/users/2",
+			Header: rvasily,
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:   "/users",
+			Query:  "password=love",
+			Header: rvasily,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field password",
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body: CR{
+				"password": "new love",
+				"info":     "ignored",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/users/2",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body: CR{
+				"login": "hacked",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 0,
+				},
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodDelete,
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error":     "access denied",
+				"table":     "users",
+				"operation": "delete",
+			},
+		},
+		Case{
+			Path:   "/users",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body: []interface{}{
+				CR{"op": "update", "user_id": 1, "record": CR{"login": "rvasily"}},
+				CR{"op": "delete", "user_id": 2},
+			},
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error":     "access denied",
+				"table":     "users",
+				"operation": "delete",
+			},
+		},
+		Case{
+			Path:   "/users/2",
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"record": CR{"user_id": 2, "login": "ivan", "email": "", "updated": nil},
+				},
+			},
+		},
+		// records of other owners conflict on imports whatever the mode is
+		Case{
+			Path:   "/users/_import?format=ndjson&conflict=skip",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body:   CR{"user_id": 1, "login": "rvasily", "password": "love"},
+			Result: CR{
+				"response": CR{"inserted": 0, "skipped": 1, "updated": 0},
+			},
+		},
+		Case{
+			Path:   "/users/_import?format=ndjson&conflict=skip",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body:   CR{"user_id": 2, "login": "ivan", "password": "secret"},
+			Status: http.StatusConflict,
+			Result: CR{
+				"error":  "batch failed",
+				"errors": []CR{CR{"index": 0, "error": "record 2 already exists"}},
+			},
+		},
+		Case{
+			Path:   "/users/_import?format=ndjson&conflict=overwrite",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body:   CR{"user_id": 2, "login": "hacked"},
+			Status: http.StatusConflict,
+			Result: CR{
+				"error":  "batch failed",
+				"errors": []CR{CR{"index": 0, "error": "record 2 already exists"}},
+			},
+		},
+		// the owner column is the primary key, the record of a principal is created with its id
+		Case{
+			Path:   "/users",
+			Method: http.MethodPut,
+			Header: http.Header{"X-Api-Key": []string{"newcomer-key"}},
+			Body: CR{
+				"user_id":  42,
+				"login":    "newcomer",
+				"password": "secret",
+			},
+			Result: CR{
+				"response": CR{
+					"user_id": 3,
+				},
+			},
+		},
+		Case{
+			Path:   "/users/3",
+			Header: http.Header{"X-Api-Key": []string{"newcomer-key"}},
+			Result: CR{
+				"response": CR{
+					"record": CR{"user_id": 3, "login": "newcomer", "email": "", "updated": nil},
+				},
+			},
+		},
+		Case{
+			Path:   "/_admin/reload",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error":     "access denied",
+				"operation": "admin",
+			},
+		},
+		Case{
+			Path:   "/_admin/reload",
+			Method: http.MethodPost,
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"changes": []string{},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	var password, info string
+	err = db.QueryRow("SELECT password, info FROM users WHERE user_id = 1").Scan(&password, &info)
+	if err != nil {
+		t.Fatalf("cant read user: %v", err)
+	}
+	if password != "new love" || info != "none" {
+		t.Fatalf("write-only column must be written and hidden one must not, got password %q, info %q", password, info)
+	}
+}
+
+func TestApisTransfer(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	transferCases := []struct {
+		Method      string
+		Path        string
+		ContentType string
+		Body        string
+		Status      int
+		// Result is compared as text for exports and as json otherwise
+		Result string
+	}{
+		{
+			Path:   "/books/_export?format=csv",
+			Status: http.StatusOK,
+			Result: "id,title,author_id\n1,The Lord of the Rings,1\n2,The Hobbit,1\n3,Notes,\\N\n",
+		},
+		{
+			Path:   "/books/_export?format=ndjson&author_id=1&fields=id,title&order_by=-id",
+			Status: http.StatusOK,
+			Result: `{"id":2,"title":"The Hobbit"}` + "\n" + `{"id":1,"title":"The Lord of the Rings"}` + "\n",
+		},
+		{
+			Path:   "/books/_export?format=xml",
+			Status: http.StatusBadRequest,
+			Result: `{"error":"format must be csv or ndjson"}`,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/books/_import",
+			ContentType: "text/csv",
+			Body:        "id,title,author_id\n,Mort,2\n3,Notes revised,\\N\n",
+			Status:      http.StatusConflict,
+			Result:      `{"error":"batch failed","errors":[{"index":1,"error":"record 3 already exists"}]}`,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/books/_import?conflict=overwrite",
+			ContentType: "text/csv",
+			Body:        "id,title,author_id\n,Mort,2\n3,Notes revised,\\N\n",
+			Status:      http.StatusOK,
+			Result:      `{"response":{"inserted":1,"skipped":0,"updated":1}}`,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/books/_import?conflict=skip",
+			ContentType: "application/x-ndjson",
+			Body:        `{"id":1,"title":"Silmarillion"}` + "\n" + `{"id":100,"title":"Guards! Guards!","author_id":2}` + "\n",
+			Status:      http.StatusOK,
+			Result:      `{"response":{"inserted":1,"skipped":1,"updated":0}}`,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/books/_import",
+			ContentType: "application/x-ndjson",
+			Body:        `{"id":200,"title":"\\N"}` + "\n",
+			Status:      http.StatusOK,
+			Result:      `{"response":{"inserted":1,"skipped":0,"updated":0}}`,
+		},
+		{
+			Path:   "/books/_export?format=csv&id=200",
+			Status: http.StatusOK,
+			Result: "id,title,author_id\n200,\\\\N,\\N\n",
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/books/_import?conflict=overwrite",
+			ContentType: "text/csv",
+			Body:        "id,title,author_id\n200,\\\\N,\\N\n",
+			Status:      http.StatusOK,
+			Result:      `{"response":{"inserted":0,"skipped":0,"updated":1}}`,
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/books/_import?format=ndjson",
+			Body:   `{"title":"Eric"}` + "\n" + `{"title":1}` + "\n" + `{"title":"Sourcery","author_id":"x"}` + "\n",
+			Status: http.StatusBadRequest,
+			Result: `{"error":"batch failed","errors":[{"index":1,"field":"title","error":"field title have invalid type"},{"index":2,"field":"author_id","error":"field author_id have invalid type"}]}`,
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/books/_import?format=csv&conflict=replace",
+			Status: http.StatusBadRequest,
+			Result: `{"error":"conflict must be skip, overwrite or fail"}`,
+		},
+	}
+	for idx, item := range transferCases {
+		caseName := fmt.Sprintf("case %d: [%s] %s", idx, item.Method, item.Path)
+		req, err := http.NewRequest(item.Method, ts.URL+item.Path, bytes.NewBufferString(item.Body))
+		if err != nil {
+			panic(err)
+		}
+		if item.ContentType != "" {
+			req.Header.Set("Content-Type", item.ContentType)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", caseName, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != item.Status {
+			t.Fatalf("[%s] expected http status %v, got %v: %s", caseName, item.Status, resp.StatusCode, body)
+		}
+		if string(body) != item.Result && string(bytes.TrimSpace(body)) != item.Result {
+			t.Fatalf("[%s] results not match\nGot : %s\nWant: %s", caseName, body, item.Result)
+		}
+	}
+
+	cases := []Case{
+		Case{
+			Path:  "/books",
+			Query: "fields=title,author_id&order_by=title&limit=10&id__lt=200",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"title": "Guards! Guards!", "author_id": 2},
+						CR{"title": "Mort", "author_id": 2},
+						CR{"title": "Notes revised", "author_id": nil},
+						CR{"title": "The Hobbit", "author_id": 1},
+						CR{"title": "The Lord of the Rings", "author_id": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path: "/books/200",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 200, "title": `\N`, "author_id": nil},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// imports keep versions of new records and increment the ones of overwritten records
+	if _, err := db.Exec(`ALTER TABLE authors ADD COLUMN version integer NOT NULL DEFAULT 1;`); err != nil {
+		panic(err)
+	}
+	handler, err = NewDbExplorer(db, WithConcurrency(map[string]*Concurrency{
+		"authors": &Concurrency{VersionColumn: "version"},
+	}))
+	if err != nil {
+		panic(err)
+	}
+	ts = httptest.NewServer(handler)
+	body := `{"id":1,"name":"J. R. R. Tolkien","version":7}` + "\n" + `{"id":7,"name":"Gaiman","version":3}` + "\n"
+	resp, err := client.Post(ts.URL+"/authors/_import?conflict=overwrite", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("[import versions] request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("[import versions] expected http status 200, got %v", resp.StatusCode)
+	}
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:  "/authors",
+			Query: "fields=id,version",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "version": 2, "_etag": `"2"`},
+						CR{"id": 2, "version": 1, "_etag": `"1"`},
+						CR{"id": 7, "version": 3, "_etag": `"3"`},
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestApisSchemaReload(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+	defer db.Exec(`DROP TABLE IF EXISTS reviews;`)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/reviews",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+			},
+		},
+	})
+
+	// the migration runs while the explorer is up
+	qs := []string{
+		`CREATE TABLE reviews (id integer PRIMARY KEY, text varchar(255) NOT NULL);`,
+		`ALTER TABLE books ADD COLUMN isbn varchar(20) DEFAULT NULL;`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			panic(err)
+		}
+	}
+
+	cases := []Case{
+		Case{
+			Path:   "/_admin/reload",
+			Status: http.StatusMethodNotAllowed,
+			Result: CR{
+				"error": "bad method",
+			},
+		},
+		Case{
+			Path:   "/_admin/reload",
+			Method: http.MethodPost,
+			Result: CR{
+				"response": CR{
+					"changes": []string{
+						"table reviews added",
+						"column books.isbn added",
+					},
+				},
+			},
+		},
+		Case{
+			Path: "/books/3",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 3, "title": "Notes", "author_id": nil, "isbn": nil},
+				},
+			},
+		},
+		Case{
+			Path: "/reviews",
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:   "/_admin/reload",
+			Method: http.MethodPost,
+			Result: CR{
+				"response": CR{
+					"changes": []string{},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestApisSchemaReloadStale(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	qs := []string{
+		`DROP TABLE IF EXISTS notes;`,
+		`CREATE TABLE notes (id integer NOT NULL, owner_id integer, body varchar(255), version integer NOT NULL DEFAULT 1, PRIMARY KEY (id));`,
+		`INSERT INTO notes (id, owner_id, body) VALUES (1, 1, 'mine'), (2, 2, 'theirs');`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			panic(err)
+		}
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS notes;`)
+
+	policy := &Policy{
+		Tokens: map[string]*Principal{
+			"admin-key": &Principal{ID: "0", Roles: []string{"admin"}},
+			"owner-key": &Principal{ID: "1", Roles: []string{"user"}},
+		},
+		Tables: map[string]*TablePolicy{
+			"notes": &TablePolicy{
+				Allow:       map[string][]string{"*": []string{"read"}},
+				Hidden:      []string{"body"},
+				OwnerColumn: "owner_id",
+				AllRows:     []string{"admin"},
+			},
+		},
+		AdminRoles: []string{"admin"},
+	}
+	handler, err := NewDbExplorer(db, WithPolicy(policy), WithConcurrency(map[string]*Concurrency{
+		"notes": &Concurrency{VersionColumn: "version"},
+	}))
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	admin := http.Header{"X-Api-Key": []string{"admin-key"}}
+	owner := http.Header{"X-Api-Key": []string{"owner-key"}}
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/notes",
+			Header: owner,
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "owner_id": 1, "version": 1, "_etag": `"1"`},
+					},
+				},
+			},
+		},
+	})
+
+	// columns the policy and the version of the table refer to are dropped by a migration
+	for _, name := range []string{"owner_id", "body", "version"} {
+		if _, err := db.Exec(`ALTER TABLE notes DROP COLUMN ` + name); err != nil {
+			panic(err)
+		}
+	}
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/_admin/reload",
+			Method: http.MethodPost,
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"changes": []string{
+						"column notes.owner_id dropped",
+						"column notes.body dropped",
+						"column notes.version dropped",
+						"policy of table notes: unknown column body, ignored",
+						"policy of table notes: unknown owner column owner_id, ignored",
+						"unknown version column version of table notes, ignored",
+					},
+				},
+			},
+		},
+		// rows cant be limited to the owner any more, so there are none
+		Case{
+			Path:   "/notes",
+			Header: owner,
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:   "/notes",
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1},
+						CR{"id": 2},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/_admin/reload",
+			Method: http.MethodPost,
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"changes": []string{},
+				},
+			},
+		},
+	})
+}
+
+func TestApisConcurrency(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+	if _, err := db.Exec(`ALTER TABLE books ADD COLUMN version integer NOT NULL DEFAULT 1;`); err != nil {
+		panic(err)
+	}
+
+	handler, err := NewDbExplorer(db, WithConcurrency(map[string]*Concurrency{
+		"authors": &Concurrency{},
+		"books":   &Concurrency{VersionColumn: "version", RequireIfMatch: true},
+	}))
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	steps := []struct {
+		Method string
+		Path   string
+		// IfMatch of $name is the ETag saved by an earlier step
+		IfMatch string
+		Body    interface{}
+		Status  int
+		Result  interface{}
+		// ETag is the expected one, SaveETag saves the received one by the name
+		ETag     string
+		SaveETag string
+	}{
+		{
+			Path:     "/authors/1",
+			Status:   http.StatusOK,
+			Result:   CR{"response": CR{"record": CR{"id": 1, "name": "Tolkien"}}},
+			SaveETag: "tolkien",
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/authors/1",
+			IfMatch: `"wrong"`,
+			Body:    CR{"name": "J. R. R. Tolkien"},
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed"},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/authors/1",
+			IfMatch:  "$tolkien",
+			Body:     CR{"name": "J. R. R. Tolkien"},
+			Status:   http.StatusOK,
+			Result:   CR{"response": CR{"updated": 1}},
+			SaveETag: "renamed",
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/authors/1", // the record changed since the first GET
+			IfMatch: "$tolkien",
+			Body:    CR{"name": "Tolkien"},
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed"},
+		},
+		{
+			Path:   "/authors/1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "name": "J. R. R. Tolkien"}}},
+			ETag:   "$renamed",
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/authors/2", // authors dont require If-Match
+			Body:   CR{"name": "Terry Pratchett"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		{
+			Path:   "/books/1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "title": "The Lord of the Rings", "author_id": 1, "version": 1}}},
+			ETag:   `"1"`,
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/books/1",
+			Body:   CR{"title": "LOTR"},
+			Status: http.StatusPreconditionRequired,
+			Result: CR{"error": "If-Match is required"},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/books/1",
+			IfMatch: `"1"`,
+			Body:    CR{"title": "LOTR", "version": 10}, // the version is managed by the explorer
+			Status:  http.StatusOK,
+			Result:  CR{"response": CR{"updated": 1}},
+			ETag:    `"2"`,
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/books/2",
+			IfMatch: `"2"`,
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed"},
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/books/100",
+			IfMatch: `"1"`,
+			Status:  http.StatusNotFound,
+			Result:  CR{"error": "record not found"},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/books",
+			Body:   CR{"title": "Mort", "version": 5},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"id": 4, "_etag": `"1"`}},
+		},
+		{
+			Path:   "/books?fields=title&id__gte=3",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				CR{"title": "Notes", "_etag": `"1"`},
+				CR{"title": "Mort", "_etag": `"1"`},
+			}}},
+		},
+		{
+			Path:   "/books/4",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 4, "title": "Mort", "author_id": nil, "version": 1}}},
+			ETag:   `"1"`,
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/books",
+			Body:   []CR{CR{"op": "delete", "id": 2}},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":  "batch failed",
+				"errors": []CR{CR{"index": 0, "field": "if_match", "error": "field if_match is required"}},
+			},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/books",
+			Body: []CR{
+				CR{"op": "update", "id": 1, "record": CR{"title": "The Lord of the Rings"}, "if_match": `"2"`},
+				CR{"op": "delete", "id": 2, "if_match": `"2"`},
+			},
+			Status: http.StatusPreconditionFailed,
+			Result: CR{
+				"error":  "batch failed",
+				"errors": []CR{CR{"index": 1, "error": "precondition failed"}},
+			},
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/books/2",
+			IfMatch: "*",
+			Status:  http.StatusOK,
+			Result:  CR{"response": CR{"deleted": 1}},
+		},
+		{
+			Path:   "/books/1", // the update of the failed batch is rolled back
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "title": "LOTR", "author_id": 1, "version": 2}}},
+			ETag:   `"2"`,
+		},
+	}
+
+	etags := map[string]string{}
+	for idx, step := range steps {
+		caseName := fmt.Sprintf("case %d: [%s] %s", idx, step.Method, step.Path)
+		var reqBody []byte
+		if step.Body != nil {
+			reqBody, _ = json.Marshal(step.Body)
+		}
+		req, err := http.NewRequest(step.Method, ts.URL+step.Path, bytes.NewReader(reqBody))
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if strings.HasPrefix(step.IfMatch, "$") {
+			req.Header.Set("If-Match", etags[step.IfMatch[1:]])
+		} else if step.IfMatch != "" {
+			req.Header.Set("If-Match", step.IfMatch)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", caseName, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != step.Status {
+			t.Fatalf("[%s] expected http status %v, got %v: %s", caseName, step.Status, resp.StatusCode, body)
+		}
+
+		etag := resp.Header.Get("ETag")
+		expectedETag := step.ETag
+		if strings.HasPrefix(expectedETag, "$") {
+			expectedETag = etags[expectedETag[1:]]
+		}
+		if expectedETag != "" && etag != expectedETag {
+			t.Fatalf("[%s] expected ETag %s, got %s", caseName, expectedETag, etag)
+		}
+		if step.SaveETag != "" {
+			if etag == "" {
+				t.Fatalf("[%s] no ETag", caseName)
+			}
+			etags[step.SaveETag] = etag
+		}
+
+		var result, expected interface{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatalf("[%s] cant unpack json: %v", caseName, err)
+		}
+		data, _ := json.Marshal(step.Result)
+		json.Unmarshal(data, &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("[%s] results not match\nGot : %#v\nWant: %#v", caseName, result, expected)
+		}
+	}
+	if etags["tolkien"] == etags["renamed"] {
+		t.Fatalf("ETag must change with the record")
+	}
+
+	// ETags of lists are the ones of records whatever fields are returned
+	resp, err := client.Get(ts.URL + "/authors?fields=name&id=1")
+	if err != nil {
+		t.Fatalf("[list etags] request error: %v", err)
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Response struct {
+			Records []map[string]interface{}
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("[list etags] cant unpack json: %v", err)
+	}
+	if len(list.Response.Records) != 1 || list.Response.Records[0]["_etag"] != etags["renamed"] {
+		t.Fatalf("[list etags] expected _etag %s, got %v", etags["renamed"], list.Response.Records)
+	}
+}
+
+func TestApisConcurrencyHidden(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+
+	policy, err := LoadPolicy("policy_example.json")
+	if err != nil {
+		panic(err)
+	}
+	options := []Option{
+		WithPolicy(policy),
+		WithConcurrency(map[string]*Concurrency{"users": &Concurrency{}}),
+		WithETagKey([]byte("etag key")),
+	}
+	handler, err := NewDbExplorer(db, options...)
+	if err != nil {
+		panic(err)
+	}
+	ts := httptest.NewServer(handler)
+	// explorers with the same key send the same ETags
+	other, err := NewDbExplorer(db, options...)
+	if err != nil {
+		panic(err)
+	}
+	otherTS := httptest.NewServer(other)
+
+	get := func(url string) (string, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, url+"/users/1", nil)
+		req.Header.Set("X-Api-Key", "admin-key")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Response struct {
+				Record json.RawMessage
+			}
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("cant unpack json: %v", err)
+		}
+		return resp.Header.Get("ETag"), result.Response.Record
+	}
+
+	before, record := get(ts.URL)
+	if other, _ := get(otherTS.URL); other != before {
+		t.Fatalf("expected ETag %s of the explorer with the same key, got %s", before, other)
+	}
+	// the hash of the visible fields cant be computed by clients
+	sum := sha1.Sum(record)
+	if before == `"`+hex.EncodeToString(sum[:])+`"` {
+		t.Fatalf("ETag %s is the plain hash of the record", before)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/users/1", strings.NewReader(`{"password":"new love"}`))
+	req.Header.Set("X-Api-Key", "admin-key")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected http status 200, got %v", resp.StatusCode)
+	}
+
+	after, _ := get(ts.URL)
+	if after == before {
+		t.Fatalf("ETag must change with the write-only column")
+	}
+}
+
+func TestApisAudit(t *testing.T) {
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+
+	logFile, err := ioutil.TempFile("", "hw6_audit")
+	if err != nil {
+		panic(err)
+	}
+	logFile.Close()
+	defer os.Remove(logFile.Name())
+	auditLog, err := OpenAuditLog(logFile.Name())
+	if err != nil {
+		panic(err)
+	}
+	defer auditLog.Close()
+
+	policy, err := LoadPolicy("policy_example.json")
+	if err != nil {
+		panic(err)
+	}
+	handler, err := NewDbExplorer(db, WithPolicy(policy), WithAuditLog(auditLog))
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	admin := http.Header{"X-Api-Key": []string{"admin-key"}}
+	rvasily := http.Header{"Authorization": []string{"Bearer rvasily-key"}}
+
+	cases := []Case{
+		Case{
+			Path:   "/items",
+			Method: http.MethodPut,
+			Header: admin,
+			Body:   CR{"title": "db_crud", "description": ""},
+			Result: CR{"response": CR{"id": 3}},
+		},
+		Case{
+			Path:   "/items/3",
+			Method: http.MethodPost,
+			Header: admin,
+			Body:   CR{"updated": "admin"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body:   CR{"email": "new@example.com"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/items/3",
+			Method: http.MethodDelete,
+			Header: admin,
+			Result: CR{"response": CR{"deleted": 1}},
+		},
+		Case{
+			Path:   "/items/100", // nothing is changed, nothing is logged
+			Method: http.MethodDelete,
+			Header: admin,
+			Result: CR{"response": CR{"deleted": 0}},
+		},
+		Case{
+			Path:   "/_changes",
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error":     "access denied",
+				"operation": "admin",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	item := CR{"id": 3, "title": "db_crud", "description": "", "updated": nil}
+	updatedItem := CR{"id": 3, "title": "db_crud", "description": "", "updated": "admin"}
+	// images dont have hidden and write-only columns
+	user := CR{"user_id": 1, "login": "rvasily", "email": "rvasily@example.com", "updated": nil}
+	updatedUser := CR{"user_id": 1, "login": "rvasily", "email": "new@example.com", "updated": nil}
+	changeCases := []struct {
+		Query  string
+		Result interface{}
+	}{
+		{
+			Query: "",
+			Result: CR{"response": CR{"changes": []CR{
+				CR{"id": 1, "table": "items", "operation": "create", "key": 3, "principal": "0", "before": nil, "after": item},
+				CR{"id": 2, "table": "items", "operation": "update", "key": 3, "principal": "0", "before": item, "after": updatedItem},
+				CR{"id": 3, "table": "users", "operation": "update", "key": 1, "principal": "1", "before": user, "after": updatedUser},
+				CR{"id": 4, "table": "items", "operation": "delete", "key": 3, "principal": "0", "before": updatedItem, "after": nil},
+			}}},
+		},
+		{
+			Query: "since=2&limit=1",
+			Result: CR{"response": CR{"changes": []CR{
+				CR{"id": 3, "table": "users", "operation": "update", "key": 1, "principal": "1", "before": user, "after": updatedUser},
+			}}},
+		},
+		{
+			Query:  "since=4",
+			Result: CR{"response": CR{"changes": []CR{}}},
+		},
+	}
+	for idx, item := range changeCases {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/_changes?"+item.Query, nil)
+		req.Header.Set("X-Api-Key", "admin-key")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[changes %d] request error: %v", idx, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if bytes.Contains(body, []byte("password")) {
+			t.Fatalf("[changes %d] changes have passwords: %s", idx, body)
+		}
+		var result, expected interface{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatalf("[changes %d] cant unpack json: %v", idx, err)
+		}
+		removeKey(result, "time")
+		data, _ := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("[changes %d] results not match\nGot : %#v\nWant: %#v", idx, result, expected)
+		}
+	}
+
+	// the event stream sends changes after Last-Event-ID as they happen
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/_changes", nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Api-Key", "admin-key")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", "4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("[changes stream] request error: %v", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("[changes stream] expected text/event-stream, got %s", contentType)
+	}
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Header: admin,
+			Body:   CR{"updated": nil},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+	})
+
+	events := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("[changes stream] cant read event: %v", err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	if lines[0] != "id: 5" || lines[1] != "event: change" || !strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("[changes stream] unexpected event %q", lines)
+	}
+	var change map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &change); err != nil {
+		t.Fatalf("[changes stream] cant unpack json: %v", err)
+	}
+	if change["table"] != "items" || change["operation"] != "update" || change["key"] != float64(1) {
+		t.Fatalf("[changes stream] unexpected change %v", change)
+	}
+
+	// a line cut off by a crash is trimmed on open, ids continue after the last whole entry
+	cancel()
+	auditLog.Close()
+	file, err := os.OpenFile(logFile.Name(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		panic(err)
+	}
+	file.WriteString(`{"id":6,"table":"ite`)
+	file.Close()
+	reopened, err := OpenAuditLog(logFile.Name())
+	if err != nil {
+		t.Fatalf("[changes reopen] cant open audit log: %v", err)
+	}
+	defer reopened.Close()
+	entries, err := reopened.read(4, defaultChangesLimit)
+	if err != nil {
+		t.Fatalf("[changes reopen] cant read audit log: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != 5 || reopened.lastID != 5 {
+		t.Fatalf("[changes reopen] unexpected entries %v, last id %d", entries, reopened.lastID)
+	}
+}
+
+func TestApisTypes(t *testing.T) {
+	if Driver == "mysql" {
+		t.Skip("mysql keeps booleans as tinyint(1)")
+	}
+	db, err := sql.Open(Driver, DSN)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	qs := []string{
+		`DROP TABLE IF EXISTS flags;`,
+		`CREATE TABLE flags (id integer PRIMARY KEY, name varchar(255) NOT NULL, active boolean NOT NULL, rollout__pct integer NOT NULL DEFAULT 5);`,
+		`INSERT INTO flags (id, name, active, rollout__pct) VALUES (1, 'dark', true, 100), (12, 'beta', false, 10);`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			panic(err)
+		}
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS flags;`)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{
+			Path:   "/flags/12",
+			Method: http.MethodPost,
+			Body:   CR{"active": true},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/flags/1",
+			Method: http.MethodPost,
+			Body:   CR{"active": "yes"},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field active have invalid type"},
+		},
+		Case{
+			Path:  "/flags",
+			Query: "active=true&id__like=1%25",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "name": "dark", "active": true, "rollout__pct": 100},
+						CR{"id": 12, "name": "beta", "active": true, "rollout__pct": 10},
+					},
+				},
+			},
+		},
+		// names of columns with __ are not split into operators
+		Case{
+			Path:  "/flags",
+			Query: "fields=id&rollout__pct=10",
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"id": 12}},
+				},
+			},
+		},
+		Case{
+			Path:  "/flags",
+			Query: "fields=id&rollout__pct__gt=10",
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"id": 1}},
+				},
+			},
+		},
+		// omitted columns with defaults get them
+		Case{
+			Path:   "/flags",
+			Method: http.MethodPut,
+			Body:   CR{"id": 13, "name": "new"},
+			Result: CR{"response": CR{"id": 13}},
+		},
+		Case{
+			Path: "/flags/13",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 13, "name": "new", "active": false, "rollout__pct": 5},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+// removeKey deletes the key from all levels of a decoded json document
+func removeKey(doc interface{}, key string) {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		delete(doc, key)
+		for _, value := range doc {
+			removeKey(value, key)
+		}
+	case []interface{}:
+		for _, value := range doc {
+			removeKey(value, key)
+		}
+	}
+}
+
+func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
+	for idx, item := range cases {
+		var (
+			err      error
+			result   interface{}
+			expected interface{}
+			req      *http.Request
+		)
+
+		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+
+		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
+		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
+		if db.Stats().OpenConnections != 1 {
+			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
+		}
+
+		if item.Method == "" || item.Method == http.MethodGet {
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
+		} else {
+			data, err := json.Marshal(item.Body)
+			if err != nil {
+				panic(err)
+			}
+			reqBody := bytes.NewReader(data)
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		for name, values := range item.Header {
+			req.Header[name] = values
 		}
 
 		resp, err := client.Do(req)
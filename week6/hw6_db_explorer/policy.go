@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// operations policies allow on tables
+const (
+	opRead   = "read"
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+	// opAdmin is not a table operation, policies allow it by admin roles
+	opAdmin = "admin"
+)
+
+// roles of Allow besides the roles of principals
+const (
+	roleAny           = "*"
+	roleAnonymous     = "anonymous"
+	roleAuthenticated = "authenticated"
+)
+
+// Policy is what principals can do with tables, an explorer without a policy allows everything to everyone
+type Policy struct {
+	// Tokens are API keys of principals, sent as X-Api-Key or Authorization: Bearer
+	Tokens map[string]*Principal `json:"tokens"`
+	// Default is the policy of tables missing in Tables
+	Default TablePolicy             `json:"default"`
+	Tables  map[string]*TablePolicy `json:"tables"`
+	// AdminRoles are roles allowed /_admin requests
+	AdminRoles []string `json:"admin_roles"`
+}
+
+type TablePolicy struct {
+	// Allow maps roles to operations: read, create, update and delete,
+	// * is any request, anonymous is a request without a token and authenticated is one with it
+	Allow map[string][]string `json:"allow"`
+	// Hidden columns are neither returned nor written
+	Hidden []string `json:"hidden"`
+	// WriteOnly columns are written but never returned, like passwords
+	WriteOnly []string `json:"write_only"`
+	// OwnerColumn limits rows to the ones with the id of the principal, new records get it,
+	// anonymous requests see no rows
+	OwnerColumn string `json:"owner_column"`
+	// AllRows are roles the owner column does not limit
+	AllRows []string `json:"all_rows"`
+}
+
+type Principal struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+// Option configures NewDbExplorer
+type Option func(explorer *DbExplorer)
+
+func WithPolicy(policy *Policy) Option {
+	return func(explorer *DbExplorer) {
+		explorer.policy = policy
+	}
+}
+
+// LoadPolicy reads a policy from a json file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("cant parse policy %s: %s", path, err)
+	}
+	return policy, nil
+}
+
+// check validates the policy against introspected tables, so mistakes in it fail at start and not on requests
+// entries that dont fit are returned, views skip them: unknown tables and columns are not there anyway,
+// hidden primary keys are returned and unknown owner columns match no rows
+func (p *Policy) check(c *catalog) []string {
+	names := make([]string, 0, len(p.Tables))
+	for name := range p.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		t, exists := c.tables[name]
+		if !exists {
+			problems = append(problems, fmt.Sprintf("policy of unknown table %s", name))
+			continue
+		}
+		for _, problem := range p.Tables[name].check(t) {
+			problems = append(problems, fmt.Sprintf("policy of table %s: %s", name, problem))
+		}
+	}
+	for _, name := range c.tableNames {
+		if _, exists := p.Tables[name]; exists {
+			continue
+		}
+		for _, problem := range p.Default.check(c.tables[name]) {
+			problems = append(problems, fmt.Sprintf("default policy on table %s: %s", name, problem))
+		}
+	}
+	return problems
+}
+
+func (tp *TablePolicy) check(t *table) []string {
+	var problems []string
+	for _, ops := range tp.Allow {
+		for _, op := range ops {
+			switch op {
+			case opRead, opCreate, opUpdate, opDelete:
+			default:
+				problems = append(problems, fmt.Sprintf("unknown operation %s", op))
+			}
+		}
+	}
+	for _, names := range [][]string{tp.Hidden, tp.WriteOnly} {
+		for _, name := range names {
+			c, exists := t.columns[name]
+			if !exists {
+				problems = append(problems, fmt.Sprintf("unknown column %s", name))
+			} else if c.PrimaryKey {
+				problems = append(problems, fmt.Sprintf("primary key %s cant be hidden", name))
+			}
+		}
+	}
+	if tp.OwnerColumn != "" {
+		if _, exists := t.columns[tp.OwnerColumn]; !exists {
+			problems = append(problems, fmt.Sprintf("unknown owner column %s", tp.OwnerColumn))
+		} else if contains(tp.Hidden, tp.OwnerColumn) {
+			problems = append(problems, fmt.Sprintf("owner column %s cant be hidden", tp.OwnerColumn))
+		}
+	}
+	return problems
+}
+
+func (p *Policy) table(name string) *TablePolicy {
+	if tp, exists := p.Tables[name]; exists {
+		return tp
+	}
+	return &p.Default
+}
+
+// allows tells if any of the roles of the principal is allowed the operation, nil principal is anonymous
+func (tp *TablePolicy) allows(principal *Principal, op string) bool {
+	roles := []string{roleAny, roleAnonymous}
+	if principal != nil {
+		roles = append([]string{roleAny, roleAuthenticated}, principal.Roles...)
+	}
+	for _, role := range roles {
+		if contains(tp.Allow[role], op) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tp *TablePolicy) limitsRows(principal *Principal) bool {
+	if tp.OwnerColumn == "" {
+		return false
+	}
+	if principal == nil {
+		return true
+	}
+	for _, role := range principal.Roles {
+		if contains(tp.AllRows, role) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(items []string, item string) bool {
+	for _, other := range items {
+		if other == item {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedError is answered with 403 and what exactly was denied
+type deniedError struct {
+	// Table is empty for requests not about tables, like /_admin ones
+	Table     string
+	Operation string
+	// Column is set when the operation is allowed, but not on the column
+	Column string
+}
+
+func (e deniedError) Error() string {
+	return "access denied"
+}
+
+func (e deniedError) response() response {
+	resp := response{"error": e.Error(), "operation": e.Operation}
+	if e.Table != "" {
+		resp["table"] = e.Table
+	}
+	if e.Column != "" {
+		resp["column"] = e.Column
+	}
+	return resp
+}
+
+type principalKey struct{}
+
+// authenticate finds the principal of the API key of the request, nil without a key
+func (db DbExplorer) authenticate(r *http.Request) (*Principal, error) {
+	if db.policy == nil {
+		return nil, nil
+	}
+	token := r.Header.Get("X-Api-Key")
+	if auth := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return nil, nil
+	}
+	principal, exists := db.policy.Tokens[token]
+	if !exists {
+		return nil, httpError{http.StatusUnauthorized, fmt.Errorf("invalid token")}
+	}
+	return principal, nil
+}
+
+func principalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// authorize answers 403 if the principal of the request is not allowed the operation on the table
+func (db DbExplorer) authorize(ctx context.Context, t *table, op string) error {
+	if db.policy == nil || db.policy.table(t.Name).allows(principalFromContext(ctx), op) {
+		return nil
+	}
+	return deniedError{Table: t.Name, Operation: op}
+}
+
+// authorizeAdmin answers 403 if the principal of the request has none of the admin roles
+func (db DbExplorer) authorizeAdmin(ctx context.Context) error {
+	if db.policy == nil {
+		return nil
+	}
+	if principal := principalFromContext(ctx); principal != nil {
+		for _, role := range principal.Roles {
+			if contains(db.policy.AdminRoles, role) {
+				return nil
+			}
+		}
+	}
+	return deniedError{Operation: opAdmin}
+}
+
+// readableTables are names of tables the principal of the request can read
+func (db DbExplorer) readableTables(ctx context.Context) []string {
+	names := []string{}
+	for _, name := range db.tableNames {
+		if db.authorize(ctx, db.tables[name], opRead) == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// view is the table as the principal of the request sees it: without hidden columns,
+// with write-only columns not returned and with rows limited by the owner column
+func (db DbExplorer) view(ctx context.Context, t *table) *table {
+	if db.policy == nil {
+		return t
+	}
+	tp := db.policy.table(t.Name)
+	v := *t
+	v.Columns = nil
+	v.columns = make(map[string]*column, len(t.Columns))
+	v.writeOnly = make(map[string]bool, len(tp.WriteOnly))
+	for _, c := range t.Columns {
+		if contains(tp.Hidden, c.Name) && !c.PrimaryKey && c.Name != tp.OwnerColumn {
+			v.hidden = append(v.hidden, c)
+			continue
+		}
+		v.Columns = append(v.Columns, c)
+		v.columns[c.Name] = c
+		if contains(tp.WriteOnly, c.Name) && !c.PrimaryKey {
+			v.writeOnly[c.Name] = true
+		}
+	}
+
+	for _, fk := range t.ForeignKeys {
+		if v.columns[fk.Column.Name] != nil && db.authorize(ctx, fk.RefTable, opRead) != nil {
+			if v.hiddenRefs == nil {
+				v.hiddenRefs = make(map[string]bool)
+			}
+			v.hiddenRefs[fk.Column.Name] = true
+		}
+	}
+
+	principal := principalFromContext(ctx)
+	if tp.limitsRows(principal) {
+		v.owner = t.columns[tp.OwnerColumn]
+		if v.owner == nil {
+			// the column is gone since the policy was loaded, without an id the view has no rows
+			v.owner = &column{Name: tp.OwnerColumn}
+			return &v
+		}
+		if principal != nil {
+			if id, err := v.owner.value(principal.ID); err == nil {
+				v.ownerID = id
+			}
+		}
+	}
+	return &v
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const paramExpand = "expand"
+
+// parseExpand returns the relations of ?expand=author,editor, their columns have to be among the fields
+func parseExpand(t *table, query url.Values, fields []*column) ([]*foreignKey, error) {
+	expand := query.Get(paramExpand)
+	if expand == "" {
+		return nil, nil
+	}
+	var relations []*foreignKey
+	for _, name := range strings.Split(expand, ",") {
+		fk := t.foreignKey(strings.TrimSpace(name))
+		if fk == nil {
+			return nil, badParam("unknown relation %s", name)
+		}
+		if !hasColumn(fields, fk.Column) {
+			return nil, badParam("field %s is needed to expand %s", fk.Column.Name, fk.Name)
+		}
+		relations = append(relations, fk)
+	}
+	return relations, nil
+}
+
+func hasColumn(columns []*column, c *column) bool {
+	for _, other := range columns {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
+
+// expand inlines referenced records under relation names, one query for every relation,
+// a null key or a record missing or not visible to the principal is null
+func (db DbExplorer) expand(ctx context.Context, records []response, relations []*foreignKey) error {
+	for _, fk := range relations {
+		if err := db.authorize(ctx, fk.RefTable, opRead); err != nil {
+			return err
+		}
+		refTable := db.view(ctx, fk.RefTable)
+		columns := refTable.readColumns()
+		// the referenced column is needed to match records even if it is not returned
+		selected := columns
+		if !hasColumn(columns, fk.RefColumn) {
+			selected = append(append([]*column{}, columns...), fk.RefColumn)
+		}
+
+		args := &queryArgs{dialect: db.dialect}
+		var placeholders []string
+		seen := make(map[string]bool)
+		for _, record := range records {
+			value := record[fk.Column.Name]
+			if value == nil || seen[fmt.Sprint(value)] {
+				continue
+			}
+			seen[fmt.Sprint(value)] = true
+			placeholders = append(placeholders, args.add(value))
+		}
+
+		refs := make(map[string]response)
+		if len(placeholders) > 0 {
+			query := "SELECT " + db.selectColumns(selected) + " FROM " + db.dialect.quoteIdent(refTable.Name) +
+				" WHERE " + db.dialect.quoteIdent(fk.RefColumn.Name) + " IN (" + strings.Join(placeholders, ", ") + ")"
+			if cond := db.ownerCond(refTable, args); cond != "" {
+				query += " AND " + cond
+			}
+			refRecords, err := db.queryRecords(db.db, selected, query, args.args...)
+			if err != nil {
+				return err
+			}
+			for _, ref := range refRecords {
+				refs[fmt.Sprint(ref[fk.RefColumn.Name])] = ref
+				if len(selected) > len(columns) {
+					delete(ref, fk.RefColumn.Name)
+				}
+			}
+		}
+
+		for _, record := range records {
+			var ref interface{}
+			if value := record[fk.Column.Name]; value != nil {
+				if found, exists := refs[fmt.Sprint(value)]; exists {
+					ref = found
+				}
+			}
+			record[fk.Name] = ref
+		}
+	}
+	return nil
+}
+
+// listReferencing lists records of the child table referencing the record, /users/1/items,
+// the usual list params work on them
+func (db DbExplorer) listReferencing(t *table, id interface{}, childName string, r *http.Request) (interface{}, error) {
+	var fk *foreignKey
+	for _, ref := range t.ReferencedBy {
+		if ref.Table.Name != childName {
+			continue
+		}
+		if fk != nil {
+			return nil, badParam("%s references %s by several keys, filter %s by one of them", childName, t.Name, childName)
+		}
+		fk = ref
+	}
+	if fk == nil {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("unknown relation")}
+	}
+	if err := db.authorize(r.Context(), fk.Table, opRead); err != nil {
+		return nil, err
+	}
+
+	refValue, err := db.refValue(t, id, fk.RefColumn)
+	if err != nil {
+		return nil, err
+	}
+	child := db.view(r.Context(), fk.Table)
+	return db.listFiltered(child, r, filter{Column: fk.Column, Operator: "eq", Values: []interface{}{refValue}})
+}
+
+// refValue returns the value of the column of the record the foreign key references, 404 if there is no record
+func (db DbExplorer) refValue(t *table, id interface{}, refColumn *column) (interface{}, error) {
+	args := &queryArgs{dialect: db.dialect}
+	query := "SELECT " + db.selectColumns([]*column{refColumn}) + " FROM " + db.dialect.quoteIdent(t.Name) +
+		db.recordWhere(t, id, args)
+	records, err := db.queryRecords(db.db, []*column{refColumn}, query, args.args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, httpError{http.StatusNotFound, fmt.Errorf("record not found")}
+	}
+	return records[0][refColumn.Name], nil
+}
+
+// checkNotReferenced answers 409 before a delete of a record of the view that a restricting foreign key would fail,
+// keys with cascades and set nulls are left to the database. The answer does not tell the referencing table nor how many
+// records there are, they can be ones the principal cant read. The check is not atomic with the delete,
+// the database is the judge and violations it reports are 409 by constraintError as well
+func (db DbExplorer) checkNotReferenced(q querier, t *table, id interface{}) error {
+	for _, fk := range t.ReferencedBy {
+		if !fk.restrictsDelete() {
+			continue
+		}
+		args := &queryArgs{dialect: db.dialect}
+		query := "SELECT COUNT(*) FROM " + db.dialect.quoteIdent(fk.Table.Name) +
+			" WHERE " + db.dialect.quoteIdent(fk.Column.Name) + " IN (SELECT " + db.dialect.quoteIdent(fk.RefColumn.Name) +
+			" FROM " + db.dialect.quoteIdent(t.Name) + db.recordWhere(t, id, args) + ")"
+		var count int64
+		if err := q.QueryRow(query, args.args...).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return httpError{http.StatusConflict, fmt.Errorf("record is referenced")}
+		}
+	}
+	return nil
+}
+
+// constraintError turns foreign key violations the database reports into 409
+func (db DbExplorer) constraintError(err error) error {
+	if db.dialect.isForeignKeyViolation(err) {
+		return httpError{http.StatusConflict, fmt.Errorf("foreign key constraint failed")}
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadPath is POST /_admin/reload, a table named _admin is not reachable by it
+const reloadPath = "_admin/reload"
+
+// schemaCache holds the current catalog, copies of the explorer share it
+type schemaCache struct {
+	// reloading serializes reloads, so an older catalog never replaces a newer one
+	reloading sync.Mutex
+	current   atomic.Value
+}
+
+func (sc *schemaCache) load() *catalog {
+	return sc.current.Load().(*catalog)
+}
+
+// ReloadSchema introspects the database again and replaces the catalog, changes are logged and returned.
+// The old catalog stays if the new one cant be loaded, entries of the policy and of concurrency settings
+// that stop fitting the tables are reported and skipped, requests that already started keep the catalog they took
+func (db DbExplorer) ReloadSchema() ([]string, error) {
+	db.schemas.reloading.Lock()
+	defer db.schemas.reloading.Unlock()
+
+	fresh, err := db.loadCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("cant reload schema: %s", err)
+	}
+	changes := diffCatalogs(db.schemas.load(), fresh)
+	db.schemas.current.Store(fresh)
+	for _, change := range changes {
+		log.Printf("db explorer: schema changed: %s", change)
+	}
+	return changes, nil
+}
+
+// RefreshSchema reloads the schema every interval until ctx is done, it is meant to be run in a goroutine
+func (db DbExplorer) RefreshSchema(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := db.ReloadSchema(); err != nil {
+				log.Printf("db explorer: %s", err)
+			}
+		}
+	}
+}
+
+// diffCatalogs describes added and dropped tables, changes of tables present in both and newly stale settings
+func diffCatalogs(old, fresh *catalog) []string {
+	changes := []string{}
+	for _, name := range fresh.tableNames {
+		if _, exists := old.tables[name]; !exists {
+			changes = append(changes, fmt.Sprintf("table %s added", name))
+		}
+	}
+	for _, name := range old.tableNames {
+		freshTable, exists := fresh.tables[name]
+		if !exists {
+			changes = append(changes, fmt.Sprintf("table %s dropped", name))
+			continue
+		}
+		changes = append(changes, diffTables(old.tables[name], freshTable)...)
+	}
+	for _, entry := range fresh.stale {
+		if !contains(old.stale, entry) {
+			changes = append(changes, entry+", ignored")
+		}
+	}
+	return changes
+}
+
+func diffTables(old, fresh *table) []string {
+	var changes []string
+	for _, c := range fresh.Columns {
+		if _, exists := old.columns[c.Name]; !exists {
+			changes = append(changes, fmt.Sprintf("column %s.%s added", fresh.Name, c.Name))
+		}
+	}
+	for _, c := range old.Columns {
+		freshColumn, exists := fresh.columns[c.Name]
+		if !exists {
+			changes = append(changes, fmt.Sprintf("column %s.%s dropped", old.Name, c.Name))
+			continue
+		}
+		if before, after := describeColumn(c), describeColumn(freshColumn); before != after {
+			changes = append(changes, fmt.Sprintf("column %s.%s changed from %s to %s", old.Name, c.Name, before, after))
+		}
+	}
+
+	oldKeys := describeForeignKeys(old)
+	freshKeys := describeForeignKeys(fresh)
+	for _, fk := range freshKeys {
+		if !contains(oldKeys, fk) {
+			changes = append(changes, fmt.Sprintf("foreign key %s.%s added", fresh.Name, fk))
+		}
+	}
+	for _, fk := range oldKeys {
+		if !contains(freshKeys, fk) {
+			changes = append(changes, fmt.Sprintf("foreign key %s.%s dropped", old.Name, fk))
+		}
+	}
+	return changes
+}
+
+func describeColumn(c *column) string {
+	description := c.Type
+	if c.Nullable {
+		description += " null"
+	} else {
+		description += " not null"
+	}
+	if c.PrimaryKey {
+		description += " primary key"
+	}
+	return description
+}
+
+func describeForeignKeys(t *table) []string {
+	var keys []string
+	for _, fk := range t.ForeignKeys {
+		keys = append(keys, fmt.Sprintf("%s -> %s.%s", fk.Column.Name, fk.RefTable.Name, fk.RefColumn.Name))
+	}
+	return keys
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// kinds of values columns keep, validation is on the level of "string - int - float - bool - null"
+const (
+	kindInt    = "int"
+	kindFloat  = "float"
+	kindBool   = "bool"
+	kindString = "string"
+)
+
+type column struct {
+	Name string
+	// type as the database reports it: int(11), varchar(255)...
+	Type          string
+	Kind          string
+	Nullable      bool
+	PrimaryKey    bool
+	AutoIncrement bool
+	// HasDefault columns are filled by the database when inserts leave them out, generated ones as well
+	HasDefault bool
+	// MaxLength of varchar and char columns, 0 if not limited
+	MaxLength int
+	// Schema validates values of the column
+	Schema *jsonSchema
+}
+
+type table struct {
+	Name    string
+	Columns []*column
+	// nil for tables without a primary key, they are only listed and inserted to
+	PrimaryKey *column
+	// ForeignKeys are the relations of ?expand=, ReferencedBy are foreign keys of other tables pointing here
+	ForeignKeys  []*foreignKey
+	ReferencedBy []*foreignKey
+	columns      map[string]*column
+
+	// optimistic locking of the Concurrency of the table: ETags are values of the version column if there is one,
+	// locked tables return them in records of lists and of creates
+	locked         bool
+	version        *column
+	requireIfMatch bool
+
+	// set on views of policies: hidden columns get only zero values of new records, write-only columns are not returned,
+	// owner limits rows to the ones of ownerID, nil ownerID matches no rows
+	hidden    []*column
+	writeOnly map[string]bool
+	owner     *column
+	ownerID   interface{}
+	// hiddenRefs are columns referencing tables the principal cant read, schemas dont name those tables
+	hiddenRefs map[string]bool
+}
+
+// foreignKey is a column referencing records of another table, keys of several columns are not supported
+type foreignKey struct {
+	// Name is the relation name of ?expand=: author_id is author, the referenced table when there is no _id
+	Name      string
+	Table     *table
+	Column    *column
+	RefTable  *table
+	RefColumn *column
+	// OnDelete is the delete rule of the database, NO ACTION if it is not reported
+	OnDelete string
+}
+
+func newTable(name string) *table {
+	return &table{
+		Name:    name,
+		columns: make(map[string]*column),
+	}
+}
+
+// addColumn appends a column the dialect has introspected, the first primary key column is the key of records
+func (t *table) addColumn(c *column) {
+	c.Kind = columnKind(c.Type)
+	if c.MaxLength == 0 {
+		c.MaxLength = columnMaxLength(c.Type)
+	}
+	t.Columns = append(t.Columns, c)
+	t.columns[c.Name] = c
+	if c.PrimaryKey && t.PrimaryKey == nil {
+		t.PrimaryKey = c
+	}
+}
+
+// linkForeignKeys resolves foreign keys the dialect has introspected, keys to unknown tables or columns are skipped
+func linkForeignKeys(tables map[string]*table, fkColumns []foreignKeyColumn) {
+	for idx, fkc := range fkColumns {
+		multiColumn := idx > 0 && fkColumns[idx-1].Table == fkc.Table && fkColumns[idx-1].Constraint == fkc.Constraint ||
+			idx+1 < len(fkColumns) && fkColumns[idx+1].Table == fkc.Table && fkColumns[idx+1].Constraint == fkc.Constraint
+		t, refTable := tables[fkc.Table], tables[fkc.RefTable]
+		if multiColumn || t == nil || refTable == nil {
+			continue
+		}
+		c := t.columns[fkc.Column]
+		refColumn := refTable.PrimaryKey
+		if fkc.RefColumn != "" {
+			refColumn = refTable.columns[fkc.RefColumn]
+		}
+		if c == nil || refColumn == nil {
+			continue
+		}
+
+		name := strings.TrimSuffix(c.Name, "_id")
+		if name == c.Name || name == "" || t.columns[name] != nil {
+			name = refTable.Name
+		}
+		onDelete := strings.ToUpper(fkc.OnDelete)
+		if onDelete == "" {
+			onDelete = "NO ACTION"
+		}
+		fk := &foreignKey{Name: name, Table: t, Column: c, RefTable: refTable, RefColumn: refColumn, OnDelete: onDelete}
+		c.Schema.References = &schemaRef{Table: refTable.Name, Column: refColumn.Name, Relation: name}
+		t.ForeignKeys = append(t.ForeignKeys, fk)
+		refTable.ReferencedBy = append(refTable.ReferencedBy, fk)
+	}
+}
+
+// primaryKeyName is empty for tables without a primary key
+func (t *table) primaryKeyName() string {
+	if t.PrimaryKey == nil {
+		return ""
+	}
+	return t.PrimaryKey.Name
+}
+
+// readColumns are the columns records are returned with
+func (t *table) readColumns() []*column {
+	if len(t.writeOnly) == 0 {
+		return t.Columns
+	}
+	columns := make([]*column, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		if !t.writeOnly[c.Name] {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// readColumn is the column to select, filter and sort by, nil if the column is unknown or write-only
+func (t *table) readColumn(name string) *column {
+	if t.writeOnly[name] {
+		return nil
+	}
+	return t.columns[name]
+}
+
+// restrictsDelete tells if the key keeps referenced records from being deleted, cascades and set nulls dont
+func (fk *foreignKey) restrictsDelete() bool {
+	switch fk.OnDelete {
+	case "CASCADE", "SET NULL", "SET DEFAULT":
+		return false
+	}
+	return true
+}
+
+func (t *table) foreignKey(name string) *foreignKey {
+	for _, fk := range t.ForeignKeys {
+		if fk.Name == name {
+			return fk
+		}
+	}
+	return nil
+}
+
+func columnKind(colType string) string {
+	colType = strings.ToLower(colType)
+	if idx := strings.IndexAny(colType, "( "); idx >= 0 {
+		colType = colType[:idx]
+	}
+	switch colType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "serial", "bigserial":
+		return kindInt
+	case "float", "double", "real", "decimal", "numeric":
+		return kindFloat
+	// mysql reports booleans as tinyint(1), they stay numbers there
+	case "boolean", "bool":
+		return kindBool
+	}
+	return kindString
+}
+
+// columnMaxLength parses the length of varchar(255) and char(10), other types are not limited
+func columnMaxLength(colType string) int {
+	colType = strings.ToLower(colType)
+	start, end := strings.Index(colType, "("), strings.Index(colType, ")")
+	if start < 0 || end < start || !strings.Contains(colType[:start], "char") {
+		return 0
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(colType[start+1 : end]))
+	if err != nil {
+		return 0
+	}
+	return length
+}
+
+// value checks a value from the request body against the schema of the column and converts it for the query
+func (c *column) value(raw interface{}) (interface{}, error) {
+	return c.Schema.value(c.Name, raw)
+}
+
+// zeroValue is written to not null columns missing in the body of a new record
+func (c *column) zeroValue() interface{} {
+	switch {
+	case c.Nullable:
+		return nil
+	case c.Kind == kindInt:
+		return int64(0)
+	case c.Kind == kindFloat:
+		return float64(0)
+	case c.Kind == kindBool:
+		return false
+	}
+	return ""
+}
+
+// scanDest returns a pointer to scan the column into, nulls are kept
+func (c *column) scanDest() interface{} {
+	switch c.Kind {
+	case kindInt:
+		return &sql.NullInt64{}
+	case kindFloat:
+		return &sql.NullFloat64{}
+	case kindBool:
+		return &sql.NullBool{}
+	}
+	return &sql.NullString{}
+}
+
+// scannedValue turns what was scanned into a value for the json response, null is nil
+func scannedValue(dest interface{}) interface{} {
+	switch v := dest.(type) {
+	case *sql.NullInt64:
+		if v.Valid {
+			return v.Int64
+		}
+	case *sql.NullFloat64:
+		if v.Valid {
+			return v.Float64
+		}
+	case *sql.NullBool:
+		if v.Valid {
+			return v.Bool
+		}
+	case *sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// exportPath is /$table/_export, importPath is /$table/_import
+	exportPath = "_export"
+	importPath = "_import"
+
+	paramFormat   = "format"
+	paramConflict = "conflict"
+
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	// csvNull is null in csv, empty strings stay empty strings,
+	// strings starting with a backslash get one more, so a string \N is \\N
+	csvNull   = `\N`
+	csvEscape = `\`
+
+	// what imports do with records whose primary key exists
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictFail      = "fail"
+
+	// maxImportErrors stops validation of imports that are obviously broken
+	maxImportErrors = 100
+)
+
+// transferFormat is the format of ?format=, or of the content type if there is no param
+func transferFormat(format, contentType string) (string, error) {
+	if format == "" {
+		switch {
+		case strings.HasPrefix(contentType, "text/csv"):
+			format = formatCSV
+		case strings.HasPrefix(contentType, "application/x-ndjson"):
+			format = formatNDJSON
+		}
+	}
+	if format != formatCSV && format != formatNDJSON {
+		return "", badParam("format must be %s or %s", formatCSV, formatNDJSON)
+	}
+	return format, nil
+}
+
+// export streams records of the table row by row, list params filter, sort and project them,
+// there is no limit unless it is given
+func (db DbExplorer) export(t *table, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	format, err := transferFormat(query.Get(paramFormat), "")
+	if err != nil {
+		return nil, err
+	}
+	params, err := parseListParams(t, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(params.Expand) > 0 {
+		return nil, badParam("expand is not supported by export")
+	}
+
+	args := &queryArgs{dialect: db.dialect}
+	sqlQuery := "SELECT " + db.selectColumns(params.Fields) + " FROM " + db.dialect.quoteIdent(t.Name) +
+		db.where(t, params.Filters, args) + db.orderBy(params.OrderBy)
+	if _, limited := query[paramLimit]; limited {
+		sqlQuery += " LIMIT " + args.add(params.Limit) + " OFFSET " + args.add(params.Offset)
+	}
+	rows, err := db.db.Query(sqlQuery, args.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return stream(func(w http.ResponseWriter) error {
+		defer rows.Close()
+		if format == formatCSV {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+t.Name+"."+format+`"`)
+		w.WriteHeader(http.StatusOK)
+
+		if format == formatCSV {
+			return writeCSV(w, rows, params.Fields)
+		}
+		return writeNDJSON(w, rows, params.Fields)
+	}), nil
+}
+
+func writeCSV(w io.Writer, rows *sql.Rows, columns []*column) error {
+	out := csv.NewWriter(w)
+	line := make([]string, len(columns))
+	for idx, c := range columns {
+		line[idx] = c.Name
+	}
+	if err := out.Write(line); err != nil {
+		return err
+	}
+	for rows.Next() {
+		record, err := scanRecord(rows, columns)
+		if err != nil {
+			return err
+		}
+		for idx, c := range columns {
+			line[idx] = csvValue(record[c.Name])
+		}
+		if err := out.Write(line); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return csvNull
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		if strings.HasPrefix(v, csvEscape) {
+			return csvEscape + v
+		}
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+func writeNDJSON(w io.Writer, rows *sql.Rows, columns []*column) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for rows.Next() {
+		record, err := scanRecord(rows, columns)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// recordReader returns records of the body one by one and io.EOF after the last one
+type recordReader func() (map[string]interface{}, error)
+
+// newRecordReader reads csv with a header of column names or ndjson,
+// values are validated against columns later as values of any other body
+func newRecordReader(format string, body io.Reader, t *table) (recordReader, error) {
+	if format == formatNDJSON {
+		decoder := json.NewDecoder(body)
+		decoder.UseNumber()
+		return func() (map[string]interface{}, error) {
+			var item interface{}
+			if err := decoder.Decode(&item); err != nil {
+				return nil, err
+			}
+			record, isObject := item.(map[string]interface{})
+			if !isObject {
+				return nil, fmt.Errorf("record must be an object")
+			}
+			return record, nil
+		}, nil
+	}
+
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return func() (map[string]interface{}, error) { return nil, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, badParam("cant parse csv header: %s", err)
+	}
+	return func() (map[string]interface{}, error) {
+		line, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]interface{}, len(header))
+		for idx, name := range header {
+			switch {
+			case line[idx] == csvNull:
+				record[name] = nil
+			case strings.HasPrefix(line[idx], csvEscape+csvEscape):
+				record[name] = line[idx][len(csvEscape):]
+			// new records of csv files leave generated keys empty
+			case line[idx] == "" && t.PrimaryKey != nil && name == t.PrimaryKey.Name && t.PrimaryKey.AutoIncrement:
+			default:
+				record[name] = line[idx]
+			}
+		}
+		return record, nil
+	}, nil
+}
+
+// importRecords inserts records of the body in one transaction, ?conflict= says what to do with existing keys,
+// nothing is written if any record is invalid or conflicts with conflict=fail
+func (db DbExplorer) importRecords(t *table, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	format, err := transferFormat(query.Get(paramFormat), r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	conflict := query.Get(paramConflict)
+	switch conflict {
+	case "":
+		conflict = conflictFail
+	case conflictSkip, conflictFail:
+	case conflictOverwrite:
+		if err := db.authorize(r.Context(), t, opUpdate); err != nil {
+			return nil, err
+		}
+		// imports have no ETags of the records they overwrite
+		if t.requireIfMatch {
+			return nil, errIfMatchRequired()
+		}
+	default:
+		return nil, badParam("conflict must be %s, %s or %s", conflictSkip, conflictOverwrite, conflictFail)
+	}
+
+	next, err := newRecordReader(format, r.Body, t)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.begin(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{"inserted": 0, "updated": 0, "skipped": 0}
+	var errs []itemError
+	status := http.StatusBadRequest
+	for idx := 0; len(errs) < maxImportErrors; idx++ {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, badParam("cant parse record %d: %s", idx, err)
+		}
+
+		op, err := db.prepareImport(t, record, conflict)
+		if _, denied := err.(deniedError); denied {
+			tx.Rollback()
+			return nil, err
+		}
+		if err != nil {
+			errs = append(errs, newItemError(idx, err))
+			continue
+		}
+		// once anything failed the rest is only validated
+		if len(errs) > 0 {
+			continue
+		}
+		outcome, err := op(tx)
+		if httpErr, ok := err.(httpError); ok {
+			errs = append(errs, newItemError(idx, err))
+			status = httpErr.Status
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("import record %d: %s", idx, err)
+		}
+		counts[outcome]++
+	}
+	if len(errs) > 0 {
+		tx.Rollback()
+		return nil, batchError{status, errs}
+	}
+	if err := db.commit(tx); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// prepareImport validates a record and returns the write of it with the conflict handling,
+// the write tells if the record was inserted, updated or skipped. Imported keys dont move postgres sequences.
+// Inserted records keep their versions, overwritten ones get theirs incremented as by any update
+func (db DbExplorer) prepareImport(t *table, record map[string]interface{}, conflict string) (func(q querier) (string, error), error) {
+	var id interface{}
+	if raw, hasKey := record[t.primaryKeyName()]; t.PrimaryKey != nil && hasKey {
+		var err error
+		id, err = t.PrimaryKey.value(raw)
+		if err != nil {
+			return nil, invalidField(t.PrimaryKey, err)
+		}
+	}
+	insert, err := db.prepareInsert(t, record, true)
+	if err != nil {
+		return nil, err
+	}
+	var update operation
+	fields := make(map[string]interface{}, len(record))
+	if id != nil && conflict == conflictOverwrite {
+		// the owner column is not written by updates, exported records have it anyway
+		for name, value := range record {
+			if name != t.PrimaryKey.Name && (t.owner == nil || name != t.owner.Name) {
+				fields[name] = value
+			}
+		}
+		update, err = db.prepareUpdate(t, id, fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(q querier) (string, error) {
+		if id != nil {
+			exists, visible, err := db.recordExists(q, t, id)
+			if err != nil {
+				return "", err
+			}
+			// records of other owners conflict whatever the mode is, the answer is the one of conflict=fail,
+			// so imports dont tell whose records are there
+			if exists && visible {
+				switch conflict {
+				case conflictSkip:
+					return "skipped", nil
+				case conflictOverwrite:
+					if _, err := update(q); err != nil {
+						return "", err
+					}
+					return "updated", nil
+				}
+			}
+			if exists {
+				return "", httpError{http.StatusConflict, fmt.Errorf("record %v already exists", id)}
+			}
+		}
+		if _, err := insert(q); err != nil {
+			return "", err
+		}
+		return "inserted", nil
+	}, nil
+}
+
+// recordExists looks for the key among all rows, not only the ones of the view, they conflict as well,
+// visible tells if the row is one of the view
+func (db DbExplorer) recordExists(q querier, t *table, id interface{}) (exists, visible bool, err error) {
+	count := func(where string, args *queryArgs) (bool, error) {
+		var n int64
+		err := q.QueryRow("SELECT COUNT(*) FROM "+db.dialect.quoteIdent(t.Name)+where, args.args...).Scan(&n)
+		return n > 0, err
+	}
+	args := &queryArgs{dialect: db.dialect}
+	exists, err = count(" WHERE "+db.dialect.quoteIdent(t.PrimaryKey.Name)+" = "+args.add(id), args)
+	if err != nil || !exists || t.owner == nil {
+		return exists, exists, err
+	}
+	args = &queryArgs{dialect: db.dialect}
+	visible, err = count(db.recordWhere(t, id, args), args)
+	return exists, visible, err
+}